@@ -2,7 +2,9 @@ package app
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
 	firebase "firebase.google.com/go"
 	"github.com/andrwkng/hudumaapp/model"
@@ -82,15 +84,86 @@ type AuthUser struct {
 	PhoneNumber   string `json:"phone_number"`
 }
 
+// AuthTokens are issued to a user after logging in.
+type AuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    string `json:"expires_at"`
+}
+
+// Authenticator identifies the user presenting an access token.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*AuthUser, error)
+}
+
+// TokenIssuer issues tokens to users who logged in with their phone number
+// and password. Only the local authenticator issues tokens; Firebase clients
+// get theirs from Firebase.
+type TokenIssuer interface {
+	IssueTokens(ctx context.Context, user *User) (*AuthTokens, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*AuthTokens, error)
+}
+
+// BookingParties identifies the users involved in a booking.
+type BookingParties struct {
+	BookingID uuid.UUID
+	// User ID of the client who made the booking.
+	ClientID string
+	// User ID of the assigned provider. Nil until a provider is assigned.
+	ProviderUserID *string
+}
+
+// BidParties identifies the users involved in a bid.
+type BidParties struct {
+	BidID     int
+	BookingID uuid.UUID
+	// User ID of the client who owns the request the bid was made on.
+	ClientID string
+	// User ID of the provider who made the bid.
+	BidderID string
+}
+
+// OwnershipService looks up who is involved with a resource so access to it
+// can be authorized.
+type OwnershipService interface {
+	FindBookingParties(context.Context, uuid.UUID) (*BookingParties, error)
+	FindBidParties(context.Context, int) (*BidParties, error)
+	IsAdmin(context.Context, string) (bool, error)
+}
+
 type BookingService interface {
 	FindBookingByID(context.Context, uuid.UUID) (*Booking, error)
 	FindProviderBookingByID(context.Context, uuid.UUID, string) (*ProviderBooking, error)
 	CreateBooking(context.Context, *model.Booking) error
 	FindMyBookings(context.Context) ([]*BookingBrief, error)
 	FindBookings(context.Context, string) ([]*BookingBrief, error)
-	InsertDate(context.Context, string) error
-	CompleteBooking(context.Context, uuid.UUID) error
-	CancelBooking(context.Context, uuid.UUID) error
+	// CompleteBooking completes a booking in progress once the client
+	// confirms it, either themselves or by giving the provider the
+	// completion code.
+	CompleteBooking(context.Context, *model.BookingCompletion) error
+	// CheckIn starts a booking when its provider arrives at its location.
+	CheckIn(context.Context, *model.BookingCheckpoint) error
+	// CheckOut records that the provider finished the job and sends the
+	// completion code to the client by SMS.
+	CheckOut(context.Context, *model.BookingCheckpoint) error
+	// FindBookingVisit returns the current visit to a booking.
+	FindBookingVisit(context.Context, uuid.UUID) (*BookingVisit, error)
+	// CancelBooking cancels a booking under its cancellation policy, taking
+	// the fee out of the payment. A client cancelling for a fee must accept
+	// it first.
+	CancelBooking(context.Context, *model.BookingCancellation) (*CancellationDecision, error)
+	// QuoteCancellation returns what cancelling a booking now would cost its
+	// client, without cancelling it.
+	QuoteCancellation(context.Context, *model.BookingCancellation) (*CancellationDecision, error)
+	TransitionBooking(context.Context, *model.BookingTransition) error
+	ListBookingEvents(context.Context, uuid.UUID) ([]*BookingEvent, error)
+	// ProposeReschedule proposes moving a booking to another time, countering
+	// the other party's pending proposal if there is one.
+	ProposeReschedule(context.Context, *model.RescheduleProposal) error
+	// DecideReschedule accepts or declines the other party's pending proposal
+	// to move a booking.
+	DecideReschedule(context.Context, *model.RescheduleDecision) error
+	ListReschedules(context.Context, uuid.UUID) ([]*BookingReschedule, error)
 }
 
 type CategoryService interface {
@@ -99,6 +172,9 @@ type CategoryService interface {
 	ListRootCategories(context.Context) ([]RootCategory, error)
 	ListCategoriesByParentID(context.Context, string) ([]*Category, error)
 	ListCategoriesByIndustryID(context.Context, string) ([]*Category, error)
+	// SuggestCategories returns the categories matching the words of the
+	// title of a request, best match first.
+	SuggestCategories(ctx context.Context, title string) ([]*Category, error)
 }
 
 type IndustryService interface {
@@ -113,7 +189,7 @@ type UserService interface {
 	FindUserByUsername(context.Context, string) (*User, error)
 	FindUserByPhoneNumber(context.Context, string) (*User, error)
 	// Password
-	ValidateUser(context.Context, string, string, bool) error
+	ValidateUser(context.Context, string, string, bool) (*User, error)
 	ResetUserPassword(context.Context, string, string) error
 	ChangeUserPassword(context.Context, *model.PwdChange) error
 	// Provider
@@ -134,6 +210,27 @@ type UserService interface {
 	ListServicesByProviderID(context.Context, string) ([]*Service, error)
 }
 
+// Purposes a verification code can be issued for. A code is only accepted for
+// the purpose it was issued for.
+const (
+	VerifyPhonePurpose         = "verify_phone"
+	ResetPasswordPurpose       = "reset_password"
+	VerifyPaymentMethodPurpose = "verify_payment_method"
+)
+
+// SMSSender sends text messages.
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone string, message string) error
+}
+
+// VerificationService issues one-time codes sent to a phone number and uses
+// them to prove ownership of it.
+type VerificationService interface {
+	SendCode(ctx context.Context, phone string, purpose string) error
+	VerifyPhone(ctx context.Context, phone string, code string) error
+	ResetPassword(ctx context.Context, reset *model.PasswordReset) error
+}
+
 type ClientService interface {
 	FindClientByID(context.Context, uuid.UUID) (*Client, error)
 	FindClients(context.Context) ([]*Client, error)
@@ -158,14 +255,70 @@ type RequestService interface {
 	FilterRequests(context.Context, model.RequestFilter) ([]Request, error)
 	AllRequests(context.Context, model.RequestFilter) ([]AllRequest, error)
 	ListRequestsCategories(context.Context) ([]Category, error)
+	// UpdateRequest edits a request until a bid on it is accepted.
+	UpdateRequest(context.Context, *model.Request) error
+	// CancelRequest cancels a request under its cancellation policy, like
+	// CancelBooking, and notifies the providers who bid on it.
+	CancelRequest(context.Context, *model.BookingCancellation) (*CancellationDecision, error)
+	// ReopenRequest opens a request for bidding again after the provider
+	// whose bid was accepted cancelled it.
+	ReopenRequest(ctx context.Context, id uuid.UUID, actorID string) error
+	// ListRequestCandidates returns the requests open for bidding which the
+	// user, a provider, has not bid on, with what is known about the user.
+	ListRequestCandidates(ctx context.Context, userID string) (*ProviderProfile, []*RequestCandidate, error)
+}
+
+// AvailabilityService keeps the calendars of providers: the hours they work
+// each week, exceptions to them and blackout days. Bookings of a service must
+// fit in the hours of its provider and not overlap their other bookings.
+type AvailabilityService interface {
+	FindAvailability(ctx context.Context, userID string) (*Availability, error)
+	// SetWorkingHours replaces the weekly working hours of a provider.
+	SetWorkingHours(context.Context, *model.WeeklyHours) error
+	CreateAvailabilityException(context.Context, *model.AvailabilityException) error
+	DeleteAvailabilityException(ctx context.Context, id string, userID string) error
+	CreateBlackout(context.Context, *model.Blackout) error
+	DeleteBlackout(ctx context.Context, id string, userID string) error
+	// ListFreeSlots lists the times a service of a provider can be booked at.
+	ListFreeSlots(context.Context, *model.SlotFilter) ([]*Slot, error)
+}
+
+// SeriesService manages recurring bookings. Their bookings are made ahead of
+// time like any other booking, and an occurrence that cannot be booked fails.
+type SeriesService interface {
+	CreateSeries(context.Context, *model.BookingSeries) error
+	FindSeriesByID(ctx context.Context, id string, userID string) (*BookingSeries, error)
+	// ListSeries lists the series of a user as client or provider.
+	ListSeries(ctx context.Context, userID string) ([]*BookingSeries, error)
+	// SkipOccurrence skips the occurrence of a series on a date, cancelling
+	// its booking if it was made.
+	SkipOccurrence(ctx context.Context, id string, date string, userID string) error
+	// CancelSeries stops a series and cancels its bookings that have not
+	// started.
+	CancelSeries(ctx context.Context, id string, userID string, reason *string) error
+	// GenerateSeriesBookings makes the bookings of active series that are
+	// due soon and returns how many occurrences it handled.
+	GenerateSeriesBookings(ctx context.Context) (int, error)
 }
 
+// RecommendationService ranks the requests open for bidding for a provider.
+type RecommendationService interface {
+	RecommendRequests(ctx context.Context, userID string) ([]*RecommendedRequest, error)
+}
+
+// BidService manages the bids of providers on requests. A provider holds at
+// most one active bid per request, which they may revise or withdraw while
+// the request is open for bidding.
 type BidService interface {
 	ListMyBids(context.Context, string) ([]*Bid, error)
 	FindBidsByBookingID(context.Context, string) ([]*Bid, error)
 	FindBidsByRequestID(context.Context, string, string) ([]*Bid, error)
 	CreateBid(context.Context, *model.Bid) error
-	AcceptBid(context.Context, int) error
+	UpdateBid(context.Context, *model.BidUpdate) error
+	WithdrawBid(context.Context, int) error
+	// AcceptBid accepts a bid on behalf of the actor, rejects the other
+	// active bids on the request and closes bidding.
+	AcceptBid(context.Context, int, string) error
 }
 
 type LocationService interface {
@@ -182,9 +335,227 @@ type SearchService interface {
 
 type PlanService interface {
 	CreatePlan(context.Context, *model.Plan) error
-	GetAllPlans(context.Context) ([]PlanService, error)
+	UpdatePlan(context.Context, *model.Plan) error
+	ArchivePlan(context.Context, string) error
+	FindPlanByID(context.Context, string) (*Plan, error)
+	// ListPlans returns the active plans, or all plans if the flag is set.
+	ListPlans(context.Context, bool) ([]*Plan, error)
 }
 
+// SubscriptionService runs the lifecycle of client subscriptions to plans.
+// Payments are taken elsewhere and reported through ActivateSubscription,
+// RenewSubscription and MarkSubscriptionPastDue.
 type SubscriptionService interface {
 	CreateSubscription(context.Context, *model.Subscription) error
+	// FindSubscriptionByID returns a subscription of the client.
+	FindSubscriptionByID(ctx context.Context, id string, clientID string) (*Subscription, error)
+	// FindActiveSubscription returns the active or past due subscription of
+	// the client.
+	FindActiveSubscription(ctx context.Context, clientID string) (*Subscription, error)
+	ListSubscriptions(ctx context.Context, clientID string) ([]*Subscription, error)
+	ActivateSubscription(ctx context.Context, id string, paymentID string) error
+	RenewSubscription(ctx context.Context, id string, paymentID string) error
+	MarkSubscriptionPastDue(ctx context.Context, id string) error
+	// CancelSubscription ends a subscription of the client now, or at the end
+	// of the period already paid for.
+	CancelSubscription(ctx context.Context, id string, clientID string, immediately bool) error
+	// ExpireSubscriptions expires subscriptions whose end has passed and
+	// returns how many were expired.
+	ExpireSubscriptions(context.Context) (int, error)
+	// ClaimDueSubscriptions leases up to limit subscriptions due for renewal
+	// to owner, so no other instance charges them until the lease runs out.
+	// Renewing or recording a failed renewal releases the lease.
+	ClaimDueSubscriptions(ctx context.Context, owner string, lease time.Duration, limit int) ([]*Subscription, error)
+	// RecordFailedRenewal marks a subscription past due until retryAt, or
+	// expires it when retryAt is nil.
+	RecordFailedRenewal(ctx context.Context, id string, retryAt *time.Time) error
+	// RecordPendingRenewal releases the billing lease of a subscription whose
+	// charge is waiting for the client to pay, until checkAt.
+	RecordPendingRenewal(ctx context.Context, id string, checkAt time.Time) error
+}
+
+// Types of payment methods.
+const (
+	MobilePaymentMethod = "mobile"
+	CardPaymentMethod   = "card"
+)
+
+// PaymentMethodService stores the payment methods of users. M-Pesa numbers
+// other than the verified phone number of the account must be verified with
+// a code sent to them before they can be used. Cards are stored as a token
+// issued by the card processor, which has already verified them.
+type PaymentMethodService interface {
+	AddPaymentMethod(context.Context, *model.PaymentMethod) error
+	FindPaymentMethodByID(ctx context.Context, id string, userID string) (*PaymentMethod, error)
+	// ListPaymentMethods returns the payment methods of the user, the default
+	// one first.
+	ListPaymentMethods(ctx context.Context, userID string) ([]*PaymentMethod, error)
+	// SetDefaultPaymentMethod makes a verified payment method the default.
+	SetDefaultPaymentMethod(ctx context.Context, id string, userID string) error
+	// SendPaymentMethodCode sends a new verification code to an unverified
+	// M-Pesa number.
+	SendPaymentMethodCode(ctx context.Context, id string, userID string) error
+	VerifyPaymentMethod(ctx context.Context, id string, userID string, code string) error
+	// DeletePaymentMethod fails with a conflict while a subscription that is
+	// not cancelled or expired pays with the method.
+	DeletePaymentMethod(ctx context.Context, id string, userID string) error
+}
+
+// Payment methods of transactions.
+const (
+	MpesaSTKMethod = "mpesa_stk"
+	MpesaC2BMethod = "mpesa_c2b"
+	MpesaB2CMethod = "mpesa_b2c"
+)
+
+// Transaction statuses. A transaction is pending from the moment a payment is
+// requested until the payment provider reports the outcome.
+const (
+	TransactionPending   = "pending"
+	TransactionCompleted = "completed"
+	TransactionFailed    = "failed"
+)
+
+// Directions of transactions, from the point of view of the user.
+const (
+	TransactionDebit  = "debit"
+	TransactionCredit = "credit"
+)
+
+// TransactionService is the ledger of money paid by and to users.
+type TransactionService interface {
+	CreateTransaction(context.Context, *model.Transaction) error
+	// FindTransactionByID returns the transaction if it belongs to the user.
+	FindTransactionByID(ctx context.Context, id string, userID string) (*Transaction, error)
+	// ListTransactions returns the matching transactions, newest first.
+	ListTransactions(context.Context, model.TransactionFilter) ([]*Transaction, error)
+}
+
+// PaymentRequest is a payment the customer has been asked to make.
+type PaymentRequest struct {
+	TransactionID string `json:"transaction_id"`
+	// AccountReference identifies the payment when paying to the paybill
+	// directly instead of through the prompt on the phone.
+	AccountReference string `json:"account_reference"`
+	Amount           int    `json:"amount"`
+	Currency         string `json:"currency"`
+	Phone            string `json:"phone"`
+	Status           string `json:"status"`
+	Message          string `json:"message"`
+}
+
+// PaymentGateway asks a customer to pay from their phone. The outcome is
+// reported asynchronously to a callback; the returned reference identifies
+// the request in that callback.
+type PaymentGateway interface {
+	RequestPayment(ctx context.Context, phone string, amount int, accountReference string, description string) (reference string, err error)
+}
+
+// PaymentService requests payments and records their outcome as reported by
+// the payment provider. Completed payments activate or renew the subscription,
+// or mark the booking, they were requested for as paid. Callbacks are
+// idempotent: a payment reported more than once is only recorded once.
+type PaymentService interface {
+	RequestSubscriptionPayment(ctx context.Context, subscriptionID string, clientID string, phone string) (*PaymentRequest, error)
+	// RequestBookingPayment asks the client to pay the escrow of a booking.
+	RequestBookingPayment(ctx context.Context, bookingID string, clientID string, phone string) (*PaymentRequest, error)
+	// CompleteSTKPayment records the outcome of an STK push.
+	CompleteSTKPayment(context.Context, *model.STKResult) error
+	// ValidateC2BPayment returns an error if a payment to the paybill should
+	// be turned down because its account reference or amount is wrong.
+	ValidateC2BPayment(context.Context, *model.C2BPayment) error
+	// ConfirmC2BPayment records a payment made to the paybill.
+	ConfirmC2BPayment(context.Context, *model.C2BPayment) error
+}
+
+// SystemActor is the actor of booking changes made by the application
+// rather than a user.
+const SystemActor = "system"
+
+// PayoutGateway sends money to a phone number. The outcome is reported
+// asynchronously; the returned reference identifies the payout in that
+// report.
+type PayoutGateway interface {
+	SendPayout(ctx context.Context, phone string, amount int, remarks string) (reference string, err error)
 }
+
+// EscrowService holds the payments of bookings in escrow. Escrows follow the
+// booking: accepting it opens one for the accepted bid or the service price,
+// completing it releases the funds to the provider's earnings and cancelling
+// it refunds the client.
+type EscrowService interface {
+	FindEscrowByBookingID(context.Context, uuid.UUID) (*Escrow, error)
+	// CompleteStartedBookings completes bookings that have been in progress
+	// for longer than window without being completed or disputed, which
+	// releases their funds. It returns how many were completed.
+	CompleteStartedBookings(ctx context.Context, window time.Duration) (int, error)
+}
+
+// EarningsMethod is the method of ledger entries crediting released escrow
+// funds to the earnings of a provider.
+const EarningsMethod = "earnings"
+
+// WalletService keeps the earnings of providers and pays out withdrawals and
+// refunds. Balances are derived from the transaction ledger: earnings are
+// credited when an escrow is released and withdrawals are debited when they
+// are requested, and credited back if they fail.
+type WalletService interface {
+	FindWallet(ctx context.Context, userID string) (*Wallet, error)
+	// RequestPayout withdraws earnings to an M-Pesa number. The amount must
+	// be at least the minimum payout and at most the available balance.
+	RequestPayout(context.Context, *model.PayoutRequest) error
+	FindPayoutByID(ctx context.Context, id string, userID string) (*Payout, error)
+	ListPayouts(ctx context.Context, userID string) ([]*Payout, error)
+
+	// ClaimPayouts leases up to limit requested payouts to owner. Payouts
+	// that are not recorded as sent before their lease runs out are claimed
+	// again.
+	ClaimPayouts(ctx context.Context, owner string, lease time.Duration, limit int) ([]*Payout, error)
+	// RecordPayout records that a payout was sent.
+	RecordPayout(ctx context.Context, payoutID string, reference string) error
+	// CompletePayout records the outcome of a payout reported by the payout
+	// gateway. Outcomes reported more than once are only recorded once.
+	CompletePayout(context.Context, *model.PayoutResult) error
+
+	// ListCommissionRates lists the default commission rate and the rates of
+	// categories and industries. The rate of a booking is that of its
+	// category, else of the category's industry, else the default.
+	ListCommissionRates(context.Context) ([]*CommissionRate, error)
+	SetCommissionRate(context.Context, *model.CommissionRate) error
+}
+
+// CancellationPolicyService keeps the cancellation policies of providers and
+// the platform. The policy of a booking is that of its provider, else of its
+// category, else the platform default.
+type CancellationPolicyService interface {
+	FindProviderCancellationPolicy(ctx context.Context, userID string) (*CancellationPolicy, error)
+	SetProviderCancellationPolicy(context.Context, *model.CancellationPolicy) error
+	DeleteProviderCancellationPolicy(ctx context.Context, userID string) error
+
+	// ListCancellationPolicies lists the default policy and the policies of
+	// categories.
+	ListCancellationPolicies(context.Context) ([]*CancellationPolicy, error)
+	// SetCancellationPolicy creates or updates the policy of a category, or
+	// the default policy when no category is given.
+	SetCancellationPolicy(context.Context, *model.CancellationPolicy) error
+}
+
+// InvoiceService gives users the invoices and receipts of their charges.
+// They are issued as bookings complete and payments arrive, so there is
+// nothing to create by hand.
+type InvoiceService interface {
+	FindInvoiceByID(ctx context.Context, id string, userID string) (*Invoice, error)
+	ListInvoices(ctx context.Context, userID string) ([]*Invoice, error)
+}
+
+// SubscriptionCharger takes the payment for the next period of a
+// subscription and returns the ID of the payment.
+type SubscriptionCharger interface {
+	ChargeSubscription(context.Context, *Subscription) (string, error)
+}
+
+// ErrChargePending is returned by a SubscriptionCharger while the client has
+// not yet paid, such as when they still have to approve an M-Pesa prompt on
+// their phone. The payment renews the subscription once it is recorded;
+// until then the charge is checked again from time to time.
+var ErrChargePending = errors.New("charge pending")
@@ -0,0 +1,54 @@
+// Package auth implements app.Authenticator on top of Firebase and on top of
+// locally issued JWTs.
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	firebase "firebase.google.com/go"
+	fbauth "firebase.google.com/go/auth"
+	app "github.com/andrwkng/hudumaapp"
+	"google.golang.org/api/option"
+)
+
+// FirebaseAuthenticator verifies Firebase ID tokens.
+type FirebaseAuthenticator struct {
+	client *fbauth.Client
+}
+
+// NewFirebaseAuthenticator initializes the Firebase app from a service account
+// key file. The client is created once and shared by all requests.
+func NewFirebaseAuthenticator(ctx context.Context, credentialsFile string) (*FirebaseAuthenticator, error) {
+	fbApp, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create firebase app: %w", err)
+	}
+
+	client, err := fbApp.Auth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create firebase auth client: %w", err)
+	}
+	return &FirebaseAuthenticator{client: client}, nil
+}
+
+func (a *FirebaseAuthenticator) Authenticate(ctx context.Context, token string) (*app.AuthUser, error) {
+	idToken, err := a.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, app.Errorf(app.UNAUTHORIZED_ERR, "Invalid authorization token.")
+	}
+
+	user := &app.AuthUser{
+		ID:       idToken.UID,
+		Provider: idToken.Firebase.SignInProvider,
+	}
+	// Users who signed in with something other than their phone have no
+	// phone number claim.
+	if phone, ok := idToken.Claims["phone_number"].(string); ok {
+		user.PhoneNumber = phone
+	}
+	if verified, ok := idToken.Claims["email_verified"].(bool); ok {
+		user.EmailVerified = verified
+	}
+	return user, nil
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Token types. The type is stored in every token so a refresh token cannot be
+// used as an access token and vice versa.
+const (
+	accessToken  = "access"
+	refreshToken = "refresh"
+)
+
+// MinKeySize is the minimum length of the key used to sign tokens.
+const MinKeySize = 32
+
+// signInProvider is reported as the provider of locally authenticated users.
+const signInProvider = "password"
+
+type claims struct {
+	jwt.RegisteredClaims
+	PhoneNumber string `json:"phone_number,omitempty"`
+	TokenType   string `json:"token_type"`
+}
+
+// JWTAuthenticator issues and verifies HS256 signed JWTs. It needs no external
+// service, so the app can be developed and tested offline.
+type JWTAuthenticator struct {
+	key                  []byte
+	accessTokenDuration  time.Duration
+	refreshTokenDuration time.Duration
+}
+
+func NewJWTAuthenticator(key string, accessTokenDuration, refreshTokenDuration time.Duration) (*JWTAuthenticator, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("token symmetric key must be at least %d characters", MinKeySize)
+	}
+	if accessTokenDuration <= 0 || refreshTokenDuration <= 0 {
+		return nil, fmt.Errorf("token durations must be positive")
+	}
+	return &JWTAuthenticator{
+		key:                  []byte(key),
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+	}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*app.AuthUser, error) {
+	c, err := a.parse(token, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &app.AuthUser{
+		ID:          c.Subject,
+		PhoneNumber: c.PhoneNumber,
+		Provider:    signInProvider,
+	}, nil
+}
+
+func (a *JWTAuthenticator) IssueTokens(ctx context.Context, user *app.User) (*app.AuthTokens, error) {
+	var phone string
+	if user.Phone != nil {
+		phone = *user.Phone
+	}
+	return a.issue(user.UserID, phone)
+}
+
+func (a *JWTAuthenticator) RefreshTokens(ctx context.Context, token string) (*app.AuthTokens, error) {
+	c, err := a.parse(token, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return a.issue(c.Subject, c.PhoneNumber)
+}
+
+// issue signs a new access and refresh token pair for the user.
+func (a *JWTAuthenticator) issue(userID, phone string) (*app.AuthTokens, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	accessExpiresAt := now.Add(a.accessTokenDuration)
+
+	access, err := a.sign(userID, phone, accessToken, now, accessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := a.sign(userID, phone, refreshToken, now, now.Add(a.refreshTokenDuration))
+	if err != nil {
+		return nil, err
+	}
+
+	return &app.AuthTokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresAt:    accessExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (a *JWTAuthenticator) sign(userID, phone, tokenType string, issuedAt, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		PhoneNumber: phone,
+		TokenType:   tokenType,
+	})
+	return token.SignedString(a.key)
+}
+
+// parse verifies the signature and expiry of a token and checks its type.
+func (a *JWTAuthenticator) parse(token string, tokenType string) (*claims, error) {
+	var c claims
+	if _, err := jwt.ParseWithClaims(token, &c, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.key, nil
+	}); err != nil {
+		return nil, app.Errorf(app.UNAUTHORIZED_ERR, "Invalid authorization token.")
+	}
+
+	if c.TokenType != tokenType || c.Subject == "" {
+		return nil, app.Errorf(app.UNAUTHORIZED_ERR, "Invalid authorization token.")
+	}
+	return &c, nil
+}
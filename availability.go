@@ -0,0 +1,172 @@
+package app
+
+import (
+	"sort"
+	"time"
+
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+// Bookings start at wall clock times, without a time zone. Working hours and
+// dates use the same clock.
+const (
+	DateFormat     = "2006-01-02"
+	DateTimeFormat = "2006-01-02 15:04:05"
+)
+
+// ParseDateTime parses a wall clock time in DateTimeFormat, or with a T
+// between the date and the time.
+func ParseDateTime(s string) (time.Time, error) {
+	t, err := time.Parse(DateTimeFormat, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05", s)
+	}
+	return t, err
+}
+
+// SlotStep is how far apart the free slots offered for a service start.
+const SlotStep = 30 * time.Minute
+
+// DefaultServiceDuration is how long a service takes, in minutes, unless its
+// provider says otherwise.
+const DefaultServiceDuration = 60
+
+// BusyBookingStatuses are the statuses of bookings that take up the time of
+// their provider.
+var BusyBookingStatuses = []string{BookingRequested, BookingAccepted, BookingInProgress}
+
+// WorkingHours are hours a provider works on a day of the week. Weekday 0 is
+// Sunday. Times are like 08:30, and 24:00 is the end of the day.
+type WorkingHours struct {
+	Weekday   int    `json:"weekday"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// AvailabilityException replaces the working hours of a provider on a date.
+// A date may have several exceptions.
+type AvailabilityException struct {
+	ID        string `json:"exception_id"`
+	Date      string `json:"date"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// Blackout is a run of days, from StartDate to EndDate inclusive, on which a
+// provider does not work.
+type Blackout struct {
+	ID        string  `json:"blackout_id"`
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date"`
+	Reason    *string `json:"reason"`
+}
+
+// Availability is when a provider works.
+type Availability struct {
+	WorkingHours []*WorkingHours          `json:"working_hours"`
+	Exceptions   []*AvailabilityException `json:"exceptions"`
+	Blackouts    []*Blackout              `json:"blackouts"`
+}
+
+// Slot is a free time to book a service at.
+type Slot struct {
+	StartAt string `json:"start_at"`
+	EndAt   string `json:"end_at"`
+}
+
+// Interval is the time from Start up to End.
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (i Interval) overlaps(o Interval) bool {
+	return i.Start.Before(o.End) && o.Start.Before(i.End)
+}
+
+// IsSet reports whether the provider has published working hours. Providers
+// who have not are not held to them.
+func (a *Availability) IsSet() bool {
+	return len(a.WorkingHours) > 0
+}
+
+// OpenIntervals returns the times a provider works on the day of date: none
+// on blackout days, the exceptions of the date if it has any, and otherwise
+// the working hours of its weekday.
+func (a *Availability) OpenIntervals(date time.Time) []Interval {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	d := day.Format(DateFormat)
+
+	for _, b := range a.Blackouts {
+		if b.StartDate <= d && d <= b.EndDate {
+			return nil
+		}
+	}
+
+	var intervals []Interval
+	add := func(start, end string) {
+		from, err1 := model.ParseClock(start)
+		to, err2 := model.ParseClock(end)
+		if err1 == nil && err2 == nil && from < to {
+			intervals = append(intervals, Interval{
+				Start: day.Add(time.Duration(from) * time.Minute),
+				End:   day.Add(time.Duration(to) * time.Minute),
+			})
+		}
+	}
+	for _, e := range a.Exceptions {
+		if e.Date == d {
+			add(e.StartTime, e.EndTime)
+		}
+	}
+	if intervals == nil {
+		for _, h := range a.WorkingHours {
+			if h.Weekday == int(day.Weekday()) {
+				add(h.StartTime, h.EndTime)
+			}
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start.Before(intervals[j].Start) })
+	return intervals
+}
+
+// Covers reports whether the provider works for the whole of an interval.
+// It must fit in one of their working intervals of the day it starts on.
+func (a *Availability) Covers(i Interval) bool {
+	for _, open := range a.OpenIntervals(i.Start) {
+		if !i.Start.Before(open.Start) && !i.End.After(open.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// FreeSlots returns the slots of the given length, starting every SlotStep
+// from the start of each working interval, on the days from the day of from
+// to the day of to. Slots starting before notBefore or overlapping a busy
+// interval are left out.
+func (a *Availability) FreeSlots(from, to time.Time, length time.Duration, notBefore time.Time, busy []Interval) []*Slot {
+	slots := []*Slot{}
+	last := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC); !day.After(last); day = day.AddDate(0, 0, 1) {
+		for _, open := range a.OpenIntervals(day) {
+		slot:
+			for start := open.Start; !start.Add(length).After(open.End); start = start.Add(SlotStep) {
+				if start.Before(notBefore) {
+					continue
+				}
+				candidate := Interval{start, start.Add(length)}
+				for _, b := range busy {
+					if candidate.overlaps(b) {
+						continue slot
+					}
+				}
+				slots = append(slots, &Slot{
+					StartAt: candidate.Start.Format(DateTimeFormat),
+					EndAt:   candidate.End.Format(DateTimeFormat),
+				})
+			}
+		}
+	}
+	return slots
+}
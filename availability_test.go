@@ -0,0 +1,52 @@
+package app_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+// monday is a Monday.
+var monday = time.Date(2023, 3, 6, 0, 0, 0, 0, time.UTC)
+
+func at(day time.Time, clock string) time.Time {
+	t, _ := time.Parse("15:04", clock)
+	return day.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+}
+
+func TestFreeSlots(t *testing.T) {
+	tuesday := monday.AddDate(0, 0, 1)
+	wednesday := monday.AddDate(0, 0, 2)
+	availability := &app.Availability{
+		WorkingHours: []*app.WorkingHours{
+			{Weekday: 1, StartTime: "08:00", EndTime: "10:00"},
+			{Weekday: 2, StartTime: "08:00", EndTime: "10:00"},
+			{Weekday: 3, StartTime: "08:00", EndTime: "10:00"},
+		},
+		Exceptions: []*app.AvailabilityException{
+			{Date: "2023-03-07", StartTime: "14:00", EndTime: "15:00"},
+		},
+		Blackouts: []*app.Blackout{
+			{StartDate: "2023-03-08", EndDate: "2023-03-08"},
+		},
+	}
+	busy := []app.Interval{{Start: at(monday, "08:30"), End: at(monday, "09:00")}}
+
+	var got []string
+	for _, slot := range availability.FreeSlots(monday, wednesday, time.Hour, at(monday, "08:15"), busy) {
+		got = append(got, slot.StartAt)
+	}
+	want := []string{"2023-03-06 09:00:00", "2023-03-07 14:00:00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("slots=%v, want %v", got, want)
+	}
+
+	if availability.Covers(app.Interval{Start: at(tuesday, "08:00"), End: at(tuesday, "09:00")}) {
+		t.Error("covers working hours replaced by an exception")
+	}
+	if !availability.Covers(app.Interval{Start: at(monday, "08:00"), End: at(monday, "10:00")}) {
+		t.Error("does not cover the working hours")
+	}
+}
@@ -0,0 +1,139 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/google/uuid"
+)
+
+// DefaultReleaseWindow is how long a booking stays in progress before it is
+// completed automatically and its funds released.
+const DefaultReleaseWindow = 72 * time.Hour
+
+// PayoutScheduler periodically completes bookings left in progress past the
+// release window and sends requested payouts: withdrawals of earnings and
+// refunds. Like Scheduler, several instances may run against the same
+// database.
+type PayoutScheduler struct {
+	Escrows app.EscrowService
+	Wallets app.WalletService
+	// Gateway sends the payouts. Without one, bookings are still completed
+	// but payouts wait to be sent.
+	Gateway app.PayoutGateway
+
+	// Name identifies the scheduler in payout leases.
+	Name     string
+	Interval time.Duration
+	// Lease is how long a claimed payout is kept from other schedulers. A
+	// payout that could not be sent is retried once its lease runs out.
+	Lease     time.Duration
+	BatchSize int
+	// ReleaseWindow is how long after a booking went in progress it is
+	// completed if nobody completed or disputed it. Zero turns this off.
+	ReleaseWindow time.Duration
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func NewPayoutScheduler(escrows app.EscrowService, wallets app.WalletService, gateway app.PayoutGateway) *PayoutScheduler {
+	return &PayoutScheduler{
+		Escrows:       escrows,
+		Wallets:       wallets,
+		Gateway:       gateway,
+		Name:          uuid.NewString(),
+		Interval:      DefaultInterval,
+		Lease:         DefaultLease,
+		BatchSize:     DefaultBatchSize,
+		ReleaseWindow: DefaultReleaseWindow,
+	}
+}
+
+// Open starts running the scheduler in the background.
+func (s *PayoutScheduler) Open() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop()
+	}()
+}
+
+// Close stops the scheduler and waits for the current run to finish.
+func (s *PayoutScheduler) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *PayoutScheduler) loop() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Run(s.ctx); err != nil && s.ctx.Err() == nil {
+			log.Printf("billing: payout run failed: %s", err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run completes overdue bookings and sends the waiting payouts once.
+func (s *PayoutScheduler) Run(ctx context.Context) error {
+	if s.ReleaseWindow > 0 {
+		completed, err := s.Escrows.CompleteStartedBookings(ctx, s.ReleaseWindow)
+		if err != nil {
+			return err
+		}
+		if completed > 0 {
+			log.Printf("billing: completed %d bookings after the release window", completed)
+		}
+	}
+
+	if s.Gateway == nil {
+		return nil
+	}
+
+	for {
+		payouts, err := s.Wallets.ClaimPayouts(ctx, s.Name, s.Lease, s.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, payout := range payouts {
+			if err := s.pay(ctx, payout); err != nil {
+				return err
+			}
+		}
+		if len(payouts) < s.BatchSize {
+			return nil
+		}
+	}
+}
+
+// pay sends a payout and records it. Only errors recording the payout are
+// returned; a payout that could not be sent is left to be claimed again.
+func (s *PayoutScheduler) pay(ctx context.Context, payout *app.Payout) error {
+	remarks := "HudumaApp payout"
+	if payout.Description != nil {
+		remarks = *payout.Description
+	}
+
+	reference, err := s.Gateway.SendPayout(ctx, payout.Phone, payout.Amount, remarks)
+	if err != nil {
+		log.Printf("billing: sending payout %s failed: %s", payout.PayoutID, err)
+		return nil
+	}
+	return s.Wallets.RecordPayout(ctx, payout.PayoutID, reference)
+}
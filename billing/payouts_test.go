@@ -0,0 +1,219 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/billing"
+	"github.com/andrwkng/hudumaapp/database/sqlite"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/payments/fake"
+	"github.com/google/uuid"
+)
+
+const (
+	providerUserID = "BEfKrgwHuFWH5zA9H9vDEhBPc0o2"
+	requestID      = "8396dbac-7f84-46d8-b092-afc1c0640f28"
+)
+
+// paymentGateway accepts every payment request.
+type paymentGateway struct{}
+
+func (paymentGateway) RequestPayment(ctx context.Context, phone string, amount int, accountReference string, description string) (string, error) {
+	return "checkout-" + accountReference, nil
+}
+
+// setupEscrow accepts a bid of 500 on a seeded request, pays it and starts
+// the job.
+func setupEscrow(t *testing.T) (*clock, *sqlite.EscrowService, *sqlite.WalletService) {
+	t.Helper()
+
+	// The seeded request starts on 13 December 2021, and the bid must be
+	// accepted before then.
+	clk := &clock{now: time.Date(2021, 12, 1, 9, 0, 0, 0, time.UTC)}
+	db := sqlite.NewDB(":memory:")
+	db.Now = clk.Now
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Seed(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bids := sqlite.NewBidService(db)
+	if err := bids.CreateBid(ctx, &model.Bid{BookingID: requestID, BidderID: providerUserID, Amount: "500"}); err != nil {
+		t.Fatal(err)
+	}
+	placed, err := bids.FindBidsByBookingID(ctx, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bids.AcceptBid(ctx, placed[len(placed)-1].ID, clientID); err != nil {
+		t.Fatal(err)
+	}
+
+	payments := sqlite.NewPaymentService(db, paymentGateway{})
+	request, err := payments.RequestBookingPayment(ctx, requestID, clientID, "+254123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Amount != 500 {
+		t.Fatalf("requested %d, want the accepted bid of 500", request.Amount)
+	}
+	if err := payments.CompleteSTKPayment(ctx, &model.STKResult{
+		CheckoutRequestID: "checkout-" + request.AccountReference,
+		Amount:            500,
+		Receipt:           "RCPT1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bookings := sqlite.NewBookingService(db, nil)
+	if err := bookings.TransitionBooking(ctx, &model.BookingTransition{
+		BookingID: uuid.MustParse(requestID),
+		Status:    app.BookingInProgress,
+		ActorID:   providerUserID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	escrows := sqlite.NewEscrowService(db)
+	assertEscrow(t, escrows, app.EscrowHeld)
+	return clk, escrows, sqlite.NewWalletService(db, 200)
+}
+
+func assertEscrow(t *testing.T, escrows *sqlite.EscrowService, status string) {
+	t.Helper()
+	escrow, err := escrows.FindEscrowByBookingID(context.Background(), uuid.MustParse(requestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Status != status {
+		t.Fatalf("escrow is %s, want %s", escrow.Status, status)
+	}
+}
+
+func assertWallet(t *testing.T, wallets *sqlite.WalletService, available, pending int) {
+	t.Helper()
+	wallet, err := wallets.FindWallet(context.Background(), providerUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Available != available || wallet.Pending != pending {
+		t.Fatalf("wallet has %d available and %d pending, want %d and %d", wallet.Available, wallet.Pending, available, pending)
+	}
+}
+
+func TestPayoutSchedulerReleasesAfterWindow(t *testing.T) {
+	clk, escrows, wallets := setupEscrow(t)
+	ctx := context.Background()
+
+	gateway := &fake.PayoutGateway{}
+	scheduler := billing.NewPayoutScheduler(escrows, wallets, gateway)
+
+	// The default commission of 10% is kept from the 500 paid.
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertEscrow(t, escrows, app.EscrowHeld)
+	assertWallet(t, wallets, 0, 450)
+
+	clk.Add(scheduler.ReleaseWindow)
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertEscrow(t, escrows, app.EscrowReleased)
+	assertWallet(t, wallets, 450, 0)
+
+	var appErr *app.Error
+	err := wallets.RequestPayout(ctx, &model.PayoutRequest{UserID: providerUserID, Amount: 100, Phone: "+254111222333"})
+	if !errors.As(err, &appErr) || appErr.Code != app.CONFLICT_ERR {
+		t.Fatalf("payout below the minimum: got %v, want a conflict", err)
+	}
+	err = wallets.RequestPayout(ctx, &model.PayoutRequest{UserID: providerUserID, Phone: "+254700000001"})
+	if !errors.As(err, &appErr) || appErr.Code != app.FORBIDDEN_ERR {
+		t.Fatalf("payout to an unverified phone: got %v, want forbidden", err)
+	}
+	if err := wallets.RequestPayout(ctx, &model.PayoutRequest{UserID: providerUserID, Phone: "+254111222333"}); err != nil {
+		t.Fatal(err)
+	}
+	assertWallet(t, wallets, 0, 0)
+
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	payouts := gateway.Payouts()
+	if len(payouts) != 1 || payouts[0].Amount != 450 {
+		t.Fatalf("payouts = %+v, want one of 450", payouts)
+	}
+
+	// A payout already sent is not sent again.
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gateway.Payouts()); n != 1 {
+		t.Fatalf("%d payouts, want 1", n)
+	}
+
+	if err := wallets.CompletePayout(ctx, &model.PayoutResult{Reference: payouts[0].Reference, Receipt: "B2C1"}); err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := wallets.FindWallet(ctx, providerUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Available != 0 || wallet.TotalPaidOut != 450 {
+		t.Fatalf("wallet = %+v, want 450 paid out", wallet)
+	}
+}
+
+func TestPayoutSchedulerRetriesFailedPayouts(t *testing.T) {
+	clk, escrows, wallets := setupEscrow(t)
+	ctx := context.Background()
+
+	gateway := &fake.PayoutGateway{Err: errors.New("insufficient balance")}
+	scheduler := billing.NewPayoutScheduler(escrows, wallets, gateway)
+
+	clk.Add(scheduler.ReleaseWindow)
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.RequestPayout(ctx, &model.PayoutRequest{UserID: providerUserID, Phone: "+254111222333"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payout stays leased until the lease runs out.
+	gateway.Err = nil
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gateway.Payouts()); n != 0 {
+		t.Fatalf("%d payouts within the lease, want 0", n)
+	}
+
+	clk.Add(scheduler.Lease)
+	if err := scheduler.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	payouts := gateway.Payouts()
+	if len(payouts) != 1 {
+		t.Fatalf("%d payouts after the lease, want 1", len(payouts))
+	}
+
+	// A withdrawal that fails at M-Pesa gives the funds back.
+	if err := wallets.CompletePayout(ctx, &model.PayoutResult{Reference: payouts[0].Reference, ResultCode: 1, ResultDesc: "Declined"}); err != nil {
+		t.Fatal(err)
+	}
+	assertWallet(t, wallets, 450, 0)
+}
@@ -0,0 +1,168 @@
+// Package billing renews subscriptions and pays out escrowed job payments in
+// the background.
+package billing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/google/uuid"
+)
+
+// Default scheduler settings.
+const (
+	DefaultInterval  = time.Minute
+	DefaultLease     = 5 * time.Minute
+	DefaultBatchSize = 50
+)
+
+// DefaultPendingWait is how long a charge waiting for the client to pay is
+// left before it is checked again.
+const DefaultPendingWait = 2 * time.Minute
+
+// DefaultRetryDelays are the waits before each retry of a failed renewal.
+// The subscription expires when the last retry fails too.
+var DefaultRetryDelays = []time.Duration{24 * time.Hour, 72 * time.Hour, 7 * 24 * time.Hour}
+
+// Scheduler periodically charges the subscriptions due for renewal. Several
+// instances may run against the same database: each subscription is leased to
+// one scheduler while it is charged.
+type Scheduler struct {
+	Subscriptions app.SubscriptionService
+	// Charger takes the payments. Without one, due subscriptions are left
+	// alone and only ended subscriptions are expired.
+	Charger app.SubscriptionCharger
+
+	// Name identifies the scheduler in subscription leases.
+	Name string
+	// Interval between runs.
+	Interval time.Duration
+	// Lease is how long a claimed subscription is kept from other schedulers.
+	// It must comfortably exceed the time a charge takes.
+	Lease time.Duration
+	// BatchSize is the most subscriptions claimed per run.
+	BatchSize   int
+	RetryDelays []time.Duration
+	// PendingWait is how long to wait before checking a charge again while
+	// the client has not paid.
+	PendingWait time.Duration
+	// Now returns the current time. It should be the clock of the database
+	// so retries are scheduled in the same time as due dates are checked.
+	Now func() time.Time
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(subscriptions app.SubscriptionService, charger app.SubscriptionCharger) *Scheduler {
+	return &Scheduler{
+		Subscriptions: subscriptions,
+		Charger:       charger,
+		Name:          uuid.NewString(),
+		Interval:      DefaultInterval,
+		Lease:         DefaultLease,
+		BatchSize:     DefaultBatchSize,
+		RetryDelays:   DefaultRetryDelays,
+		PendingWait:   DefaultPendingWait,
+		Now:           time.Now,
+	}
+}
+
+// Open starts running the scheduler in the background.
+func (s *Scheduler) Open() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop()
+	}()
+}
+
+// Close stops the scheduler and waits for the current run to finish.
+func (s *Scheduler) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Run(s.ctx); err != nil && s.ctx.Err() == nil {
+			log.Printf("billing: run failed: %s", err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run expires ended subscriptions and charges the ones due for renewal once.
+func (s *Scheduler) Run(ctx context.Context) error {
+	expired, err := s.Subscriptions.ExpireSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("billing: expired %d subscriptions", expired)
+	}
+
+	if s.Charger == nil {
+		return nil
+	}
+
+	for {
+		subscriptions, err := s.Subscriptions.ClaimDueSubscriptions(ctx, s.Name, s.Lease, s.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, subscription := range subscriptions {
+			if err := s.renew(ctx, subscription); err != nil {
+				return err
+			}
+		}
+		if len(subscriptions) < s.BatchSize {
+			return nil
+		}
+	}
+}
+
+// renew charges a subscription and records the outcome. Only errors recording
+// the outcome are returned; a failed charge is scheduled for a retry.
+func (s *Scheduler) renew(ctx context.Context, subscription *app.Subscription) error {
+	paymentID, err := s.Charger.ChargeSubscription(ctx, subscription)
+	if err == nil {
+		return s.Subscriptions.RenewSubscription(ctx, subscription.SubscriptionID, paymentID)
+	} else if err == app.ErrChargePending {
+		checkAt := s.Now().UTC().Truncate(time.Second).Add(s.PendingWait)
+		return s.Subscriptions.RecordPendingRenewal(ctx, subscription.SubscriptionID, checkAt)
+	}
+	log.Printf("billing: charging subscription %s failed: %s", subscription.SubscriptionID, err)
+
+	retryAt := s.retryAt(subscription.FailedCharges)
+	if retryAt == nil {
+		log.Printf("billing: subscription %s expired after %d failed charges", subscription.SubscriptionID, subscription.FailedCharges+1)
+	}
+	return s.Subscriptions.RecordFailedRenewal(ctx, subscription.SubscriptionID, retryAt)
+}
+
+// retryAt returns when to retry a subscription that has failed the given
+// number of charges before this one, or nil once the retries are used up.
+func (s *Scheduler) retryAt(failedCharges int) *time.Time {
+	if failedCharges >= len(s.RetryDelays) {
+		return nil
+	}
+	t := s.Now().UTC().Truncate(time.Second).Add(s.RetryDelays[failedCharges])
+	return &t
+}
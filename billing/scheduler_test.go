@@ -0,0 +1,367 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/billing"
+	"github.com/andrwkng/hudumaapp/database/sqlite"
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+const (
+	clientID      = "8o0LYQoCdygx5shO5mo5ILeVVWU2"
+	weeklyPlanID  = "98975604-428c-4335-9474-b99110b2d019"
+	monthlyPlanID = "098974ab-441c-4aa9-bf9c-40c6a95bdb5d"
+)
+
+// clock is a settable time source shared by the database and the schedulers.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// charger records the charges it takes and fails them while failing is set,
+// or leaves them pending while pending is set.
+type charger struct {
+	mu      sync.Mutex
+	failing bool
+	pending bool
+	charges map[string]int
+}
+
+func (c *charger) ChargeSubscription(ctx context.Context, subscription *app.Subscription) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failing {
+		return "", errors.New("insufficient funds")
+	}
+	if c.pending {
+		return "", app.ErrChargePending
+	}
+	c.charges[subscription.SubscriptionID]++
+	return "payment", nil
+}
+
+func setup(t *testing.T, planID string) (*clock, *sqlite.DB, *sqlite.SubscriptionService, *app.Subscription) {
+	t.Helper()
+
+	clk := &clock{now: time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC)}
+	db := sqlite.NewDB(":memory:")
+	db.Now = clk.Now
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Seed(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	svc := sqlite.NewSubscriptionService(db)
+	subscription := model.Subscription{ClientID: clientID, PlanID: planID, AutoRenew: true}
+	if err := svc.CreateSubscription(ctx, &subscription); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ActivateSubscription(ctx, subscription.SubscriptionID, "first"); err != nil {
+		t.Fatal(err)
+	}
+	return clk, db, svc, find(t, svc, subscription.SubscriptionID)
+}
+
+func find(t *testing.T, svc *sqlite.SubscriptionService, id string) *app.Subscription {
+	t.Helper()
+	subscription, err := svc.FindSubscriptionByID(context.Background(), id, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subscription
+}
+
+func newScheduler(svc app.SubscriptionService, c *charger, clk *clock) *billing.Scheduler {
+	s := billing.NewScheduler(svc, c)
+	s.Now = clk.Now
+	return s
+}
+
+func TestSchedulerRenewsEveryPeriod(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, monthlyPlanID)
+	c := &charger{charges: map[string]int{}}
+	s := newScheduler(svc, c, clk)
+
+	// Run daily for a year.
+	for day := 0; day < 365; day++ {
+		clk.Add(24 * time.Hour)
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := c.charges[subscription.SubscriptionID]; got != 12 {
+		t.Errorf("charges=%d, want 12", got)
+	}
+	got := find(t, svc, subscription.SubscriptionID)
+	if got.Status != app.SubscriptionActive || got.BillingCycles != 13 {
+		t.Errorf("status=%s cycles=%d, want active and 13", got.Status, got.BillingCycles)
+	}
+}
+
+func TestSchedulerRetriesThenExpires(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, weeklyPlanID)
+	c := &charger{charges: map[string]int{}, failing: true}
+	s := newScheduler(svc, c, clk)
+	s.RetryDelays = []time.Duration{24 * time.Hour, 48 * time.Hour}
+
+	clk.Add(7 * 24 * time.Hour)
+	for i, want := range []string{app.SubscriptionPastDue, app.SubscriptionPastDue, app.SubscriptionExpired} {
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got := find(t, svc, subscription.SubscriptionID)
+		if got.Status != want || got.FailedCharges != i+1 {
+			t.Fatalf("attempt %d: status=%s failed=%d, want %s and %d", i+1, got.Status, got.FailedCharges, want, i+1)
+		}
+
+		// Nothing happens before the retry is due.
+		if err := s.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := find(t, svc, subscription.SubscriptionID); got.FailedCharges != i+1 {
+			t.Fatalf("attempt %d: retried early", i+1)
+		}
+		clk.Add(48 * time.Hour)
+	}
+}
+
+func TestSchedulerRecoversFromPastDue(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, weeklyPlanID)
+	c := &charger{charges: map[string]int{}, failing: true}
+	s := newScheduler(svc, c, clk)
+
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c.failing = false
+	clk.Add(24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := find(t, svc, subscription.SubscriptionID)
+	if got.Status != app.SubscriptionActive || got.FailedCharges != 0 || got.BillingCycles != 2 {
+		t.Errorf("status=%s failed=%d cycles=%d, want active, 0 and 2", got.Status, got.FailedCharges, got.BillingCycles)
+	}
+}
+
+func TestSchedulerExpiresCancelledSubscription(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, weeklyPlanID)
+	c := &charger{charges: map[string]int{}}
+	s := newScheduler(svc, c, clk)
+
+	if err := svc.CancelSubscription(ctx, subscription.SubscriptionID, clientID, false); err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := find(t, svc, subscription.SubscriptionID); got.Status != app.SubscriptionExpired {
+		t.Errorf("status=%s, want expired", got.Status)
+	}
+	if got := c.charges[subscription.SubscriptionID]; got != 0 {
+		t.Errorf("charges=%d, want 0", got)
+	}
+}
+
+func TestSchedulersDoNotChargeTwice(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, weeklyPlanID)
+	c := &charger{charges: map[string]int{}}
+
+	clk.Add(7 * 24 * time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		s := newScheduler(svc, c, clk)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Run(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := c.charges[subscription.SubscriptionID]; got != 1 {
+		t.Errorf("charges=%d, want 1", got)
+	}
+}
+
+func TestSchedulerWaitsForPendingCharge(t *testing.T) {
+	ctx := context.Background()
+	clk, _, svc, subscription := setup(t, weeklyPlanID)
+	c := &charger{charges: map[string]int{}, pending: true}
+	s := newScheduler(svc, c, clk)
+
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := find(t, svc, subscription.SubscriptionID)
+	if got.Status != app.SubscriptionActive || got.FailedCharges != 0 || got.BillingCycles != 1 {
+		t.Fatalf("status=%s failed=%d cycles=%d, want active, 0 and 1", got.Status, got.FailedCharges, got.BillingCycles)
+	}
+
+	c.pending = false
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.charges[subscription.SubscriptionID]; got != 0 {
+		t.Fatalf("charged %d times before the pending charge was checked again", got)
+	}
+	clk.Add(billing.DefaultPendingWait)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, svc, subscription.SubscriptionID); got.BillingCycles != 2 {
+		t.Errorf("cycles=%d, want 2", got.BillingCycles)
+	}
+}
+
+// promptGateway accepts payment requests and remembers the last one.
+type promptGateway struct {
+	last string
+}
+
+func (g *promptGateway) RequestPayment(ctx context.Context, phone string, amount int, accountReference string, description string) (string, error) {
+	g.last = "checkout-" + accountReference
+	return g.last, nil
+}
+
+func TestPaymentServiceChargesRenewals(t *testing.T) {
+	ctx := context.Background()
+	clk, db, svc, subscription := setup(t, weeklyPlanID)
+	gateway := &promptGateway{}
+	s := newScheduler(svc, nil, clk)
+	s.Charger = sqlite.NewPaymentService(db, gateway)
+
+	// Without an M-Pesa number on file the charge fails.
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, svc, subscription.SubscriptionID); got.Status != app.SubscriptionPastDue || got.FailedCharges != 1 {
+		t.Fatalf("status=%s failed=%d, want past due and 1", got.Status, got.FailedCharges)
+	}
+
+	methods := sqlite.NewPaymentMethodService(db, nil)
+	if err := methods.AddPaymentMethod(ctx, &model.PaymentMethod{UserID: clientID, Method: "mpesa", PhoneNumber: "+254123456789"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry prompts the client and waits for them to pay.
+	clk.Add(24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gateway.last == "" {
+		t.Fatal("no payment requested")
+	}
+	clk.Add(billing.DefaultPendingWait)
+	prompt := gateway.last
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gateway.last != prompt {
+		t.Fatal("prompted again while the charge is pending")
+	}
+
+	if err := s.Charger.(*sqlite.PaymentService).CompleteSTKPayment(ctx, &model.STKResult{
+		CheckoutRequestID: prompt,
+		Amount:            1000,
+		Receipt:           "RCPT2",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got := find(t, svc, subscription.SubscriptionID)
+	if got.Status != app.SubscriptionActive || got.FailedCharges != 0 || got.BillingCycles != 2 {
+		t.Fatalf("status=%s failed=%d cycles=%d, want active, 0 and 2", got.Status, got.FailedCharges, got.BillingCycles)
+	}
+
+	// A declined prompt counts as a failed charge once it is checked.
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Charger.(*sqlite.PaymentService).CompleteSTKPayment(ctx, &model.STKResult{
+		CheckoutRequestID: gateway.last,
+		ResultCode:        1032,
+		ResultDesc:        "Request cancelled by user",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(billing.DefaultPendingWait)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, svc, subscription.SubscriptionID); got.Status != app.SubscriptionPastDue || got.FailedCharges != 1 {
+		t.Errorf("status=%s failed=%d, want past due and 1", got.Status, got.FailedCharges)
+	}
+}
+
+func TestFreePlanRenewsWithoutPayment(t *testing.T) {
+	ctx := context.Background()
+	clk, db, svc, _ := setup(t, weeklyPlanID)
+	gateway := &promptGateway{}
+	s := newScheduler(svc, nil, clk)
+	s.Charger = sqlite.NewPaymentService(db, gateway)
+
+	plan := model.Plan{Code: "free", Name: "Free", Currency: "KES", Interval: 1, IntervalUnit: "week"}
+	if err := sqlite.NewPlanService(db).CreatePlan(ctx, &plan); err != nil {
+		t.Fatal(err)
+	}
+	subscription := model.Subscription{ClientID: "BEfKrgwHuFWH5zA9H9vDEhBPc0o2", PlanID: plan.ID, AutoRenew: true}
+	if err := svc.CreateSubscription(ctx, &subscription); err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != app.SubscriptionActive {
+		t.Fatalf("status=%s, want active without a payment", subscription.Status)
+	}
+
+	clk.Add(7 * 24 * time.Hour)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.FindSubscriptionByID(ctx, subscription.SubscriptionID, subscription.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != app.SubscriptionActive || got.BillingCycles != 2 || gateway.last != "" {
+		t.Errorf("status=%s cycles=%d prompt=%q, want active, 2 and no prompt", got.Status, got.BillingCycles, gateway.last)
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -89,6 +90,8 @@ type Service struct {
 	Name     string `json:"name"`
 	Price    `json:"price"`
 	Category *string `json:"category"`
+	// Duration is how long the service takes in minutes.
+	Duration int `json:"duration"`
 }
 
 type Review struct {
@@ -127,6 +130,53 @@ type AllRequest struct {
 	Address   string    `json:"location"`
 }
 
+// RequestCandidate is an open request with the facts it is recommended to a
+// provider on.
+type RequestCandidate struct {
+	Request    AllRequest
+	CreatedAt  time.Time
+	DistanceKm *float64
+	// CategoryDepth is how many levels below the provider's category the
+	// category of the request is, or nil if it is not under it.
+	CategoryDepth *int
+	// ServiceMatch is set if the provider offers a service in the category
+	// of the request.
+	ServiceMatch bool
+	// ClientReviews and ClientRating are the number and average rating of
+	// the reviews the client has written.
+	ClientReviews int
+	ClientRating  float64
+	// BidsAccepted and BidsDecided count the provider's accepted bids and
+	// their accepted or rejected bids in the category of the request.
+	BidsAccepted int
+	BidsDecided  int
+}
+
+// ProviderProfile is what is known about the provider requests are
+// recommended to.
+type ProviderProfile struct {
+	HasCategory  bool
+	BidsAccepted int
+	BidsDecided  int
+}
+
+// RecommendedRequest is an open request ranked for a provider. Score is the
+// sum of the points of its factors, out of 100.
+type RecommendedRequest struct {
+	AllRequest
+	Score   float64        `json:"score"`
+	Factors []*ScoreFactor `json:"factors"`
+}
+
+// ScoreFactor explains part of the score of a recommended request: it earned
+// Points out of Weight because of Reason.
+type ScoreFactor struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Points float64 `json:"points"`
+	Reason string  `json:"reason"`
+}
+
 type location struct {
 	Latitude  *float64 `json:"latitude"`
 	Longitude *float64 `json:"longitude"`
@@ -194,6 +244,82 @@ type ProviderBooking struct {
 	Distance    string   `json:"distance_km"`
 }
 
+type BookingEvent struct {
+	ID         int       `json:"event_id"`
+	BookingID  uuid.UUID `json:"booking_id"`
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    string    `json:"actor_id"`
+	Reason     *string   `json:"reason"`
+	// RescheduleID is the proposal to move the booking the event is about.
+	RescheduleID *int   `json:"reschedule_id,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// BookingSeries is a recurring booking of a service.
+type BookingSeries struct {
+	ID         string `json:"series_id"`
+	ClientID   string `json:"client_id"`
+	ProviderID string `json:"provider_id"`
+	ServiceID  int    `json:"service_id"`
+	LocationID string `json:"location_id"`
+	Rule       string `json:"rule"`
+	StartAt    string `json:"start_at"`
+	Status     string `json:"status"`
+	// GeneratedUntil is the time up to which the bookings of the series have
+	// been made.
+	GeneratedUntil string              `json:"generated_until"`
+	CreatedAt      string              `json:"created_at"`
+	Occurrences    []*SeriesOccurrence `json:"occurrences,omitempty"`
+}
+
+// SeriesOccurrence is a time a series recurs at.
+type SeriesOccurrence struct {
+	OccursAt      string  `json:"occurs_at"`
+	Status        string  `json:"status"`
+	BookingID     *string `json:"booking_id"`
+	BookingStatus *string `json:"booking_status"`
+	// Note says why the occurrence failed or was skipped.
+	Note *string `json:"note"`
+}
+
+// BookingReschedule is a proposal to move a booking to another time.
+type BookingReschedule struct {
+	ID          int       `json:"reschedule_id"`
+	BookingID   uuid.UUID `json:"booking_id"`
+	ProposedBy  string    `json:"proposed_by"`
+	FromStartAt string    `json:"from_start_at"`
+	StartAt     string    `json:"start_at"`
+	EndAt       string    `json:"end_at"`
+	Status      string    `json:"status"`
+	Reason      *string   `json:"reason"`
+	CreatedAt   string    `json:"created_at"`
+	DecidedAt   *string   `json:"decided_at"`
+}
+
+// DefaultCheckInRadius is how far, in km, from the location of a booking its
+// provider may check in and out.
+const DefaultCheckInRadius = 0.5
+
+// BookingVisit is the provider's visit to do the job of a booking, from
+// checking in on arrival to checking out when done.
+type BookingVisit struct {
+	ID                int      `json:"visit_id"`
+	BookingID         string   `json:"booking_id"`
+	CheckInAt         string   `json:"check_in_at"`
+	CheckInLatitude   float64  `json:"check_in_latitude"`
+	CheckInLongitude  float64  `json:"check_in_longitude"`
+	CheckInDistance   float64  `json:"check_in_distance_km"`
+	CheckOutAt        *string  `json:"check_out_at"`
+	CheckOutLatitude  *float64 `json:"check_out_latitude"`
+	CheckOutLongitude *float64 `json:"check_out_longitude"`
+	CheckOutDistance  *float64 `json:"check_out_distance_km"`
+	// Duration is how long the job took in minutes.
+	Duration    *int    `json:"duration_minutes"`
+	ConfirmedBy *string `json:"confirmed_by"`
+	ConfirmedAt *string `json:"confirmed_at"`
+}
+
 type BookingBrief struct {
 	ID     uuid.UUID `json:"booking_id"`
 	Title  *string   `json:"title"`
@@ -256,7 +382,12 @@ type Bid struct {
 	BookingID uuid.UUID     `json:"request_id"`
 	Provider  ProviderBrief `json:"bidder"`
 	Amount    int           `json:"amount"`
-	Date      string        `json:"date"`
+	Status    string        `json:"status"`
+	Message   *string       `json:"message"`
+	// ETA is when the provider can start the job.
+	ETA       *string `json:"eta"`
+	ExpiresAt *string `json:"expires_at"`
+	Date      string  `json:"date"`
 }
 
 type BidBrief struct {
@@ -268,47 +399,184 @@ type BidBrief struct {
 	Date       string    `json:"date"`
 }
 
+// Escrow holds the client's payment for a booking until it is released to
+// the provider or refunded.
+type Escrow struct {
+	EscrowID       string `json:"escrow_id"`
+	BookingID      string `json:"booking_id"`
+	ClientID       string `json:"client_id"`
+	ProviderUserID string `json:"provider_user_id"`
+	Amount         int    `json:"amount"`
+	// Commission is kept by the platform when the funds are released.
+	Commission int `json:"commission"`
+	// CancellationFee is kept from the refund of a booking cancelled late.
+	CancellationFee int     `json:"cancellation_fee"`
+	Currency        string  `json:"currency"`
+	Status          string  `json:"status"`
+	HeldAt          *string `json:"held_at"`
+	SettledAt       *string `json:"settled_at"`
+	CreatedAt       string  `json:"created_at"`
+}
+
+// Payout is money sent to a user's phone: a withdrawal of their earnings or
+// the refund of an escrow.
+type Payout struct {
+	PayoutID    string  `json:"payout_id"`
+	UserID      string  `json:"user_id"`
+	EscrowID    *string `json:"escrow_id"`
+	Phone       string  `json:"phone"`
+	Amount      int     `json:"amount"`
+	Currency    string  `json:"currency"`
+	Status      string  `json:"status"`
+	Description *string `json:"description"`
+	Receipt     *string `json:"receipt"`
+	PaidAt      *string `json:"paid_at"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// Wallet is the earnings balance of a provider. Pending is held in escrow for
+// jobs not completed yet; Available can be withdrawn.
+type Wallet struct {
+	Available     int    `json:"available"`
+	Pending       int    `json:"pending"`
+	TotalEarned   int    `json:"total_earned"`
+	TotalPaidOut  int    `json:"total_paid_out"`
+	Currency      string `json:"currency"`
+	MinimumPayout int    `json:"minimum_payout"`
+}
+
+// CommissionRate is the share of job payments kept by the platform. It
+// applies to a category, an industry, or, without either, to everything else.
+type CommissionRate struct {
+	CategoryID *int    `json:"category_id"`
+	IndustryID *int    `json:"industry_id"`
+	Percent    float64 `json:"percent"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// Invoice is an invoice or receipt of a booking or subscription charge.
+// Amounts include tax: Subtotal and Tax add up to Total.
+type Invoice struct {
+	InvoiceID      string         `json:"invoice_id"`
+	Kind           string         `json:"kind"`
+	Number         string         `json:"number"`
+	UserID         string         `json:"user_id"`
+	BookingID      *string        `json:"booking_id"`
+	SubscriptionID *string        `json:"subscription_id"`
+	TransactionID  *string        `json:"transaction_id"`
+	BilledTo       string         `json:"billed_to"`
+	Phone          string         `json:"phone"`
+	Items          []*InvoiceItem `json:"items"`
+	Currency       string         `json:"currency"`
+	Subtotal       int            `json:"subtotal"`
+	TaxRate        float64        `json:"tax_rate"`
+	Tax            int            `json:"tax"`
+	Total          int            `json:"total"`
+	Status         string         `json:"status"`
+	IssuedAt       string         `json:"issued_at"`
+	PaidAt         *string        `json:"paid_at"`
+}
+
+type InvoiceItem struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitAmount  int    `json:"unit_amount"`
+	Amount      int    `json:"amount"`
+}
+
+// Transaction is an entry in the ledger of money paid by or to a user.
 type Transaction struct {
-	Code      string
-	Amount    int
-	Currency  string
-	Status    string
-	CreatedAt time.Time
+	TransactionID  string  `json:"transaction_id"`
+	UserID         *string `json:"user_id"`
+	BookingID      *string `json:"booking_id"`
+	SubscriptionID *string `json:"subscription_id"`
+	// Direction is debit for money paid by the user or withdrawn from their
+	// earnings, and credit for money paid to them or earned by them.
+	Direction         string  `json:"direction"`
+	Method            string  `json:"method"`
+	ProviderReference *string `json:"provider_reference"`
+	AccountReference  string  `json:"account_reference"`
+	Amount            int     `json:"amount"`
+	Currency          string  `json:"currency"`
+	Status            string  `json:"status"`
+	Description       *string `json:"description"`
+	CreatedAt         string  `json:"created_at"`
+	UpdatedAt         string  `json:"updated_at"`
 }
 
 type Subscription struct {
-	SubscriptionID string `json:"subscription_id"`
-	//Payment        `json:"payment"`
-	Plan          string `json:"plan"`
-	PlanName      string `json:"plan_name"`
-	Price         string `json:"plan"`
-	PaymentMethod string `json:"plan"`
-	AutoRenew     bool   `json:"auto_renew"`
-	Status        string `json:"status"`
-	//BillingCycles int    `json:"billing_cycles"`
-	NextBillingDate string `json:"renewal_date"`
-	//ActivatedAt   string `json:"activated_at"`
-	//CancelledAt   string `json:"canceled_at"`
-	//StartsAt string `json:"starts_at"`
-	//ExpireBy      string `json:"expires_by"`
-	//EndsAt        string `json:"ends_at"`
-	// Payment_method string `json:"payment_method"`
+	SubscriptionID  string  `json:"subscription_id"`
+	ClientID        string  `json:"client_id"`
+	PlanID          string  `json:"plan_id"`
+	Plan            string  `json:"plan"`      // monthly
+	PlanName        string  `json:"plan_name"` // Monthly
+	Price           string  `json:"price"`     // Ksh 999/month
+	PaymentMethodID *string `json:"payment_method_id"`
+	AutoRenew       bool    `json:"auto_renew"`
+	Status          string  `json:"status"`
+	BillingCycles   int     `json:"billing_cycles"`
+	FailedCharges   int     `json:"failed_charges"`
+	NextBillingDate *string `json:"renewal_date"`
+	ActivatedAt     *string `json:"activated_at"`
+	CancelledAt     *string `json:"cancelled_at"`
+	StartsAt        *string `json:"starts_at"`
+	EndsAt          *string `json:"ends_at"`
+	CreatedAt       string  `json:"created_at"`
 }
 
 type SubscriptionPage struct {
-	Plans          []Plan          `json:"plans"`
-	PaymentMethods []PaymentMethod `json:"payment_options"`
+	Plans          []*Plan         `json:"plans"`
+	PaymentMethods []*PaymentMethod `json:"payment_options"`
 }
 
+// Plan statuses. Archived plans are no longer offered, but subscriptions to
+// them keep working.
+const (
+	PlanActive   = "active"
+	PlanArchived = "archived"
+)
+
 type Plan struct {
-	ID           string `json:"id"`
-	Name         string `json:"name"`          // Weekly
-	Description  string `json:"description"`   // 24km distance...
-	Price        int    `json:"price"`         // 199
-	Currency     string `json:"currency"`      // Ksh
-	Interval     int    `json:"interval"`      // 1
-	IntervalUnit string `json:"interval_unit"` // week
-	// Features      []string
+	ID           string       `json:"id"`
+	Code         string       `json:"code"`          // monthly
+	Name         string       `json:"name"`          // Weekly
+	Description  string       `json:"description"`   // 24km distance...
+	Price        int          `json:"price"`         // 199
+	Currency     string       `json:"currency"`      // Ksh
+	Interval     int          `json:"interval"`      // 1
+	IntervalUnit string       `json:"interval_unit"` // week
+	TrialDays    int          `json:"trial_days"`
+	Features     PlanFeatures `json:"features"`
+	Status       string       `json:"status"`
+}
+
+// PeriodEnd returns the end of a billing period of the plan starting at start.
+func (p *Plan) PeriodEnd(start time.Time) time.Time {
+	switch p.IntervalUnit {
+	case "day":
+		return start.AddDate(0, 0, p.Interval)
+	case "week":
+		return start.AddDate(0, 0, 7*p.Interval)
+	case "year":
+		return start.AddDate(p.Interval, 0, 0)
+	default:
+		return start.AddDate(0, p.Interval, 0)
+	}
+}
+
+// PriceLabel describes the price of the plan per period, e.g. Ksh 999/month.
+func (p *Plan) PriceLabel() string {
+	if p.Interval == 1 {
+		return fmt.Sprintf("%s %d/%s", p.Currency, p.Price, p.IntervalUnit)
+	}
+	return fmt.Sprintf("%s %d/%d %ss", p.Currency, p.Price, p.Interval, p.IntervalUnit)
+}
+
+// PlanFeatures are the features a plan unlocks.
+type PlanFeatures struct {
+	// Distance in km within which requests are shown to the provider.
+	SearchRadiusKm int  `json:"search_radius_km"`
+	Premium        bool `json:"premium"`
 }
 
 /*
@@ -324,8 +592,14 @@ type PaymentMethod struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
 	Method string `json:"payment_method"` // eg. visa, mastercard, mpesa
+	// Number is the phone number of mobile money, or the masked number of a
+	// card.
 	Number string `json:"number"`
 	//Status string // Valid, Expiring, Expired, Invalid, PendingVerification
-	Logo string `json:"logo_url"`
-	Type string `json:"type"` // enum eg. Debit, Credit, Mobile, Bank
+	Logo      string  `json:"logo_url"`
+	Type      string  `json:"type"`   // mobile or card
+	Expiry    *string `json:"expiry"` // MM/YY, cards only
+	IsDefault bool    `json:"is_default"`
+	Verified  bool    `json:"verified"`
+	CreatedAt string  `json:"created_at"`
 }
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Cancellation rules, the part of a cancellation policy that applies to
+// cancelling a booking.
+const (
+	// CancellationFree applies before the free window closes, to
+	// cancellations by the provider and to bookings without a policy.
+	CancellationFree = "free"
+	// CancellationLate applies to the client cancelling within the free
+	// window before the start.
+	CancellationLate = "late"
+	// CancellationNoShow applies to the client cancelling after the start,
+	// or the provider reporting that the client did not show up.
+	CancellationNoShow = "no_show"
+)
+
+// CancellationPolicy sets what a client pays for cancelling a booking late.
+// It is set by a provider for their bookings, or by the platform for a
+// category or, without either, for everything else.
+type CancellationPolicy struct {
+	ProviderID *string `json:"provider_id"`
+	CategoryID *int    `json:"category_id"`
+	// FreeHours is how many hours before the start cancelling stops being
+	// free.
+	FreeHours int `json:"free_hours"`
+	// FeePercent is the share of the amount paid kept for cancelling late.
+	FeePercent float64 `json:"fee_percent"`
+	// NoShowPercent is the share of the amount paid kept for cancelling
+	// after the start or not showing up.
+	NoShowPercent float64 `json:"no_show_percent"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// CancellationDecision is what cancelling a booking costs its client.
+type CancellationDecision struct {
+	BookingID string              `json:"booking_id"`
+	Policy    *CancellationPolicy `json:"policy"`
+	Rule      string              `json:"rule"`
+	// FreeUntil is when cancelling stops being free, if it does.
+	FreeUntil *string `json:"free_until"`
+	// Paid is the amount held in escrow for the booking. Fees are only
+	// taken from payments, so nothing is owed for unpaid bookings.
+	Paid     int    `json:"paid"`
+	Fee      int    `json:"fee"`
+	Refund   int    `json:"refund"`
+	Currency string `json:"currency"`
+	Message  string `json:"message"`
+}
+
+// DecideCancellation works out what cancelling a booking that starts at
+// start costs its client at now under a policy, which may be nil. byClient
+// tells whether the client cancels, and noShow whether the provider reports
+// that the client did not show up.
+func DecideCancellation(p *CancellationPolicy, start, now time.Time, paid int, byClient, noShow bool) *CancellationDecision {
+	d := &CancellationDecision{Policy: p, Rule: CancellationFree, Paid: paid}
+
+	var percent float64
+	switch {
+	case p == nil:
+		d.Message = "There is no cancellation policy, cancelling is free."
+	case !byClient && !noShow:
+		d.Message = "Cancellations by the provider are free for the client."
+	case noShow || !now.Before(start):
+		d.Rule, percent = CancellationNoShow, p.NoShowPercent
+		d.Message = fmt.Sprintf("Cancelling after the start or not showing up costs %g%% of the amount paid.", p.NoShowPercent)
+	default:
+		freeUntil := start.Add(-time.Duration(p.FreeHours) * time.Hour)
+		s := freeUntil.Format(DateTimeFormat)
+		d.FreeUntil = &s
+		if now.Before(freeUntil) {
+			d.Message = fmt.Sprintf("Cancelling is free until %s.", s)
+		} else {
+			d.Rule, percent = CancellationLate, p.FeePercent
+			d.Message = fmt.Sprintf("Cancelling less than %d hours before the start costs %g%% of the amount paid.", p.FreeHours, p.FeePercent)
+		}
+	}
+
+	d.Fee = int(math.Round(float64(paid) * percent / 100))
+	d.Refund = paid - d.Fee
+	if percent > 0 && paid == 0 {
+		d.Message += " Nothing has been paid, so there is no fee."
+	}
+	return d
+}
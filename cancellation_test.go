@@ -0,0 +1,37 @@
+package app_test
+
+import (
+	"testing"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+func TestDecideCancellation(t *testing.T) {
+	policy := &app.CancellationPolicy{FreeHours: 24, FeePercent: 25, NoShowPercent: 100}
+	start := at(monday, "09:00")
+
+	for _, tt := range []struct {
+		name     string
+		policy   *app.CancellationPolicy
+		now      string
+		paid     int
+		byClient bool
+		noShow   bool
+		rule     string
+		fee      int
+	}{
+		{"NoPolicy", nil, "2023-03-06 08:00:00", 1000, true, false, app.CancellationFree, 0},
+		{"Early", policy, "2023-03-05 08:59:00", 1000, true, false, app.CancellationFree, 0},
+		{"Late", policy, "2023-03-05 09:00:00", 1000, true, false, app.CancellationLate, 250},
+		{"LateUnpaid", policy, "2023-03-05 10:00:00", 0, true, false, app.CancellationLate, 0},
+		{"AfterStart", policy, "2023-03-06 09:00:00", 1000, true, false, app.CancellationNoShow, 1000},
+		{"ByProvider", policy, "2023-03-06 08:00:00", 1000, false, false, app.CancellationFree, 0},
+		{"NoShow", policy, "2023-03-06 09:30:00", 1000, false, true, app.CancellationNoShow, 1000},
+	} {
+		now, _ := app.ParseDateTime(tt.now)
+		d := app.DecideCancellation(tt.policy, start, now, tt.paid, tt.byClient, tt.noShow)
+		if d.Rule != tt.rule || d.Fee != tt.fee || d.Refund != tt.paid-tt.fee {
+			t.Errorf("%s: rule=%s fee=%d refund=%d, want rule=%s fee=%d", tt.name, d.Rule, d.Fee, d.Refund, tt.rule, tt.fee)
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/auth"
+	"github.com/andrwkng/hudumaapp/billing"
 	"github.com/andrwkng/hudumaapp/config"
 	"github.com/andrwkng/hudumaapp/database/sqlite"
+	"github.com/andrwkng/hudumaapp/payments/fake"
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/policy"
+	"github.com/andrwkng/hudumaapp/recommend"
+	"github.com/andrwkng/hudumaapp/recurring"
 	"github.com/andrwkng/hudumaapp/server"
+	"github.com/andrwkng/hudumaapp/sms"
 	"github.com/go-sql-driver/mysql"
 )
 
@@ -45,32 +55,150 @@ func main() {
 		AllowNativePasswords: true,
 	}
 
-	switch cfg.Env {
-	case config.ProdEnv:
+	switch {
+	case cfg.DBDSN != "":
+		db = sqlite.NewDB(cfg.DBDSN)
+	case cfg.Env == config.ProdEnv:
 		db = sqlite.NewDB(dbCfg.FormatDSN())
-	case config.StageEnv:
+	case cfg.Env == config.StageEnv:
 		db = sqlite.NewDB(dbCfg.FormatDSN())
 		//db = sqlite.NewDB("xdshcqjkkzdjs55v:whsydeehry48wxsz@tcp(dcrhg4kh56j13bnu.cbetxkdyhwsb.us-east-1.rds.amazonaws.com:3306)/wtej3mys487jlnyv")
 	default:
 		db = sqlite.NewDB("root@tcp(127.0.0.1:3306)/hudumaapp")
 	}
+	db.TaxRate = cfg.InvoiceTaxRate
 
 	err = db.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	server.BkSvc = sqlite.NewBookingService(db)
+	// An in-memory database starts out empty on every run.
+	if db.IsMemory() {
+		if err := db.Migrate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := db.Seed(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Booking and bid services check the caller owns what they act on, and
+	// plans are only managed by administrators.
+	authorizer := policy.NewAuthorizer(sqlite.NewOwnershipService(db))
+
 	server.LocSvc = sqlite.NewLocationService(db)
-	server.BidSvc = sqlite.NewBidService(db)
+	server.BidSvc = policy.NewBidService(sqlite.NewBidService(db), authorizer)
 	server.CatSvc = sqlite.NewCategoryService(db)
 	server.PfoSvc = sqlite.NewPortfolioService(db)
-	server.ReqSvc = sqlite.NewRequestService(db)
 	server.UsrSvc = sqlite.NewUserService(db)
 	server.RevSvc = sqlite.NewReviewService(db)
 	server.IndSvc = sqlite.NewIndustryService(db)
 	server.SrchSvc = sqlite.NewSearchService(db)
+	server.PlanSvc = policy.NewPlanService(sqlite.NewPlanService(db), authorizer)
 	server.SubSvc = sqlite.NewSubscriptionService(db)
+	server.TxnSvc = sqlite.NewTransactionService(db)
+	server.InvSvc = sqlite.NewInvoiceService(db)
+	server.InvoiceIssuer = cfg.InvoiceIssuer
+
+	var smsSender app.SMSSender
+	switch cfg.SMSSender {
+	case config.FileSMS:
+		smsSender = sms.NewFileSender(cfg.SMSFile)
+	case config.LogSMS:
+		smsSender = sms.NewLogSender()
+	default:
+		log.Fatalf("unknown sms sender %q", cfg.SMSSender)
+	}
+	server.VerSvc = sqlite.NewVerificationService(db, smsSender)
+	bookings := sqlite.NewBookingService(db, smsSender)
+	bookings.CheckInRadius = cfg.CheckInRadius
+	server.BkSvc = policy.NewBookingService(bookings, authorizer)
+	server.PmSvc = sqlite.NewPaymentMethodService(db, smsSender)
+	requests := sqlite.NewRequestService(db, smsSender)
+	server.ReqSvc = policy.NewRequestService(requests, authorizer)
+	server.RecSvc = recommend.NewService(requests)
+	server.AvlSvc = sqlite.NewAvailabilityService(db)
+
+	// Payments are requested over M-Pesa when a Daraja app is configured.
+	var mpesaClient *mpesa.Client
+	var charger app.SubscriptionCharger
+	if cfg.MpesaConsumerKey != "" {
+		mpesaClient = mpesa.NewClient(cfg.Mpesa())
+		payments := sqlite.NewPaymentService(db, mpesaClient)
+		server.PaySvc = payments
+		charger = payments
+	}
+	server.CallbackSecret = cfg.MpesaCallbackSecret
+	server.CallbackAllowedIPs = cfg.MpesaCallbackAllowedIPs
+
+	switch cfg.AuthProvider {
+	case config.LocalAuth:
+		jwtAuth, err := auth.NewJWTAuthenticator(cfg.TokenSymmetricKey, cfg.AccessTokenDuration, cfg.RefreshTokenDuration)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Auth = jwtAuth
+		server.Tokens = jwtAuth
+	case config.FirebaseAuth:
+		firebaseAuth, err := auth.NewFirebaseAuthenticator(context.Background(), cfg.FirebaseCredentials)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Auth = firebaseAuth
+	default:
+		log.Fatalf("unknown auth provider %q", cfg.AuthProvider)
+	}
+
+	// Renew subscriptions in the background, charging them over M-Pesa.
+	// Without it the scheduler only expires ended subscriptions.
+	if cfg.BillingInterval > 0 {
+		scheduler := billing.NewScheduler(server.SubSvc, charger)
+		scheduler.Interval = cfg.BillingInterval
+		scheduler.RetryDelays = cfg.BillingRetryDelays
+		scheduler.Now = db.Now
+		scheduler.Open()
+		defer scheduler.Close()
+	}
+
+	// Complete jobs left in progress and send withdrawals and refunds in the
+	// background.
+	var payouts app.PayoutGateway
+	switch cfg.PayoutGateway {
+	case config.NoPayouts:
+	case config.MpesaPayouts:
+		if mpesaClient == nil {
+			log.Fatal("mpesa payouts need MPESA_CONSUMER_KEY")
+		}
+		payouts = mpesaClient
+	case config.FakePayouts:
+		payouts = &fake.PayoutGateway{}
+	default:
+		log.Fatalf("unknown payout gateway %q", cfg.PayoutGateway)
+	}
+	escrows := sqlite.NewEscrowService(db)
+	server.EscSvc = policy.NewEscrowService(escrows, authorizer)
+	wallets := sqlite.NewWalletService(db, cfg.PayoutMinimum)
+	server.WalSvc = policy.NewWalletService(wallets, authorizer)
+	server.CanSvc = policy.NewCancellationPolicyService(sqlite.NewCancellationPolicyService(db), authorizer)
+	if cfg.BillingInterval > 0 {
+		scheduler := billing.NewPayoutScheduler(escrows, wallets, payouts)
+		scheduler.Interval = cfg.BillingInterval
+		scheduler.ReleaseWindow = cfg.EscrowReleaseWindow
+		scheduler.Open()
+		defer scheduler.Close()
+	}
+
+	// Make the bookings of recurring series ahead of time.
+	series := sqlite.NewSeriesService(db)
+	series.Horizon = cfg.SeriesHorizon
+	server.SerSvc = series
+	if cfg.SeriesInterval > 0 {
+		scheduler := recurring.NewScheduler(series)
+		scheduler.Interval = cfg.SeriesInterval
+		scheduler.Open()
+		defer scheduler.Close()
+	}
 
 	log.Fatal(server.Start())
 
@@ -62,7 +62,11 @@ func Run(ctx context.Context, args []string) error {
 		AllowNativePasswords: true,
 	}
 
-	db := sqlite.NewDB(dbCfg.FormatDSN())
+	dsn := dbCfg.FormatDSN()
+	if cfg.DBDSN != "" {
+		dsn = cfg.DBDSN
+	}
+	db := sqlite.NewDB(dsn)
 
 	err = db.Open()
 	if err != nil {
@@ -83,6 +87,10 @@ func Run(ctx context.Context, args []string) error {
 		//return (&DBCommand{}).Run(ctx, args)
 		cli := cmd.NewDBCommand(db)
 		return cli.Run(ctx, args)
+	case "users":
+		return cmd.NewUsersCommand(db).Run(ctx, args)
+	case "subscriptions":
+		return cmd.NewSubscriptionsCommand(db).Run(ctx, args)
 	default:
 		return fmt.Errorf("serviceAapp %s: unknown command", cmdName)
 	}
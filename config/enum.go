@@ -5,3 +5,22 @@ const (
 	StageEnv string = "staging"
 	LocalEnv string = "local"
 )
+
+// Authentication providers, selected with AUTH_PROVIDER.
+const (
+	FirebaseAuth string = "firebase"
+	LocalAuth    string = "local"
+)
+
+// SMS senders, selected with SMS_SENDER.
+const (
+	LogSMS  string = "log"
+	FileSMS string = "file"
+)
+
+// Payout gateways, selected with PAYOUT_GATEWAY.
+const (
+	NoPayouts    string = "none"
+	MpesaPayouts string = "mpesa"
+	FakePayouts  string = "fake"
+)
@@ -0,0 +1,424 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+type AvailabilityService struct {
+	db *DB
+}
+
+func NewAvailabilityService(db *DB) *AvailabilityService {
+	return &AvailabilityService{db}
+}
+
+func (s *AvailabilityService) FindAvailability(ctx context.Context, userID string) (*app.Availability, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findAvailabilityProviderID(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	availability, err := findAvailability(ctx, tx, providerID, "")
+	if err != nil {
+		return nil, err
+	}
+	return availability, tx.Commit()
+}
+
+func (s *AvailabilityService) SetWorkingHours(ctx context.Context, hours *model.WeeklyHours) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findAvailabilityProviderID(ctx, tx, hours.UserID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM working_hours WHERE provider_id = ?
+		`,
+		providerID,
+	); err != nil {
+		return err
+	}
+	for _, h := range hours.Hours {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO working_hours (
+				provider_id,
+				weekday,
+				start_time,
+				end_time
+			) VALUES (?,?,?,?)
+			`,
+			providerID,
+			h.Weekday,
+			h.StartTime,
+			h.EndTime,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *AvailabilityService) CreateAvailabilityException(ctx context.Context, exception *model.AvailabilityException) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findAvailabilityProviderID(ctx, tx, exception.UserID)
+	if err != nil {
+		return err
+	}
+
+	exception.ID = uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO availability_exceptions (
+			exception_id,
+			provider_id,
+			date,
+			start_time,
+			end_time,
+			created_at
+		) VALUES (?,?,?,?,?,?)
+		`,
+		exception.ID,
+		providerID,
+		exception.Date,
+		exception.StartTime,
+		exception.EndTime,
+		tx.now,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *AvailabilityService) DeleteAvailabilityException(ctx context.Context, id string, userID string) error {
+	return s.delete(ctx, `
+		DELETE FROM availability_exceptions
+		WHERE exception_id = ?
+		AND provider_id = (SELECT provider_id FROM providers WHERE user_id = ?)
+		`,
+		id,
+		userID,
+	)
+}
+
+func (s *AvailabilityService) CreateBlackout(ctx context.Context, blackout *model.Blackout) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findAvailabilityProviderID(ctx, tx, blackout.UserID)
+	if err != nil {
+		return err
+	}
+
+	blackout.ID = uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO blackouts (
+			blackout_id,
+			provider_id,
+			start_date,
+			end_date,
+			reason,
+			created_at
+		) VALUES (?,?,?,?,?,?)
+		`,
+		blackout.ID,
+		providerID,
+		blackout.StartDate,
+		blackout.EndDate,
+		blackout.Reason,
+		tx.now,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *AvailabilityService) DeleteBlackout(ctx context.Context, id string, userID string) error {
+	return s.delete(ctx, `
+		DELETE FROM blackouts
+		WHERE blackout_id = ?
+		AND provider_id = (SELECT provider_id FROM providers WHERE user_id = ?)
+		`,
+		id,
+		userID,
+	)
+}
+
+// delete runs a delete statement and returns sql.ErrNoRows if it deleted
+// nothing.
+func (s *AvailabilityService) delete(ctx context.Context, query string, args ...interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func (s *AvailabilityService) ListFreeSlots(ctx context.Context, filter *model.SlotFilter) ([]*app.Slot, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	duration, err := findServiceDuration(ctx, tx, filter.ProviderID, filter.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	from, _ := time.Parse(app.DateFormat, filter.From)
+	to, _ := time.Parse(app.DateFormat, filter.To)
+	availability, err := findAvailability(ctx, tx, filter.ProviderID, filter.From)
+	if err != nil {
+		return nil, err
+	}
+	busy, err := findBusyIntervals(ctx, tx, filter.ProviderID, from, to.AddDate(0, 0, 1), "")
+	if err != nil {
+		return nil, err
+	}
+
+	slots := availability.FreeSlots(from, to, duration, wallClock(tx.now), busy)
+	return slots, tx.Commit()
+}
+
+// findAvailabilityProviderID returns the provider ID of a user.
+func findAvailabilityProviderID(ctx context.Context, tx *Tx, userID string) (string, error) {
+	var providerID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT provider_id FROM providers WHERE user_id = ?
+		`,
+		userID,
+	).Scan(&providerID)
+	if err == sql.ErrNoRows {
+		return "", app.Errorf(app.NOTFOUND_ERR, "Only providers have working hours.")
+	}
+	return providerID, err
+}
+
+// findAvailability returns the working hours of a provider with their
+// exceptions and blackouts that end on or after a date, or all of them if
+// the date is empty.
+func findAvailability(ctx context.Context, tx *Tx, providerID string, since string) (*app.Availability, error) {
+	availability := &app.Availability{
+		WorkingHours: []*app.WorkingHours{},
+		Exceptions:   []*app.AvailabilityException{},
+		Blackouts:    []*app.Blackout{},
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT weekday, start_time, end_time
+		FROM working_hours
+		WHERE provider_id = ?
+		ORDER BY weekday, start_time
+		`,
+		providerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h app.WorkingHours
+		if err := rows.Scan(&h.Weekday, &h.StartTime, &h.EndTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		availability.WorkingHours = append(availability.WorkingHours, &h)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT exception_id, date, start_time, end_time
+		FROM availability_exceptions
+		WHERE provider_id = ? AND date >= ?
+		ORDER BY date, start_time
+		`,
+		providerID,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e app.AvailabilityException
+		if err := rows.Scan(&e.ID, &e.Date, &e.StartTime, &e.EndTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		availability.Exceptions = append(availability.Exceptions, &e)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT blackout_id, start_date, end_date, reason
+		FROM blackouts
+		WHERE provider_id = ? AND end_date >= ?
+		ORDER BY start_date
+		`,
+		providerID,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b app.Blackout
+		if err := rows.Scan(&b.ID, &b.StartDate, &b.EndDate, &b.Reason); err != nil {
+			return nil, err
+		}
+		availability.Blackouts = append(availability.Blackouts, &b)
+	}
+	return availability, rows.Err()
+}
+
+// findServiceDuration returns how long a service of a provider takes.
+func findServiceDuration(ctx context.Context, tx *Tx, providerID string, serviceID string) (time.Duration, error) {
+	var minutes int
+	err := tx.QueryRowContext(ctx, `
+		SELECT duration FROM services WHERE id = ? AND provider_id = ? AND deleted_at IS NULL
+		`,
+		serviceID,
+		providerID,
+	).Scan(&minutes)
+	if err == sql.ErrNoRows {
+		return 0, app.Errorf(app.NOTFOUND_ERR, "The provider does not offer this service.")
+	} else if err != nil {
+		return 0, err
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// findBusyIntervals returns the times taken by the bookings of a provider that
+// overlap the time from start up to end, leaving out the booking with the ID
+// exceptID. A booking without an end, such as a request accepted before ends
+// were recorded, takes the default service duration. Requests keep their
+// start like 2006-01-02T15:04:05, so it is compared in the format of bookings.
+func findBusyIntervals(ctx context.Context, tx *Tx, providerID string, start, end time.Time, exceptID string) ([]app.Interval, error) {
+	defaultDuration := app.DefaultServiceDuration * time.Minute
+	args := []interface{}{
+		providerID,
+		exceptID,
+		end.Format(app.DateTimeFormat),
+		start.Format(app.DateTimeFormat),
+		start.Add(-defaultDuration).Format(app.DateTimeFormat),
+	}
+	for _, status := range app.BusyBookingStatuses {
+		args = append(args, status)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT start_at, end_at
+		FROM bookings
+		WHERE provider_id = ? AND booking_id <> ?
+		AND REPLACE(start_at, 'T', ' ') < ?
+		AND (end_at > ? OR (end_at IS NULL AND REPLACE(start_at, 'T', ' ') > ?))
+		AND status IN (`+placeholders(len(app.BusyBookingStatuses))+`)
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var busy []app.Interval
+	for rows.Next() {
+		var startAt string
+		var endAt sql.NullString
+		if err := rows.Scan(&startAt, &endAt); err != nil {
+			return nil, err
+		}
+		var i app.Interval
+		if i.Start, err = parseTime(startAt); err != nil {
+			return nil, err
+		}
+		i.End = i.Start.Add(defaultDuration)
+		if endAt.Valid {
+			if i.End, err = parseTime(endAt.String); err != nil {
+				return nil, err
+			}
+		}
+		busy = append(busy, i)
+	}
+	return busy, rows.Err()
+}
+
+// reserveSlot checks that a provider is free for the given time from start,
+// other than for the booking with the ID exceptID, and returns when the time
+// ends. The provider's row is written first so that bookings of the same
+// provider are made one at a time, and two of them cannot both find the slot
+// free.
+func reserveSlot(ctx context.Context, tx *Tx, providerID string, start time.Time, duration time.Duration, exceptID string) (time.Time, error) {
+	if start.Before(wallClock(tx.now)) {
+		return time.Time{}, app.Errorf(app.INVALID_ERR, "start_date: must be in the future.")
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE providers SET updated_at = ? WHERE provider_id = ?
+		`,
+		tx.now,
+		providerID,
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	slot := app.Interval{Start: start, End: start.Add(duration)}
+	availability, err := findAvailability(ctx, tx, providerID, start.Format(app.DateFormat))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if availability.IsSet() && !availability.Covers(slot) {
+		return time.Time{}, app.Errorf(app.INVALID_ERR, "start_date: the provider does not work at this time.")
+	}
+
+	busy, err := findBusyIntervals(ctx, tx, providerID, slot.Start, slot.End, exceptID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(busy) > 0 {
+		return time.Time{}, app.Errorf(app.CONFLICT_ERR, "The provider is already booked at this time, choose another slot.")
+	}
+	return slot.End, nil
+}
+
+// wallClock returns the local wall clock time of t, which bookings are made
+// in, in UTC.
+func wallClock(t time.Time) time.Time {
+	l := t.Local()
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), 0, time.UTC)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	app "github.com/andrwkng/hudumaapp"
 	"github.com/andrwkng/hudumaapp/model"
@@ -14,19 +15,24 @@ import (
 )
 
 type BookingService struct {
-	db *DB
+	db  *DB
+	sms app.SMSSender
+	// CheckInRadius is how far, in km, from the location of a booking its
+	// provider may check in and out.
+	CheckInRadius float64
 }
 
-func NewBookingService(db *DB) *BookingService {
-	return &BookingService{db}
+func NewBookingService(db *DB, sms app.SMSSender) *BookingService {
+	return &BookingService{db: db, sms: sms, CheckInRadius: app.DefaultCheckInRadius}
 }
 
 type RequestService struct {
-	db *DB
+	db  *DB
+	sms app.SMSSender
 }
 
-func NewRequestService(db *DB) *RequestService {
-	return &RequestService{db}
+func NewRequestService(db *DB, sms app.SMSSender) *RequestService {
+	return &RequestService{db, sms}
 }
 
 func (s *RequestService) CreateRequest(ctx context.Context, request *model.Request) error {
@@ -43,6 +49,12 @@ func (s *RequestService) CreateRequest(ctx context.Context, request *model.Reque
 }
 
 func createRequest(ctx context.Context, tx *Tx, request *model.Request) error {
+	request.Status = app.BookingBidding
+
+	if err := resolveRequestCategory(ctx, tx, request); err != nil {
+		return err
+	}
+
 	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO bookings (
 			booking_id,
@@ -51,10 +63,11 @@ func createRequest(ctx context.Context, tx *Tx, request *model.Request) error {
 			description,
 			start_at,
 			location_id,
+			category_id,
 			status,
 			is_urgent,
 			is_request
-		) VALUES (?,?,?,?,?,?,?,?,?)
+		) VALUES (?,?,?,?,?,?,?,?,?,?)
 		`,
 		request.ID,
 		request.ClientID,
@@ -62,6 +75,7 @@ func createRequest(ctx context.Context, tx *Tx, request *model.Request) error {
 		request.Note,
 		request.StartDate,
 		request.LocationID,
+		request.CategoryID,
 		request.Status,
 		request.Urgent,
 		true,
@@ -70,6 +84,10 @@ func createRequest(ctx context.Context, tx *Tx, request *model.Request) error {
 		return err
 	}
 
+	if err := createBookingEvent(ctx, tx, request.ID, nil, request.Status, request.ClientID, nil); err != nil {
+		return err
+	}
+
 	// Save photos information if present
 	if request.Photos != nil {
 		for _, photoUrl := range request.Photos {
@@ -345,99 +363,6 @@ func removeLocation(ctx context.Context, tx *Tx, locationID string) error {
 	return nil
 }
 
-type TransactionService struct {
-	db *DB
-}
-
-func NewTransactionService(db *DB) *TransactionService {
-	return &TransactionService{db}
-}
-
-func (s *TransactionService) CreateTransaction(ctx context.Context, transaction *model.Transaction) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Create dial and attach associated owner user.
-	if err := createTransaction(ctx, tx, transaction); err != nil {
-		return err
-	}
-	return tx.Commit()
-}
-
-func createTransaction(ctx context.Context, tx *Tx, transaction *model.Transaction) error {
-	_, err := tx.ExecContext(ctx, `INSERT INTO transactions(
-		transaction_id,
-		user_id,
-		code,
-		service_id,
-		amount,
-		) VALUES (?,?,?,?,?,?,?)
-		`,
-		transaction.ID,
-		transaction.UserID,
-		transaction.Code,
-		transaction.ServiceID,
-		transaction.Amount,
-	)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *TransactionService) ListTransactions(ctx context.Context, userId uuid.UUID) ([]*app.Transaction, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
-
-	transactions, err := listTransactions(ctx, tx, userId)
-	if err != nil {
-		return nil, err
-	}
-	return transactions, tx.Commit()
-}
-
-func listTransactions(ctx context.Context, tx *Tx, userId uuid.UUID) ([]*app.Transaction, error) {
-	rows, err := tx.QueryContext(ctx, `
-		SELECT
-			transactions.transaction_id,
-			transactions.user_id,
-			transactions.location_id,
-			transactions.booking_id,
-			transactions.amount,
-			transactions.created_at,
-			transactions.updated_at
-		FROM transactions
-		WHERE transactions.user_id = ?
-		`, userId)
-	if err != nil {
-		return nil, err
-	}
-	var transactions []*app.Transaction
-	for rows.Next() {
-		var transaction app.Transaction
-		if err := rows.Scan(
-			&transaction.Code,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.Amount,
-			&transaction.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, &transaction)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return transactions, nil
-}
-
 func (s *LocationService) FindLocationsByUserID(ctx context.Context, userId uuid.UUID) ([]*app.Location, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -706,42 +631,6 @@ func findAppointments(ctx context.Context, tx *Tx) ([]*app.BookingBrief, error)
 	return bookings, nil
 }
 
-func (s *BookingService) CompleteBooking(ctx context.Context, bookingId uuid.UUID) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE bookings
-		SET status = 'completed'
-		WHERE booking_id = ?
-	`, bookingId); err != nil {
-		return err
-	}
-
-	return tx.Commit()
-}
-
-func (s *BookingService) CancelBooking(ctx context.Context, bookingId uuid.UUID) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE bookings
-		SET status = 'cancelled'
-		WHERE booking_id = ?
-	`, bookingId); err != nil {
-		return err
-	}
-
-	return tx.Commit()
-}
-
 func (s *BookingService) FindBookings(ctx context.Context, providerID string) ([]*app.BookingBrief, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -875,34 +764,56 @@ func (s *BookingService) CreateBooking(ctx context.Context, booking *model.Booki
 
 // createBooking creates a new booking.
 func createBooking(ctx context.Context, tx *Tx, booking *model.Booking) error {
-	booking.Status = statusPending
+	booking.Status = app.BookingRequested
+
+	start, err := app.ParseDateTime(booking.StartDate)
+	if err != nil {
+		return app.Errorf(app.INVALID_ERR, "start_date: must be like %s.", app.DateTimeFormat)
+	}
+	duration, err := findServiceDuration(ctx, tx, booking.ProviderID, booking.ServiceID)
+	if err != nil {
+		return err
+	}
+	end, err := reserveSlot(ctx, tx, booking.ProviderID, start, duration, booking.ID.String())
+	if err != nil {
+		return err
+	}
+	booking.StartDate = start.Format(app.DateTimeFormat)
 
 	query := `
 	INSERT INTO bookings (
 		booking_id,
 		status,
 		start_at,
+		end_at,
 		client_id,
 		provider_id,
 		location_id,
-		service_id
-	) VALUES (?,?,?,?,?,?,?)
+		service_id,
+		series_id
+	) VALUES (?,?,?,?,?,?,?,?,?)
 	`
 
 	// Insert row into database.
-	_, err := tx.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		booking.ID,
 		booking.Status,
 		booking.StartDate,
+		end.Format(app.DateTimeFormat),
 		booking.ClientID,
 		booking.ProviderID,
 		booking.LocationID,
 		booking.ServiceID,
+		booking.SeriesID,
 	)
 	if err != nil {
 		return err
 	}
 
+	if err := createBookingEvent(ctx, tx, booking.ID, nil, booking.Status, booking.ClientID, nil); err != nil {
+		return err
+	}
+
 	if booking.Photos != nil {
 		for _, photoUrl := range booking.Photos {
 			photo := model.Photo{
@@ -964,23 +875,237 @@ func (s *BidService) CreateBid(ctx context.Context, bid *model.Bid) error {
 }
 
 func createBid(ctx context.Context, tx *Tx, bid *model.Bid) error {
-	_, err := tx.ExecContext(ctx, `
+	// The request's row is written first so that bids on it are made one at
+	// a time, and a provider submitting twice cannot get two active bids.
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bookings SET updated_at = ? WHERE booking_id = ?
+		`,
+		tx.now,
+		bid.BookingID,
+	); err != nil {
+		return err
+	}
+	if err := expireBids(ctx, tx); err != nil {
+		return err
+	}
+	if err := checkBiddingOpen(ctx, tx, bid.BookingID); err != nil {
+		return err
+	}
+	if err := checkBidExpiry(tx, bid.ExpiresAt); err != nil {
+		return err
+	}
+
+	var providerID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT provider_id FROM providers WHERE user_id = ?
+		`,
+		bid.BidderID,
+	).Scan(&providerID)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.FORBIDDEN_ERR, "Only providers can bid on requests.")
+	} else if err != nil {
+		return err
+	}
+
+	var active int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM bids WHERE booking_id = ? AND provider_id = ? AND status = ?
+		`,
+		bid.BookingID,
+		providerID,
+		app.BidActive,
+	).Scan(&active); err != nil {
+		return err
+	}
+	if active > 0 {
+		return app.Errorf(app.CONFLICT_ERR, "You already have a bid on this request. Revise it instead.")
+	}
+
+	result, err := tx.ExecContext(ctx, `
 		INSERT INTO bids (
 			booking_id,
 			provider_id,
-			amount
-		) VALUES (?, (SELECT provider_id FROM providers WHERE user_id = ?), ?)
+			amount,
+			status,
+			message,
+			eta,
+			expires_at,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?)
 		`,
 		bid.BookingID,
-		bid.BidderID,
+		providerID,
 		bid.Amount,
+		app.BidActive,
+		bid.Message,
+		utcTime(bid.ETA),
+		utcTime(bid.ExpiresAt),
+		tx.now,
+		tx.now,
 	)
 	if err != nil {
 		return err
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	bid.ID = int(id)
 	return nil
 }
 
+// UpdateBid revises an active bid while its request is open for bidding.
+func (s *BidService) UpdateBid(ctx context.Context, update *model.BidUpdate) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := expireBids(ctx, tx); err != nil {
+		return err
+	}
+	bookingID, err := findActiveBid(ctx, tx, update.ID)
+	if err != nil {
+		return err
+	}
+	if err := checkBiddingOpen(ctx, tx, bookingID); err != nil {
+		return err
+	}
+	if err := checkBidExpiry(tx, update.ExpiresAt); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bids SET
+			amount = ?,
+			message = ?,
+			eta = ?,
+			expires_at = ?,
+			updated_at = ?
+		WHERE id = ?
+		`,
+		update.Amount,
+		update.Message,
+		utcTime(update.ETA),
+		utcTime(update.ExpiresAt),
+		tx.now,
+		update.ID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WithdrawBid withdraws an active bid while its request is open for bidding.
+func (s *BidService) WithdrawBid(ctx context.Context, bidID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := expireBids(ctx, tx); err != nil {
+		return err
+	}
+	bookingID, err := findActiveBid(ctx, tx, bidID)
+	if err != nil {
+		return err
+	}
+	if err := checkBiddingOpen(ctx, tx, bookingID); err != nil {
+		return err
+	}
+
+	if err := setBidStatus(ctx, tx, bidID, app.BidWithdrawn); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// findActiveBid returns the booking of a bid, or a conflict error if the bid
+// is no longer active.
+func findActiveBid(ctx context.Context, tx *Tx, bidID int) (string, error) {
+	var bookingID, status string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT booking_id, status FROM bids WHERE id = ?
+		`,
+		bidID,
+	).Scan(&bookingID, &status); err != nil {
+		return "", err
+	}
+	if status != app.BidActive {
+		return "", app.Errorf(app.CONFLICT_ERR, "Bid is %s.", status)
+	}
+	return bookingID, nil
+}
+
+// checkBiddingOpen returns an error unless the request is open for bidding.
+func checkBiddingOpen(ctx context.Context, tx *Tx, bookingID string) error {
+	var status string
+	err := tx.QueryRowContext(ctx, `
+		SELECT status FROM bookings WHERE booking_id = ? AND is_request = 1
+		`,
+		bookingID,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Request not found.")
+	} else if err != nil {
+		return err
+	}
+	if status != app.BookingBidding {
+		return app.Errorf(app.CONFLICT_ERR, "Bidding on this request is closed.")
+	}
+	return nil
+}
+
+func checkBidExpiry(tx *Tx, expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(tx.now) {
+		return app.Errorf(app.INVALID_ERR, "Bid must expire in the future.")
+	}
+	return nil
+}
+
+// expireBids expires the active bids whose expiry has passed.
+func expireBids(ctx context.Context, tx *Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE bids SET
+			status = ?,
+			updated_at = ?
+		WHERE status = ? AND expires_at <= ?
+		`,
+		app.BidExpired,
+		tx.now,
+		app.BidActive,
+		tx.now,
+	)
+	return err
+}
+
+func setBidStatus(ctx context.Context, tx *Tx, bidID int, status string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE bids SET
+			status = ?,
+			updated_at = ?
+		WHERE id = ?
+		`,
+		status,
+		tx.now,
+		bidID,
+	)
+	return err
+}
+
+// utcTime converts t to UTC so it compares with the times the database
+// stores.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
 func (s *BidService) FindBidsByBookingID(ctx context.Context, bookingID string) ([]*app.Bid, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -1002,15 +1127,22 @@ func listBids(ctx context.Context, tx *Tx, bookingID string) ([]*app.Bid, error)
 			bids.booking_id,
 			bids.provider_id,
 			bids.amount,
+			`+bidStatusColumn+`,
+			bids.message,
+			bids.eta,
+			bids.expires_at,
 			bids.updated_at,
 			users.first_name,
 			users.last_name
 		FROM bids
 		LEFT JOIN providers ON bids.provider_id = providers.provider_id
 		LEFT JOIN users ON providers.user_id = users.user_id
-		WHERE bids.booking_id = ?
+		WHERE bids.booking_id = ? AND bids.status <> ?
+		ORDER BY bids.amount
 		`,
+		tx.now,
 		bookingID,
+		app.BidWithdrawn,
 	)
 	if err != nil {
 		return nil, err
@@ -1028,6 +1160,10 @@ func listBids(ctx context.Context, tx *Tx, bookingID string) ([]*app.Bid, error)
 			&bid.BookingID,
 			&bid.Provider.ID,
 			&bid.Amount,
+			&bid.Status,
+			&bid.Message,
+			&bid.ETA,
+			&bid.ExpiresAt,
 			&bid.Date,
 			&firstname,
 			&lastname,
@@ -1051,7 +1187,7 @@ func (s *BidService) ListMyBids(ctx context.Context, userID string) ([]*app.Bid,
 	}
 	defer tx.Rollback()
 
-	bids, err := listBidsByCriteria(ctx, tx, userID, "1", "1")
+	bids, err := listBidsByCriteria(ctx, tx, userID, "1", 1)
 	if err != nil {
 		return nil, err
 	}
@@ -1072,12 +1208,16 @@ func (s *BidService) FindBidsByRequestID(ctx context.Context, userID string, req
 	return bids, tx.Commit()
 }
 
-func listBidsByCriteria(ctx context.Context, tx *Tx, userID string, haystack string, needle string) ([]*app.Bid, error) {
+func listBidsByCriteria(ctx context.Context, tx *Tx, userID string, haystack string, needle interface{}) ([]*app.Bid, error) {
 	rows, err := tx.QueryContext(ctx, `
 		SELECT 
 			bids.id,
 			bids.booking_id,
 			bids.amount,
+			`+bidStatusColumn+`,
+			bids.message,
+			bids.eta,
+			bids.expires_at,
 			bids.updated_at,
 			bids.provider_id,
 			users.first_name,
@@ -1091,7 +1231,7 @@ func listBidsByCriteria(ctx context.Context, tx *Tx, userID string, haystack str
 		WHERE bids.provider_id = (SELECT provider_id FROM providers WHERE user_id = ?)
 		AND `+haystack+` = ?
 	`,
-		userID, needle,
+		tx.now, userID, needle,
 	)
 	if err != nil {
 		return nil, err
@@ -1108,6 +1248,10 @@ func listBidsByCriteria(ctx context.Context, tx *Tx, userID string, haystack str
 			&bid.ID,
 			&bid.BookingID,
 			&bid.Amount,
+			&bid.Status,
+			&bid.Message,
+			&bid.ETA,
+			&bid.ExpiresAt,
 			&bid.Date,
 			&bid.Provider.ID,
 			&firstname,
@@ -1128,52 +1272,103 @@ func listBidsByCriteria(ctx context.Context, tx *Tx, userID string, haystack str
 	return bids, nil
 }
 
-func (s *BidService) AcceptBid(ctx context.Context, bidID int) error {
+func (s *BidService) AcceptBid(ctx context.Context, bidID int, actorID string) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	err = acceptBid(ctx, tx, bidID)
+	err = acceptBid(ctx, tx, bidID, actorID)
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func acceptBid(ctx context.Context, tx *Tx, bidID int) error {
-	result, err := tx.ExecContext(ctx, `
-		UPDATE bids
-		SET accepted = TRUE
-		WHERE id = ?
+// bidStatusColumn selects the status of a bid, showing active bids past their
+// expiry as expired before expireBids gets to them. It takes the current time
+// as its argument.
+const bidStatusColumn = `CASE WHEN bids.status = 'active' AND bids.expires_at <= ? THEN 'expired' ELSE bids.status END`
+
+func acceptBid(ctx context.Context, tx *Tx, bidID int, actorID string) error {
+	if err := expireBids(ctx, tx); err != nil {
+		return err
+	}
+	bookingID, err := findActiveBid(ctx, tx, bidID)
+	if err != nil {
+		return err
+	}
+	if err := checkBiddingOpen(ctx, tx, bookingID); err != nil {
+		return err
+	}
+
+	if err := setBidStatus(ctx, tx, bidID, app.BidAccepted); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bids SET
+			status = ?,
+			updated_at = ?
+		WHERE booking_id = ? AND status = ?
+		`,
+		app.BidRejected,
+		tx.now,
+		bookingID,
+		app.BidActive,
+	); err != nil {
+		return err
+	}
+
+	// The request takes the default service duration of the provider's
+	// time, which must be free. Its start is stored in the format of
+	// bookings so it is compared with theirs.
+	var providerID, startAt string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT bids.provider_id, bookings.start_at
+		FROM bids
+		JOIN bookings ON bookings.booking_id = bids.booking_id
+		WHERE bids.id = ?
 		`,
 		bidID,
-	)
+	).Scan(&providerID, &startAt); err != nil {
+		return err
+	}
+	start, err := parseTime(startAt)
 	if err != nil {
 		return err
 	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
+	end, err := reserveSlot(ctx, tx, providerID, start, app.DefaultServiceDuration*time.Minute, bookingID)
+	if err != nil {
+		return err
 	}
 
-	// update booking status
+	// Assign the provider before moving the booking on, so the accepted
+	// booking always has someone to carry it out.
 	if _, err := tx.ExecContext(ctx, `
-	UPDATE bookings
-	SET
-		provider_id = (SELECT provider_id FROM bids WHERE id = ?),
-		status = 'pending'
-	WHERE booking_id = (SELECT booking_id FROM bids WHERE id = ?)
-`,
-		bidID,
-		bidID,
+		UPDATE bookings
+		SET provider_id = ?,
+			start_at = ?,
+			end_at = ?
+		WHERE booking_id = ?
+		`,
+		providerID,
+		start.Format(app.DateTimeFormat),
+		end.Format(app.DateTimeFormat),
+		bookingID,
 	); err != nil {
 		return err
 	}
 
-	return nil
+	id, err := uuid.Parse(bookingID)
+	if err != nil {
+		return err
+	}
+	return transitionBooking(ctx, tx, &model.BookingTransition{
+		BookingID: id,
+		Status:    app.BookingAccepted,
+		ActorID:   actorID,
+	})
 }
 
 func (s *RequestService) FilterRequests(ctx context.Context, filter model.RequestFilter) ([]app.Request, error) {
@@ -1214,14 +1409,14 @@ func filterRequests(ctx context.Context, tx *Tx, filter model.RequestFilter) (_
 			bookings.provider_id,
 			CONCAT(users.first_name, ' ', users.last_name) AS provider_name,
 			users.photo_url as provider_photo,
-			(SELECT COUNT(*) FROM bids WHERE bids.booking_id = bookings.booking_id) AS bids_count
+			(SELECT COUNT(*) FROM bids WHERE bids.booking_id = bookings.booking_id AND bids.status IN (?, ?)) AS bids_count
 		FROM bookings
 		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
 		LEFT JOIN users ON users.user_id = providers.user_id
 		LEFT JOIN bids ON bids.booking_id = bookings.booking_id
 		WHERE `+strings.Join(where, " AND ")+`
 		AND bookings.is_request = 1
-	`, args...)
+	`, append([]interface{}{app.BidActive, app.BidAccepted}, args...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -1285,7 +1480,29 @@ func allRequests(ctx context.Context, tx *Tx, filter model.RequestFilter) (_ []a
 	// Values are appended to an arg list to avoid SQL injection.
 	where, args := []string{"1 = 1"}, []interface{}{}
 	if v := filter.Category; v != "" {
-		where, args = append(where, "bookings.category_id = ?"), append(args, v)
+		ids, err := categorySubtree(ctx, tx, v)
+		if err != nil {
+			return nil, err
+		}
+		where, args = append(where, "bookings.category_id IN ("+placeholders(len(ids))+")"), append(args, ids...)
+	}
+
+	// Providers only see requests in their own category and the categories
+	// below it.
+	var providerCategoryID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT category_id FROM providers WHERE user_id = ?
+		`,
+		filter.UserID,
+	).Scan(&providerCategoryID); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if providerCategoryID.Valid {
+		ids, err := categorySubtree(ctx, tx, providerCategoryID.String)
+		if err != nil {
+			return nil, err
+		}
+		where, args = append(where, "bookings.category_id IN ("+placeholders(len(ids))+")"), append(args, ids...)
 	}
 
 	var latitude *float64
@@ -1372,6 +1589,7 @@ func getRequestsCategories(ctx context.Context, tx *Tx) (_ []app.Category, err e
 		FROM bookings
 		INNER JOIN categories ON categories.id = bookings.category_id
 		WHERE bookings.is_request = 1
+		GROUP BY categories.id, categories.name
 	`)
 	if err != nil {
 		return nil, err
@@ -1389,36 +1607,3 @@ func getRequestsCategories(ctx context.Context, tx *Tx) (_ []app.Category, err e
 	}
 	return categories, nil
 }
-
-func (s *BookingService) InsertDate(ctx context.Context, date string) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	err = insertDate(ctx, tx, date)
-	if err != nil {
-		return err
-	}
-	return tx.Commit()
-}
-
-func insertDate(ctx context.Context, tx *Tx, date string) error {
-	result, err := tx.ExecContext(ctx, `
-		INSERT INTO dates (date_id,start_at)
-		VALUES (?,?)
-		`,
-		uuid.New(),
-		date,
-	)
-	if err != nil {
-		return err
-	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
-	}
-
-	return nil
-}
@@ -0,0 +1,364 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+type CancellationPolicyService struct {
+	db *DB
+}
+
+func NewCancellationPolicyService(db *DB) *CancellationPolicyService {
+	return &CancellationPolicyService{db}
+}
+
+func (s *CancellationPolicyService) FindProviderCancellationPolicy(ctx context.Context, userID string) (*app.CancellationPolicy, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findCancellationProviderID(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := listCancellationPolicies(ctx, tx, "provider_id = ?", providerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "You have not set a cancellation policy.")
+	}
+	return policies[0], tx.Commit()
+}
+
+func (s *CancellationPolicyService) SetProviderCancellationPolicy(ctx context.Context, policy *model.CancellationPolicy) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findCancellationProviderID(ctx, tx, policy.UserID)
+	if err != nil {
+		return err
+	}
+	if err := setCancellationPolicy(ctx, tx, policy, &providerID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *CancellationPolicyService) DeleteProviderCancellationPolicy(ctx context.Context, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	providerID, err := findCancellationProviderID(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM cancellation_policies WHERE provider_id = ?
+		`,
+		providerID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return app.Errorf(app.NOTFOUND_ERR, "You have not set a cancellation policy.")
+	}
+	return tx.Commit()
+}
+
+func (s *CancellationPolicyService) ListCancellationPolicies(ctx context.Context) ([]*app.CancellationPolicy, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	policies, err := listCancellationPolicies(ctx, tx, "provider_id IS NULL ORDER BY category_id IS NOT NULL, category_id")
+	if err != nil {
+		return nil, err
+	}
+	return policies, tx.Commit()
+}
+
+func (s *CancellationPolicyService) SetCancellationPolicy(ctx context.Context, policy *model.CancellationPolicy) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if policy.CategoryID != nil {
+		if err := checkExists(ctx, tx, "categories", "category", *policy.CategoryID); err != nil {
+			return err
+		}
+	}
+	if err := setCancellationPolicy(ctx, tx, policy, nil, policy.CategoryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setCancellationPolicy creates or updates the policy of a provider, else of
+// a category, else the default policy.
+func setCancellationPolicy(ctx context.Context, tx *Tx, policy *model.CancellationPolicy, providerID *string, categoryID *int) error {
+	where, args := "provider_id IS NULL AND category_id IS NULL", []interface{}{}
+	switch {
+	case providerID != nil:
+		where, args = "provider_id = ?", append(args, *providerID)
+	case categoryID != nil:
+		where, args = "category_id = ?", append(args, *categoryID)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE cancellation_policies SET
+			free_hours = ?,
+			fee_percent = ?,
+			no_show_percent = ?,
+			updated_at = ?
+		WHERE `+where,
+		append([]interface{}{policy.FreeHours, policy.FeePercent, policy.NoShowPercent, tx.now}, args...)...,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO cancellation_policies (
+			provider_id,
+			category_id,
+			free_hours,
+			fee_percent,
+			no_show_percent,
+			updated_at
+		) VALUES (?,?,?,?,?,?)
+		`,
+		providerID,
+		categoryID,
+		policy.FreeHours,
+		policy.FeePercent,
+		policy.NoShowPercent,
+		tx.now,
+	)
+	return err
+}
+
+// listCancellationPolicies returns the policies matching where, which may
+// end in an ORDER BY clause.
+func listCancellationPolicies(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.CancellationPolicy, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT provider_id, category_id, free_hours, fee_percent, no_show_percent, updated_at
+		FROM cancellation_policies
+		WHERE `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*app.CancellationPolicy{}
+	for rows.Next() {
+		var policy app.CancellationPolicy
+		if err := rows.Scan(
+			&policy.ProviderID,
+			&policy.CategoryID,
+			&policy.FreeHours,
+			&policy.FeePercent,
+			&policy.NoShowPercent,
+			&policy.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		policies = append(policies, &policy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func findCancellationProviderID(ctx context.Context, tx *Tx, userID string) (string, error) {
+	var providerID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT provider_id FROM providers WHERE user_id = ?
+		`,
+		userID,
+	).Scan(&providerID)
+	if err == sql.ErrNoRows {
+		return "", app.Errorf(app.NOTFOUND_ERR, "Only providers have cancellation policies.")
+	}
+	return providerID, err
+}
+
+// findCancellationPolicy returns the policy of a provider, else of a
+// category, else the default policy, or nil if there is none.
+func findCancellationPolicy(ctx context.Context, tx *Tx, providerID sql.NullString, categoryID sql.NullInt64) (*app.CancellationPolicy, error) {
+	policies, err := listCancellationPolicies(ctx, tx, `
+		provider_id = ?
+		OR category_id = ?
+		OR (provider_id IS NULL AND category_id IS NULL)
+		ORDER BY provider_id IS NULL, category_id IS NULL
+		LIMIT 1
+		`,
+		providerID,
+		categoryID,
+	)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	return policies[0], nil
+}
+
+func (s *BookingService) QuoteCancellation(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	decision, err := decideCancellation(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	return decision, tx.Commit()
+}
+
+func (s *BookingService) CancelBooking(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	decision, err := cancelBooking(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	return decision, tx.Commit()
+}
+
+// cancelBooking cancels a booking under its cancellation policy. Every
+// cancellation goes through here so the fee is always applied.
+func cancelBooking(ctx context.Context, tx *Tx, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	decision, err := decideCancellation(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	// The client agrees to the fee they pay for cancelling; a no-show is
+	// reported by the provider.
+	if !c.NoShow && decision.Fee > 0 && (c.AcceptedFee == nil || *c.AcceptedFee != decision.Fee) {
+		return nil, app.Errorf(app.CONFLICT_ERR, "Cancelling now costs %s %d of the %s %d paid. Accept the fee to cancel.", decision.Currency, decision.Fee, decision.Currency, decision.Paid)
+	}
+
+	if decision.Fee > 0 {
+		// Refunding the escrow as the booking is cancelled keeps the fee.
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE escrows SET
+				cancellation_fee = ?,
+				updated_at = ?
+			WHERE booking_id = ? AND status = ?
+			`,
+			decision.Fee,
+			tx.now,
+			c.BookingID,
+			app.EscrowHeld,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	reason := c.Reason
+	if c.NoShow && reason == nil {
+		msg := "The client did not show up."
+		reason = &msg
+	}
+	if err := transitionBooking(ctx, tx, &model.BookingTransition{
+		BookingID: c.BookingID,
+		Status:    app.BookingCancelled,
+		ActorID:   c.ActorID,
+		Reason:    reason,
+	}); err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+// decideCancellation works out what cancelling a booking now costs its client
+// under the booking's policy.
+func decideCancellation(ctx context.Context, tx *Tx, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	var status, start, clientID string
+	var providerID, providerUserID sql.NullString
+	var categoryID sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bookings.status,
+			bookings.start_at,
+			bookings.client_id,
+			bookings.provider_id,
+			providers.user_id,
+			COALESCE(bookings.category_id, services.category_id)
+		FROM bookings
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		LEFT JOIN services ON services.id = bookings.service_id
+		WHERE bookings.booking_id = ?
+		`,
+		c.BookingID,
+	).Scan(&status, &start, &clientID, &providerID, &providerUserID, &categoryID); err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Booking not found.")
+	} else if err != nil {
+		return nil, err
+	}
+	startAt, err := parseTime(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.ValidateBookingTransition(status, app.BookingCancelled); err != nil {
+		return nil, err
+	}
+	now := wallClock(tx.now)
+	if c.NoShow {
+		switch {
+		case !providerUserID.Valid || c.ActorID != providerUserID.String:
+			return nil, app.Errorf(app.FORBIDDEN_ERR, "Only the provider can report that the client did not show up.")
+		case status != app.BookingAccepted:
+			return nil, app.Errorf(app.CONFLICT_ERR, "A booking that is %s cannot be reported as a no-show.", status)
+		case now.Before(startAt):
+			return nil, app.Errorf(app.INVALID_ERR, "The client can only be reported as a no-show after the start.")
+		}
+	}
+
+	policy, err := findCancellationPolicy(ctx, tx, providerID, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fees are only taken from a payment held in escrow.
+	paid, currency := 0, "Ksh"
+	escrow, err := findEscrowState(ctx, tx, c.BookingID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	} else if err == nil && escrow.status == app.EscrowHeld {
+		paid, currency = escrow.amount, escrow.currency
+	}
+
+	decision := app.DecideCancellation(policy, startAt, now, paid, c.ActorID == clientID, c.NoShow)
+	decision.BookingID = c.BookingID.String()
+	decision.Currency = currency
+	return decision, nil
+}
@@ -3,6 +3,9 @@ package sqlite
 import (
 	"context"
 	"log"
+	"sort"
+	"strings"
+	"unicode"
 
 	app "github.com/andrwkng/hudumaapp"
 	"github.com/andrwkng/hudumaapp/model"
@@ -164,8 +167,9 @@ func createCategory(ctx context.Context, tx *Tx, category *model.Category) error
 		name,
 		parent_id,
 		description,
-		icon_url
-	) VALUES (?, ?, ?, ?)
+		icon_url,
+		keywords
+	) VALUES (?, ?, ?, ?, ?)
 	`
 
 	// Insert row into database.
@@ -174,6 +178,7 @@ func createCategory(ctx context.Context, tx *Tx, category *model.Category) error
 		category.ParentID,
 		category.Description,
 		category.IconURL,
+		category.Keywords,
 	)
 	if err != nil {
 		return err
@@ -182,6 +187,172 @@ func createCategory(ctx context.Context, tx *Tx, category *model.Category) error
 	return nil
 }
 
+func (s *CategoryService) SuggestCategories(ctx context.Context, title string) ([]*app.Category, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	categories, err := suggestCategories(ctx, tx, title)
+	if err != nil {
+		return nil, err
+	}
+	return categories, tx.Commit()
+}
+
+// suggestCategories returns the categories whose name or keywords match words
+// of a title, best match first. Deeper categories win ties as they describe
+// the job more precisely.
+func suggestCategories(ctx context.Context, tx *Tx, title string) ([]*app.Category, error) {
+	words := keywordWords(title)
+	if len(words) == 0 {
+		return []*app.Category{}, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			name,
+			parent_id,
+			icon_url,
+			COALESCE(level, 0),
+			COALESCE(keywords, '')
+		FROM categories
+		`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type match struct {
+		category *app.Category
+		level    int
+		score    int
+	}
+	var matches []match
+	for rows.Next() {
+		var category app.Category
+		var level int
+		var keywords string
+		if err := rows.Scan(
+			&category.ID,
+			&category.Name,
+			&category.ParentID,
+			&category.IconURL,
+			&level,
+			&keywords,
+		); err != nil {
+			return nil, err
+		}
+
+		terms := keywordWords(category.Name + " " + keywords)
+		score := 0
+		for _, word := range words {
+			for _, term := range terms {
+				if keywordMatches(word, term) {
+					score++
+					break
+				}
+			}
+		}
+		if score > 0 {
+			matches = append(matches, match{&category, level, score})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].level > matches[j].level
+	})
+	categories := make([]*app.Category, 0, len(matches))
+	for _, m := range matches {
+		categories = append(categories, m.category)
+	}
+	return categories, nil
+}
+
+// categorySubtree returns the ID of a category and of all categories below
+// it, as query arguments.
+func categorySubtree(ctx context.Context, tx *Tx, id string) ([]interface{}, error) {
+	depths, err := categoryDepths(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, 0, len(depths))
+	for categoryID := range depths {
+		ids = append(ids, categoryID)
+	}
+	return ids, nil
+}
+
+// categoryDepths maps the ID of a category and of all categories below it to
+// how many levels below the category they are.
+func categoryDepths(ctx context.Context, tx *Tx, id string) (map[string]int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, parent_id FROM categories WHERE parent_id IS NOT NULL
+		`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := make(map[string][]string)
+	for rows.Next() {
+		var childID, parentID string
+		if err := rows.Scan(&childID, &parentID); err != nil {
+			return nil, err
+		}
+		children[parentID] = append(children[parentID], childID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	depths := map[string]int{id: 0}
+	for queue := []string{id}; len(queue) > 0; queue = queue[1:] {
+		for _, childID := range children[queue[0]] {
+			if _, ok := depths[childID]; !ok {
+				depths[childID] = depths[queue[0]] + 1
+				queue = append(queue, childID)
+			}
+		}
+	}
+	return depths, nil
+}
+
+// keywordWords splits text into lower case words.
+func keywordWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// keywordMatches reports whether a word of a title matches a keyword. Besides
+// equal words, words that start with a keyword of four letters or more, and
+// words sharing their first five letters, match: "glasses" matches "glass"
+// and "plumbing" matches "plumber".
+func keywordMatches(word string, keyword string) bool {
+	if word == keyword {
+		return true
+	}
+	short, long := word, keyword
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	if len(short) >= 4 && strings.HasPrefix(long, short) {
+		return true
+	}
+	return len(short) >= 5 && short[:5] == long[:5]
+}
+
 type IndustryService struct {
 	db *DB
 }
@@ -411,16 +582,22 @@ func createService(ctx context.Context, tx *Tx, service *model.Service) error {
 		provider_id,
 		name,
 		price,
-		category_id
-	) VALUES (?, ?, ?, ?)
+		category_id,
+		duration
+	) VALUES (?, ?, ?, ?, ?)
 	`
 
+	if service.Duration == 0 {
+		service.Duration = app.DefaultServiceDuration
+	}
+
 	// Insert row into database.
 	_, err := tx.ExecContext(ctx, query,
 		service.ProviderID,
 		service.Name,
 		service.Rate.Amount,
 		service.CategoryID,
+		service.Duration,
 	)
 	if err != nil {
 		return err
@@ -464,7 +641,8 @@ func getServicesByProviderID(ctx context.Context, tx *Tx, providerId string) ([]
 			services.name,
 			services.price,
 			services.currency,
-			categories.name
+			categories.name,
+			services.duration
 		FROM services
 		LEFT JOIN categories ON services.category_id = categories.id
 		WHERE provider_id = ?
@@ -481,6 +659,7 @@ func getServicesByProviderID(ctx context.Context, tx *Tx, providerId string) ([]
 			&service.Amount,
 			&service.Currency,
 			&service.Category,
+			&service.Duration,
 		); err != nil {
 			log.Println("rows scan error:", err)
 			return nil, err
@@ -500,7 +679,8 @@ func getServicesByUserID(ctx context.Context, tx *Tx, userId string) ([]*app.Ser
 			services.name,
 			services.price,
 			services.currency,
-			categories.name
+			categories.name,
+			services.duration
 		FROM services
 		LEFT JOIN categories ON services.category_id = categories.id
 		WHERE provider_id IN (
@@ -520,6 +700,7 @@ func getServicesByUserID(ctx context.Context, tx *Tx, userId string) ([]*app.Ser
 			&service.Amount,
 			&service.Currency,
 			&service.Category,
+			&service.Duration,
 		); err != nil {
 			log.Println("rows scan error:", err)
 			return nil, err
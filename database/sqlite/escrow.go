@@ -0,0 +1,430 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+type EscrowService struct {
+	db *DB
+}
+
+func NewEscrowService(db *DB) *EscrowService {
+	return &EscrowService{db}
+}
+
+func (s *EscrowService) FindEscrowByBookingID(ctx context.Context, bookingID uuid.UUID) (*app.Escrow, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var escrow app.Escrow
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			escrow_id,
+			booking_id,
+			client_id,
+			provider_user_id,
+			amount,
+			commission,
+			cancellation_fee,
+			currency,
+			status,
+			held_at,
+			settled_at,
+			created_at
+		FROM escrows
+		WHERE booking_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+		`,
+		bookingID,
+	).Scan(
+		&escrow.EscrowID,
+		&escrow.BookingID,
+		&escrow.ClientID,
+		&escrow.ProviderUserID,
+		&escrow.Amount,
+		&escrow.Commission,
+		&escrow.CancellationFee,
+		&escrow.Currency,
+		&escrow.Status,
+		&escrow.HeldAt,
+		&escrow.SettledAt,
+		&escrow.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &escrow, tx.Commit()
+}
+
+// CompleteStartedBookings completes the bookings that went in progress before
+// the window and have not been completed or disputed since.
+func (s *EscrowService) CompleteStartedBookings(ctx context.Context, window time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT bookings.booking_id
+		FROM bookings
+		WHERE bookings.status = ? AND EXISTS (
+			SELECT 1
+			FROM booking_events
+			WHERE booking_events.booking_id = bookings.booking_id
+			AND booking_events.to_status = ?
+			AND booking_events.created_at <= ?
+		)
+		`,
+		app.BookingInProgress,
+		app.BookingInProgress,
+		tx.now.Add(-window),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var bookingIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bookingIDs = append(bookingIDs, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	reason := "Completed automatically as nothing was reported about the job."
+	for _, id := range bookingIDs {
+		if err := transitionBooking(ctx, tx, &model.BookingTransition{
+			BookingID: id,
+			Status:    app.BookingCompleted,
+			ActorID:   app.SystemActor,
+			Reason:    &reason,
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return len(bookingIDs), tx.Commit()
+}
+
+// updateEscrow follows a booking that moved to status with its current
+// escrow, the latest one opened for it.
+func updateEscrow(ctx context.Context, tx *Tx, bookingID uuid.UUID, status string) error {
+	escrow, err := findEscrowState(ctx, tx, bookingID)
+	if err == sql.ErrNoRows {
+		if status == app.BookingAccepted {
+			return openEscrow(ctx, tx, bookingID)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch {
+	case status == app.BookingAccepted && escrowClosed(escrow.status):
+		// A request re-opened and accepted again after its escrow was
+		// cancelled or refunded gets a new one.
+		return openEscrow(ctx, tx, bookingID)
+	case status == app.BookingCompleted && escrow.status == app.EscrowHeld:
+		return releaseEscrow(ctx, tx, escrow)
+	case status == app.BookingCompleted && escrow.status == app.EscrowAwaitingPayment:
+		return issueBookingInvoice(ctx, tx, escrow)
+	case status == app.BookingCancelled && escrow.status == app.EscrowHeld:
+		return refundEscrow(ctx, tx, escrow)
+	case status == app.BookingCancelled && escrow.status == app.EscrowAwaitingPayment:
+		if err := voidBookingInvoice(ctx, tx, escrow.bookingID); err != nil {
+			return err
+		}
+		return setEscrowStatus(ctx, tx, escrow.id, app.EscrowCancelled)
+	}
+	return nil
+}
+
+// escrowClosed reports whether an escrow in status will no longer hold funds
+// for its booking.
+func escrowClosed(status string) bool {
+	return status == app.EscrowCancelled || status == app.EscrowRefunding || status == app.EscrowRefunded
+}
+
+// escrowState is what following a booking needs to know about its escrow.
+type escrowState struct {
+	id             string
+	bookingID      string
+	clientID       string
+	providerUserID string
+	amount         int
+	commission     int
+	// cancellationFee is kept from the refund of a cancelled booking.
+	cancellationFee int
+	currency        string
+	status          string
+}
+
+func findEscrowState(ctx context.Context, tx *Tx, bookingID uuid.UUID) (*escrowState, error) {
+	var escrow escrowState
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			escrow_id,
+			booking_id,
+			client_id,
+			provider_user_id,
+			amount,
+			commission,
+			cancellation_fee,
+			currency,
+			status
+		FROM escrows
+		WHERE booking_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+		`,
+		bookingID,
+	).Scan(
+		&escrow.id,
+		&escrow.bookingID,
+		&escrow.clientID,
+		&escrow.providerUserID,
+		&escrow.amount,
+		&escrow.commission,
+		&escrow.cancellationFee,
+		&escrow.currency,
+		&escrow.status,
+	); err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// openEscrow opens the escrow of a booking that was just accepted. Its amount
+// is the accepted bid, or else the price of the booked service, and the
+// commission is worked out from the rate of the booking's category. Bookings
+// without an amount are not paid through escrow.
+func openEscrow(ctx context.Context, tx *Tx, bookingID uuid.UUID) error {
+	var clientID string
+	var providerUserID sql.NullString
+	var amount, categoryID sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bookings.client_id,
+			providers.user_id,
+			COALESCE(
+				(SELECT bids.amount FROM bids WHERE bids.booking_id = bookings.booking_id AND bids.status = ? LIMIT 1),
+				services.price
+			),
+			COALESCE(bookings.category_id, services.category_id)
+		FROM bookings
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		LEFT JOIN services ON services.id = bookings.service_id
+		WHERE bookings.booking_id = ?
+		`,
+		app.BidAccepted,
+		bookingID,
+	).Scan(&clientID, &providerUserID, &amount, &categoryID); err != nil {
+		return err
+	}
+	if !providerUserID.Valid || !amount.Valid || amount.Int64 <= 0 {
+		return nil
+	}
+
+	percent, err := findCommissionPercent(ctx, tx, categoryID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO escrows (
+			escrow_id,
+			booking_id,
+			client_id,
+			provider_user_id,
+			amount,
+			commission,
+			currency,
+			status,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?)
+		`,
+		uuid.NewString(),
+		bookingID,
+		clientID,
+		providerUserID.String,
+		amount.Int64,
+		commission(int(amount.Int64), percent),
+		"Ksh",
+		app.EscrowAwaitingPayment,
+		tx.now,
+		tx.now,
+	)
+	return err
+}
+
+func setEscrowStatus(ctx context.Context, tx *Tx, escrowID string, status string) error {
+	var settledAt *time.Time
+	if status == app.EscrowReleased || status == app.EscrowRefunded || status == app.EscrowCancelled {
+		settledAt = &tx.now
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE escrows SET
+			status = ?,
+			settled_at = ?,
+			updated_at = ?
+		WHERE escrow_id = ?
+		`,
+		status,
+		settledAt,
+		tx.now,
+		escrowID,
+	)
+	return err
+}
+
+// releaseEscrow credits the funds of an escrow, less the commission, to the
+// earnings of the provider and issues the client's receipt.
+func releaseEscrow(ctx context.Context, tx *Tx, escrow *escrowState) error {
+	if err := setEscrowStatus(ctx, tx, escrow.id, app.EscrowReleased); err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("Earnings for booking, less %s %d commission", escrow.currency, escrow.commission)
+	if err := createTransaction(ctx, tx, &model.Transaction{
+		UserID:           escrow.providerUserID,
+		BookingID:        &escrow.bookingID,
+		Direction:        app.TransactionCredit,
+		Method:           app.EarningsMethod,
+		AccountReference: escrow.id,
+		Amount:           escrow.amount - escrow.commission,
+		Currency:         escrow.currency,
+		Status:           app.TransactionCompleted,
+		Description:      description,
+	}); err != nil {
+		return err
+	}
+	return issueBookingReceipt(ctx, tx, escrow)
+}
+
+// refundEscrow sends the funds of an escrow back to the client's phone. A
+// cancellation fee kept from them is credited, less its share of the
+// commission, to the earnings of the provider.
+func refundEscrow(ctx context.Context, tx *Tx, escrow *escrowState) error {
+	refund := escrow.amount - escrow.cancellationFee
+	if escrow.cancellationFee > 0 {
+		fee := escrow.cancellationFee
+		feeCommission := int(math.Round(float64(escrow.commission) * float64(fee) / float64(escrow.amount)))
+		description := fmt.Sprintf("Cancellation fee for booking, less %s %d commission", escrow.currency, feeCommission)
+		if err := createTransaction(ctx, tx, &model.Transaction{
+			UserID:           escrow.providerUserID,
+			BookingID:        &escrow.bookingID,
+			Direction:        app.TransactionCredit,
+			Method:           app.EarningsMethod,
+			AccountReference: escrow.id,
+			Amount:           fee - feeCommission,
+			Currency:         escrow.currency,
+			Status:           app.TransactionCompleted,
+			Description:      description,
+		}); err != nil {
+			return err
+		}
+	}
+	if refund <= 0 {
+		// The fee took all of it, so nothing goes back to the client.
+		return setEscrowStatus(ctx, tx, escrow.id, app.EscrowReleased)
+	}
+
+	if err := setEscrowStatus(ctx, tx, escrow.id, app.EscrowRefunding); err != nil {
+		return err
+	}
+
+	var phone string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT phone FROM users WHERE user_id = ?
+		`,
+		escrow.clientID,
+	).Scan(&phone); err != nil {
+		return err
+	}
+
+	description := "Refund for booking"
+	if escrow.cancellationFee > 0 {
+		description = fmt.Sprintf("Refund for booking, less %s %d cancellation fee", escrow.currency, escrow.cancellationFee)
+	}
+	_, err := createPayout(ctx, tx, &payoutRequest{
+		userID:      escrow.clientID,
+		escrowID:    escrow.id,
+		bookingID:   escrow.bookingID,
+		phone:       phone,
+		amount:      refund,
+		currency:    escrow.currency,
+		direction:   app.TransactionCredit,
+		description: description,
+	})
+	return err
+}
+
+// holdEscrow moves the client's payment for a booking into its escrow. If the
+// booking was completed before the payment arrived the funds are released
+// straight away, and if it was cancelled they are refunded.
+func holdEscrow(ctx context.Context, tx *Tx, bookingID string) error {
+	id, err := uuid.Parse(bookingID)
+	if err != nil {
+		return err
+	}
+	escrow, err := findEscrowState(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		log.Printf("payment for booking %s without escrow", bookingID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if escrow.status != app.EscrowAwaitingPayment && escrow.status != app.EscrowCancelled {
+		log.Printf("payment for booking %s whose escrow is %s", bookingID, escrow.status)
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE escrows SET
+			status = ?,
+			settled_at = NULL,
+			held_at = ?,
+			updated_at = ?
+		WHERE escrow_id = ?
+		`,
+		app.EscrowHeld,
+		tx.now,
+		tx.now,
+		escrow.id,
+	); err != nil {
+		return err
+	}
+
+	var bookingStatus string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT status FROM bookings WHERE booking_id = ?
+		`,
+		bookingID,
+	).Scan(&bookingStatus); err != nil {
+		return err
+	}
+	switch {
+	case escrow.status == app.EscrowCancelled || bookingStatus == app.BookingCancelled:
+		return refundEscrow(ctx, tx, escrow)
+	case bookingStatus == app.BookingCompleted:
+		return releaseEscrow(ctx, tx, escrow)
+	}
+	return nil
+}
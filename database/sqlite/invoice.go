@@ -0,0 +1,463 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/google/uuid"
+)
+
+// invoicePrefixes prefix the numbers of each kind of invoice.
+var invoicePrefixes = map[string]string{
+	app.InvoiceKind: "INV",
+	app.ReceiptKind: "RCT",
+}
+
+type InvoiceService struct {
+	db *DB
+}
+
+func NewInvoiceService(db *DB) *InvoiceService {
+	return &InvoiceService{db}
+}
+
+func (s *InvoiceService) FindInvoiceByID(ctx context.Context, id string, userID string) (*app.Invoice, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invoices, err := listInvoices(ctx, tx, "invoice_id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return invoices[0], tx.Commit()
+}
+
+func (s *InvoiceService) ListInvoices(ctx context.Context, userID string) ([]*app.Invoice, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invoices, err := listInvoices(ctx, tx, "user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	return invoices, tx.Commit()
+}
+
+func listInvoices(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.Invoice, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			invoice_id,
+			kind,
+			number,
+			user_id,
+			booking_id,
+			subscription_id,
+			transaction_id,
+			billed_to,
+			phone,
+			currency,
+			subtotal,
+			tax_rate,
+			tax,
+			total,
+			status,
+			issued_at,
+			paid_at
+		FROM invoices
+		WHERE `+where+`
+		ORDER BY issued_at DESC, seq DESC
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := []*app.Invoice{}
+	for rows.Next() {
+		var invoice app.Invoice
+		if err := rows.Scan(
+			&invoice.InvoiceID,
+			&invoice.Kind,
+			&invoice.Number,
+			&invoice.UserID,
+			&invoice.BookingID,
+			&invoice.SubscriptionID,
+			&invoice.TransactionID,
+			&invoice.BilledTo,
+			&invoice.Phone,
+			&invoice.Currency,
+			&invoice.Subtotal,
+			&invoice.TaxRate,
+			&invoice.Tax,
+			&invoice.Total,
+			&invoice.Status,
+			&invoice.IssuedAt,
+			&invoice.PaidAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invoices = append(invoices, &invoice)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, invoice := range invoices {
+		if invoice.Items, err = listInvoiceItems(ctx, tx, invoice.InvoiceID); err != nil {
+			return nil, err
+		}
+	}
+	return invoices, nil
+}
+
+func listInvoiceItems(ctx context.Context, tx *Tx, invoiceID string) ([]*app.InvoiceItem, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			description,
+			quantity,
+			unit_amount,
+			amount
+		FROM invoice_items
+		WHERE invoice_id = ?
+		ORDER BY position
+		`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*app.InvoiceItem{}
+	for rows.Next() {
+		var item app.InvoiceItem
+		if err := rows.Scan(
+			&item.Description,
+			&item.Quantity,
+			&item.UnitAmount,
+			&item.Amount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
+
+// invoiceRequest is an invoice or receipt to issue to a user.
+type invoiceRequest struct {
+	kind           string
+	userID         string
+	bookingID      string
+	subscriptionID string
+	transactionID  string
+	currency       string
+	status         string
+	items          []*app.InvoiceItem
+}
+
+// nextInvoiceSeq takes the next number of an invoice kind. The counter row is
+// updated before it is read, so invoices issued at the same time wait for each
+// other instead of taking the same number.
+func nextInvoiceSeq(ctx context.Context, tx *Tx, kind string) (int, error) {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE invoice_sequences SET seq = seq + 1 WHERE kind = ?
+		`,
+		kind,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, fmt.Errorf("no invoice sequence for kind %q", kind)
+	}
+
+	var seq int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT seq FROM invoice_sequences WHERE kind = ?
+		`,
+		kind,
+	).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// issueInvoice numbers and stores an invoice. Its total is the sum of its
+// items, which include tax at the rate of the database.
+func issueInvoice(ctx context.Context, tx *Tx, r *invoiceRequest) error {
+	var username, phone string
+	var firstName, lastName sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT username, phone, first_name, last_name FROM users WHERE user_id = ?
+		`,
+		r.userID,
+	).Scan(&username, &phone, &firstName, &lastName); err != nil {
+		return err
+	}
+	billedTo := strings.TrimSpace(firstName.String + " " + lastName.String)
+	if billedTo == "" {
+		billedTo = username
+	}
+
+	seq, err := nextInvoiceSeq(ctx, tx, r.kind)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, item := range r.items {
+		total += item.Amount
+	}
+	taxRate := tx.db.TaxRate
+	tax := int(math.Round(float64(total) * taxRate / (100 + taxRate)))
+
+	var paidAt *time.Time
+	if r.status == app.InvoicePaid {
+		paidAt = &tx.now
+	}
+
+	invoiceID := uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO invoices (
+			invoice_id,
+			kind,
+			seq,
+			number,
+			user_id,
+			booking_id,
+			subscription_id,
+			transaction_id,
+			billed_to,
+			phone,
+			currency,
+			subtotal,
+			tax_rate,
+			tax,
+			total,
+			status,
+			issued_at,
+			paid_at,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		invoiceID,
+		r.kind,
+		seq,
+		fmt.Sprintf("%s-%06d", invoicePrefixes[r.kind], seq),
+		r.userID,
+		nullString(r.bookingID),
+		nullString(r.subscriptionID),
+		nullString(r.transactionID),
+		billedTo,
+		phone,
+		r.currency,
+		total-tax,
+		taxRate,
+		tax,
+		total,
+		r.status,
+		tx.now,
+		paidAt,
+		tx.now,
+		tx.now,
+	); err != nil {
+		return err
+	}
+
+	for i, item := range r.items {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO invoice_items (
+				item_id,
+				invoice_id,
+				position,
+				description,
+				quantity,
+				unit_amount,
+				amount
+			) VALUES (?,?,?,?,?,?,?)
+			`,
+			uuid.NewString(),
+			invoiceID,
+			i,
+			item.Description,
+			item.Quantity,
+			item.UnitAmount,
+			item.Amount,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bookingInvoiceItems returns the line items of the escrow of a booking: the
+// booked service, or the request with its accepted bid.
+func bookingInvoiceItems(ctx context.Context, tx *Tx, escrow *escrowState) ([]*app.InvoiceItem, error) {
+	var serviceName, title sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT services.name, bookings.title
+		FROM bookings
+		LEFT JOIN services ON services.id = bookings.service_id
+		WHERE bookings.booking_id = ?
+		`,
+		escrow.bookingID,
+	).Scan(&serviceName, &title); err != nil {
+		return nil, err
+	}
+
+	description := serviceName.String
+	if !serviceName.Valid {
+		description = title.String + " (accepted bid)"
+	}
+	return []*app.InvoiceItem{{
+		Description: description,
+		Quantity:    1,
+		UnitAmount:  escrow.amount,
+		Amount:      escrow.amount,
+	}}, nil
+}
+
+// issueBookingInvoice issues the invoice of a booking completed before it was
+// paid for. Bookings are invoiced only once, even if they are completed again
+// after a dispute, unless their invoice was voided.
+func issueBookingInvoice(ctx context.Context, tx *Tx, escrow *escrowState) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM invoices WHERE booking_id = ? AND kind = ? AND status <> ?
+		`,
+		escrow.bookingID,
+		app.InvoiceKind,
+		app.InvoiceVoid,
+	).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	items, err := bookingInvoiceItems(ctx, tx, escrow)
+	if err != nil {
+		return err
+	}
+	return issueInvoice(ctx, tx, &invoiceRequest{
+		kind:      app.InvoiceKind,
+		userID:    escrow.clientID,
+		bookingID: escrow.bookingID,
+		currency:  escrow.currency,
+		status:    app.InvoiceIssued,
+		items:     items,
+	})
+}
+
+// issueBookingReceipt issues the receipt of a booking whose escrow was
+// released, and marks its invoice, if it has one, as paid.
+func issueBookingReceipt(ctx context.Context, tx *Tx, escrow *escrowState) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE invoices SET
+			status = ?,
+			paid_at = ?,
+			updated_at = ?
+		WHERE booking_id = ? AND kind = ? AND status = ?
+		`,
+		app.InvoicePaid,
+		tx.now,
+		tx.now,
+		escrow.bookingID,
+		app.InvoiceKind,
+		app.InvoiceIssued,
+	); err != nil {
+		return err
+	}
+
+	var transactionID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT transaction_id
+		FROM transactions
+		WHERE booking_id = ? AND user_id = ? AND direction = ? AND status = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+		`,
+		escrow.bookingID,
+		escrow.clientID,
+		app.TransactionDebit,
+		app.TransactionCompleted,
+	).Scan(&transactionID); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	items, err := bookingInvoiceItems(ctx, tx, escrow)
+	if err != nil {
+		return err
+	}
+	return issueInvoice(ctx, tx, &invoiceRequest{
+		kind:          app.ReceiptKind,
+		userID:        escrow.clientID,
+		bookingID:     escrow.bookingID,
+		transactionID: transactionID.String,
+		currency:      escrow.currency,
+		status:        app.InvoicePaid,
+		items:         items,
+	})
+}
+
+// voidBookingInvoice voids the unpaid invoice of a booking that was
+// cancelled.
+func voidBookingInvoice(ctx context.Context, tx *Tx, bookingID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE invoices SET
+			status = ?,
+			updated_at = ?
+		WHERE booking_id = ? AND kind = ? AND status = ?
+		`,
+		app.InvoiceVoid,
+		tx.now,
+		bookingID,
+		app.InvoiceKind,
+		app.InvoiceIssued,
+	)
+	return err
+}
+
+// issueSubscriptionReceipt issues the receipt of the payment for a period of
+// a subscription.
+func issueSubscriptionReceipt(ctx context.Context, tx *Tx, clientID string, subscriptionID string, paymentID string, plan *app.Plan, start time.Time) error {
+	description := fmt.Sprintf("%s plan, %s to %s",
+		plan.Name,
+		start.Format("2 Jan 2006"),
+		plan.PeriodEnd(start).Format("2 Jan 2006"),
+	)
+	return issueInvoice(ctx, tx, &invoiceRequest{
+		kind:           app.ReceiptKind,
+		userID:         clientID,
+		subscriptionID: subscriptionID,
+		transactionID:  paymentID,
+		currency:       plan.Currency,
+		status:         app.InvoicePaid,
+		items: []*app.InvoiceItem{{
+			Description: description,
+			Quantity:    1,
+			UnitAmount:  plan.Price,
+			Amount:      plan.Price,
+		}},
+	})
+}
@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+func (s *BookingService) TransitionBooking(ctx context.Context, t *model.BookingTransition) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Cancellations go through CancelBooking so the cancellation policy
+	// applies.
+	if t.Status == app.BookingCancelled {
+		if _, err := cancelBooking(ctx, tx, &model.BookingCancellation{
+			BookingID: t.BookingID,
+			ActorID:   t.ActorID,
+			Reason:    t.Reason,
+		}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err := transitionBooking(ctx, tx, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transitionBooking moves a booking to a new status after checking the move
+// against the booking lifecycle, records it in booking_events, cancels a
+// pending proposal to reschedule it that no longer applies and updates the
+// escrow of the booking.
+func transitionBooking(ctx context.Context, tx *Tx, t *model.BookingTransition) error {
+	var from string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT status
+		FROM bookings
+		WHERE booking_id = ?
+		`,
+		t.BookingID,
+	).Scan(&from); err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Booking not found.")
+	} else if err != nil {
+		return err
+	}
+
+	if err := app.ValidateBookingTransition(from, t.Status); err != nil {
+		return err
+	}
+
+	// Only update the row if nobody else moved the booking in the meantime.
+	result, err := tx.ExecContext(ctx, `
+		UPDATE bookings
+		SET status = ?, updated_at = ?
+		WHERE booking_id = ? AND status = ?
+		`,
+		t.Status,
+		tx.now,
+		t.BookingID,
+		from,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return app.Errorf(app.CONFLICT_ERR, "Booking was modified concurrently.")
+	}
+
+	if err := createBookingEvent(ctx, tx, t.BookingID, &from, t.Status, t.ActorID, t.Reason); err != nil {
+		return err
+	}
+	if err := cancelPendingReschedules(ctx, tx, t.BookingID, t.Status); err != nil {
+		return err
+	}
+	return updateEscrow(ctx, tx, t.BookingID, t.Status)
+}
+
+// createBookingEvent records a status change of a booking. from is nil for the
+// event written when the booking is created.
+func createBookingEvent(ctx context.Context, tx *Tx, bookingID uuid.UUID, from *string, to string, actorID string, reason *string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_events (
+			booking_id,
+			from_status,
+			to_status,
+			actor_id,
+			reason,
+			created_at
+		) VALUES (?,?,?,?,?,?)
+		`,
+		bookingID,
+		from,
+		to,
+		actorID,
+		reason,
+		tx.now,
+	)
+	return err
+}
+
+func (s *BookingService) ListBookingEvents(ctx context.Context, bookingID uuid.UUID) ([]*app.BookingEvent, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	events, err := listBookingEvents(ctx, tx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func listBookingEvents(ctx context.Context, tx *Tx, bookingID uuid.UUID) ([]*app.BookingEvent, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			booking_id,
+			from_status,
+			to_status,
+			actor_id,
+			reason,
+			reschedule_id,
+			created_at
+		FROM booking_events
+		WHERE booking_id = ?
+		ORDER BY id
+		`,
+		bookingID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*app.BookingEvent, 0)
+	for rows.Next() {
+		var event app.BookingEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.BookingID,
+			&event.FromStatus,
+			&event.ToStatus,
+			&event.ActorID,
+			&event.Reason,
+			&event.RescheduleID,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,13 @@
+CREATE TABLE booking_events (
+    id INTEGER PRIMARY KEY AUTO_INCREMENT,
+    booking_id VARCHAR(255) NOT NULL,
+    from_status VARCHAR(255),
+    to_status VARCHAR(255) NOT NULL,
+    actor_id VARCHAR(255) NOT NULL,
+    reason TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (booking_id) REFERENCES bookings(booking_id)
+);
+
+UPDATE bookings SET status = 'accepted' WHERE status = 'pending';
+UPDATE bookings SET status = 'cancelled' WHERE status = 'canceled';
@@ -0,0 +1,12 @@
+CREATE TABLE verification_codes (
+    id INTEGER PRIMARY KEY AUTO_INCREMENT,
+    phone VARCHAR(255) NOT NULL,
+    purpose VARCHAR(255) NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    consumed_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX verification_codes_phone_purpose ON verification_codes (phone, purpose);
@@ -0,0 +1,67 @@
+DROP TABLE IF EXISTS plans;
+
+CREATE TABLE plans (
+    plan_id VARCHAR(255) PRIMARY KEY,
+    code VARCHAR(255) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    price INT NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    interval_count INT NOT NULL DEFAULT 1,
+    interval_unit VARCHAR(255) NOT NULL,
+    trial_days INT NOT NULL DEFAULT 0,
+    features TEXT,
+    status VARCHAR(255) NOT NULL DEFAULT 'active',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO plans (
+    plan_id,
+    code,
+    name,
+    description,
+    price,
+    currency,
+    interval_count,
+    interval_unit,
+    trial_days,
+    features,
+    status
+) VALUES (
+    '98975604-428c-4335-9474-b99110b2d019',
+    'weekly',
+    'Weekly',
+    '24km distance',
+    199,
+    'Ksh',
+    1,
+    'week',
+    0,
+    '{"search_radius_km":24,"premium":false}',
+    'active'
+),(
+    '098974ab-441c-4aa9-bf9c-40c6a95bdb5d',
+    'monthly',
+    'Monthly',
+    '24km distance + distance',
+    999,
+    'Ksh',
+    1,
+    'month',
+    0,
+    '{"search_radius_km":48,"premium":false}',
+    'active'
+),(
+    '3363ace9-4c7e-482b-8f3b-ae66f7f88f62',
+    'yearly',
+    'Yearly',
+    '24km distance + distance + premium',
+    999,
+    'Ksh',
+    1,
+    'year',
+    0,
+    '{"search_radius_km":48,"premium":true}',
+    'active'
+);
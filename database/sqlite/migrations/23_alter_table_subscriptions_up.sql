@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN payment_method_id VARCHAR(255) DEFAULT NULL;
+CREATE INDEX subscriptions_client_status ON subscriptions (client_id, status);
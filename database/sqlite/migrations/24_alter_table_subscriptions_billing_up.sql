@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions ADD COLUMN failed_charges INT NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN next_attempt_at DATETIME DEFAULT NULL;
+ALTER TABLE subscriptions ADD COLUMN locked_by VARCHAR(255) DEFAULT NULL;
+ALTER TABLE subscriptions ADD COLUMN locked_until DATETIME DEFAULT NULL;
@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS transactions;
+
+CREATE TABLE transactions (
+    transaction_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) DEFAULT NULL,
+    booking_id VARCHAR(255) DEFAULT NULL,
+    subscription_id VARCHAR(255) DEFAULT NULL,
+    method VARCHAR(255) NOT NULL,
+    -- CheckoutRequestID of STK pushes.
+    reference VARCHAR(255) DEFAULT NULL UNIQUE,
+    -- M-Pesa receipt number, the TransID of C2B payments.
+    receipt VARCHAR(255) DEFAULT NULL UNIQUE,
+    account_reference VARCHAR(255) NOT NULL,
+    phone VARCHAR(255) DEFAULT NULL,
+    amount INT NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    result_code VARCHAR(255) DEFAULT NULL,
+    result_desc TEXT,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX transactions_account_reference ON transactions (account_reference);
+
+ALTER TABLE bookings ADD COLUMN paid_at DATETIME DEFAULT NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE transactions ADD COLUMN direction VARCHAR(255) NOT NULL DEFAULT 'debit';
+
+ALTER TABLE transactions ADD COLUMN description TEXT;
+
+CREATE INDEX transactions_user_id_created_at ON transactions (user_id, created_at);
@@ -0,0 +1,18 @@
+CREATE TABLE payment_methods (
+    payment_method_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    method VARCHAR(255) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    phone VARCHAR(255) DEFAULT NULL,
+    -- Reference of the card at the card processor. Card numbers are never
+    -- stored.
+    card_token VARCHAR(255) DEFAULT NULL,
+    card_last4 VARCHAR(4) DEFAULT NULL,
+    card_expiry VARCHAR(5) DEFAULT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    verified_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX payment_methods_user_id ON payment_methods (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE escrows (
+    escrow_id VARCHAR(255) PRIMARY KEY,
+    booking_id VARCHAR(255) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    -- ConversationID of the payout releasing or refunding the funds.
+    payout_reference VARCHAR(255) DEFAULT NULL UNIQUE,
+    locked_by VARCHAR(255) DEFAULT NULL,
+    locked_until DATETIME DEFAULT NULL,
+    held_at DATETIME DEFAULT NULL,
+    settled_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX escrows_status ON escrows (status);
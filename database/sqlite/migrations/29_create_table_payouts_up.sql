@@ -0,0 +1,90 @@
+-- Payouts move from the escrows to their own table, shared by refunds and
+-- withdrawals of provider earnings.
+CREATE TABLE escrows_new (
+    escrow_id VARCHAR(255) PRIMARY KEY,
+    booking_id VARCHAR(255) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    -- Platform commission kept from the amount when it is released.
+    commission INT NOT NULL DEFAULT 0,
+    currency VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    held_at DATETIME DEFAULT NULL,
+    settled_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+INSERT INTO escrows_new (
+    escrow_id,
+    booking_id,
+    client_id,
+    provider_user_id,
+    amount,
+    currency,
+    status,
+    held_at,
+    settled_at,
+    created_at,
+    updated_at
+) SELECT
+    escrow_id,
+    booking_id,
+    client_id,
+    provider_user_id,
+    amount,
+    currency,
+    status,
+    held_at,
+    settled_at,
+    created_at,
+    updated_at
+FROM escrows;
+
+DROP TABLE escrows;
+
+ALTER TABLE escrows_new RENAME TO escrows;
+
+CREATE INDEX escrows_status ON escrows (status);
+
+CREATE INDEX escrows_provider_user_id ON escrows (provider_user_id);
+
+CREATE TABLE payouts (
+    payout_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    -- Set for refunds of an escrow.
+    escrow_id VARCHAR(255) DEFAULT NULL,
+    phone VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    description TEXT,
+    -- ConversationID of the B2C payment.
+    reference VARCHAR(255) DEFAULT NULL UNIQUE,
+    receipt VARCHAR(255) DEFAULT NULL,
+    result_desc TEXT,
+    locked_by VARCHAR(255) DEFAULT NULL,
+    locked_until DATETIME DEFAULT NULL,
+    paid_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX payouts_user_id_created_at ON payouts (user_id, created_at);
+
+CREATE INDEX payouts_status ON payouts (status);
+
+-- Commission rates of categories and industries. The row without either is
+-- the default rate.
+CREATE TABLE commission_rates (
+    id INTEGER PRIMARY KEY AUTO_INCREMENT,
+    category_id INT(20) DEFAULT NULL UNIQUE,
+    industry_id INTEGER DEFAULT NULL UNIQUE,
+    percent DECIMAL(5,2) NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (category_id) REFERENCES categories(id),
+    FOREIGN KEY (industry_id) REFERENCES industries(id)
+);
+
+INSERT INTO commission_rates (percent, updated_at) VALUES (10, CURRENT_TIMESTAMP);
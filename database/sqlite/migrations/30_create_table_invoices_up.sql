@@ -0,0 +1,43 @@
+-- Invoices and receipts of booking and subscription charges. Each kind is
+-- numbered in its own sequence.
+CREATE TABLE invoices (
+    invoice_id VARCHAR(255) PRIMARY KEY,
+    kind VARCHAR(255) NOT NULL,
+    seq INT NOT NULL,
+    number VARCHAR(255) NOT NULL UNIQUE,
+    user_id VARCHAR(255) NOT NULL,
+    booking_id VARCHAR(255) DEFAULT NULL,
+    subscription_id VARCHAR(255) DEFAULT NULL,
+    transaction_id VARCHAR(255) DEFAULT NULL,
+    billed_to VARCHAR(255) NOT NULL,
+    phone VARCHAR(255) NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    -- Prices include tax at tax_rate percent.
+    subtotal INT NOT NULL,
+    tax_rate DECIMAL(5,2) NOT NULL,
+    tax INT NOT NULL,
+    total INT NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    issued_at DATETIME NOT NULL,
+    paid_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE (kind, seq)
+);
+
+CREATE INDEX invoices_user_id ON invoices (user_id);
+
+CREATE INDEX invoices_booking_id ON invoices (booking_id);
+
+CREATE TABLE invoice_items (
+    item_id VARCHAR(255) PRIMARY KEY,
+    invoice_id VARCHAR(255) NOT NULL,
+    position INT NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL,
+    unit_amount INT NOT NULL,
+    amount INT NOT NULL,
+    FOREIGN KEY (invoice_id) REFERENCES invoices(invoice_id)
+);
+
+CREATE INDEX invoice_items_invoice_id ON invoice_items (invoice_id);
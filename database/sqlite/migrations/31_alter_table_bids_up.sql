@@ -0,0 +1,13 @@
+-- Bids move through statuses instead of only being accepted. The accepted
+-- column is no longer used.
+ALTER TABLE bids ADD COLUMN status VARCHAR(255) NOT NULL DEFAULT 'active';
+
+ALTER TABLE bids ADD COLUMN message TEXT;
+
+ALTER TABLE bids ADD COLUMN eta DATETIME DEFAULT NULL;
+
+ALTER TABLE bids ADD COLUMN expires_at DATETIME DEFAULT NULL;
+
+UPDATE bids SET status = 'accepted' WHERE accepted = TRUE;
+
+CREATE INDEX bids_booking_id_status ON bids (booking_id, status);
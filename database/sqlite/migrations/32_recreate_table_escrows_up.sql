@@ -0,0 +1,55 @@
+-- A request re-opened after its provider cancelled gets a new escrow, so a
+-- booking may have several. The latest is the current one.
+CREATE TABLE escrows_new (
+    escrow_id VARCHAR(255) PRIMARY KEY,
+    booking_id VARCHAR(255) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    -- Platform commission kept from the amount when it is released.
+    commission INT NOT NULL DEFAULT 0,
+    currency VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    held_at DATETIME DEFAULT NULL,
+    settled_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+INSERT INTO escrows_new (
+    escrow_id,
+    booking_id,
+    client_id,
+    provider_user_id,
+    amount,
+    commission,
+    currency,
+    status,
+    held_at,
+    settled_at,
+    created_at,
+    updated_at
+) SELECT
+    escrow_id,
+    booking_id,
+    client_id,
+    provider_user_id,
+    amount,
+    commission,
+    currency,
+    status,
+    held_at,
+    settled_at,
+    created_at,
+    updated_at
+FROM escrows;
+
+DROP TABLE escrows;
+
+ALTER TABLE escrows_new RENAME TO escrows;
+
+CREATE INDEX escrows_booking_id ON escrows (booking_id, created_at);
+
+CREATE INDEX escrows_status ON escrows (status);
+
+CREATE INDEX escrows_provider_user_id ON escrows (provider_user_id);
@@ -0,0 +1,5 @@
+-- Keywords are matched against the titles of requests to suggest a category
+-- for them. They are separated by commas.
+ALTER TABLE categories ADD COLUMN keywords TEXT;
+
+CREATE INDEX bookings_category_id ON bookings (category_id);
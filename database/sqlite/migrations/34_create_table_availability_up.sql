@@ -0,0 +1,49 @@
+-- Providers publish the hours they work on each day of the week, exceptions
+-- to them on given dates and blackout days on which they do not work. Times
+-- are wall clock times like 08:30 and dates are like 2006-01-02. Weekday 0
+-- is Sunday.
+CREATE TABLE working_hours (
+  `id` INTEGER PRIMARY KEY AUTO_INCREMENT,
+  `provider_id` VARCHAR(255) NOT NULL,
+  `weekday` INT(1) NOT NULL,
+  `start_time` CHAR(5) NOT NULL,
+  `end_time` CHAR(5) NOT NULL,
+  FOREIGN KEY (`provider_id`) REFERENCES `providers` (`provider_id`)
+);
+
+CREATE INDEX working_hours_provider_id ON working_hours (provider_id);
+
+CREATE TABLE availability_exceptions (
+  `exception_id` VARCHAR(255) PRIMARY KEY,
+  `provider_id` VARCHAR(255) NOT NULL,
+  `date` CHAR(10) NOT NULL,
+  `start_time` CHAR(5) NOT NULL,
+  `end_time` CHAR(5) NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (`provider_id`) REFERENCES `providers` (`provider_id`)
+);
+
+CREATE INDEX availability_exceptions_provider_id_date ON availability_exceptions (provider_id, date);
+
+CREATE TABLE blackouts (
+  `blackout_id` VARCHAR(255) PRIMARY KEY,
+  `provider_id` VARCHAR(255) NOT NULL,
+  `start_date` CHAR(10) NOT NULL,
+  `end_date` CHAR(10) NOT NULL,
+  `reason` TEXT,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (`provider_id`) REFERENCES `providers` (`provider_id`)
+);
+
+CREATE INDEX blackouts_provider_id_end_date ON blackouts (provider_id, end_date);
+
+-- Bookings of a service last as long as the service, in minutes, so that
+-- bookings of the same provider can be kept from overlapping.
+ALTER TABLE services ADD COLUMN duration INT(11) NOT NULL DEFAULT 60;
+
+ALTER TABLE bookings ADD COLUMN end_at DATETIME DEFAULT NULL;
+
+CREATE INDEX bookings_provider_id_start_at ON bookings (provider_id, start_at);
+
+-- The dates table was never used.
+DROP TABLE IF EXISTS dates;
@@ -0,0 +1,22 @@
+-- Either party to a booking may propose moving it to another time. The other
+-- party accepts, declines or counters with a time of their own. A booking has
+-- at most one pending proposal.
+CREATE TABLE booking_reschedules (
+  `id` INTEGER PRIMARY KEY AUTO_INCREMENT,
+  `booking_id` VARCHAR(255) NOT NULL,
+  `proposed_by` VARCHAR(255) NOT NULL,
+  `from_start_at` DATETIME NOT NULL,
+  `start_at` DATETIME NOT NULL,
+  `end_at` DATETIME NOT NULL,
+  `status` VARCHAR(255) NOT NULL DEFAULT 'pending',
+  `reason` TEXT,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `decided_at` DATETIME DEFAULT NULL,
+  FOREIGN KEY (`booking_id`) REFERENCES `bookings` (`booking_id`),
+  FOREIGN KEY (`proposed_by`) REFERENCES `users` (`user_id`)
+);
+
+CREATE INDEX booking_reschedules_booking_id_status ON booking_reschedules (booking_id, status);
+
+-- Events of a booking that concern a proposal to move it point to it.
+ALTER TABLE booking_events ADD COLUMN reschedule_id INT(20) DEFAULT NULL;
@@ -0,0 +1,37 @@
+-- A series books a service at the times of a recurrence rule. Its bookings
+-- are made ahead of time up to generated_until, and each occurrence is
+-- recorded whether it was booked, skipped or could not be booked.
+CREATE TABLE booking_series (
+  `series_id` VARCHAR(255) PRIMARY KEY,
+  `client_id` VARCHAR(255) NOT NULL,
+  `provider_id` VARCHAR(255) NOT NULL,
+  `service_id` INT(20) NOT NULL,
+  `location_id` VARCHAR(255) NOT NULL,
+  `rule` VARCHAR(255) NOT NULL,
+  `start_at` DATETIME NOT NULL,
+  `status` VARCHAR(255) NOT NULL DEFAULT 'active',
+  `generated_until` DATETIME NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (`client_id`) REFERENCES `users` (`user_id`),
+  FOREIGN KEY (`provider_id`) REFERENCES `providers` (`provider_id`),
+  FOREIGN KEY (`service_id`) REFERENCES `services` (`id`),
+  FOREIGN KEY (`location_id`) REFERENCES `locations` (`location_id`)
+);
+
+CREATE INDEX booking_series_status_generated_until ON booking_series (status, generated_until);
+
+CREATE TABLE booking_series_occurrences (
+  `id` INTEGER PRIMARY KEY AUTO_INCREMENT,
+  `series_id` VARCHAR(255) NOT NULL,
+  `occurs_at` DATETIME NOT NULL,
+  `status` VARCHAR(255) NOT NULL,
+  `booking_id` VARCHAR(255) DEFAULT NULL,
+  `note` TEXT,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (`series_id`, `occurs_at`),
+  FOREIGN KEY (`series_id`) REFERENCES `booking_series` (`series_id`),
+  FOREIGN KEY (`booking_id`) REFERENCES `bookings` (`booking_id`)
+);
+
+ALTER TABLE bookings ADD COLUMN series_id VARCHAR(255) DEFAULT NULL;
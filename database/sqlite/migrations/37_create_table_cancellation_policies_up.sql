@@ -0,0 +1,19 @@
+-- Cancellation policies set what a client pays for cancelling a booking
+-- late or not showing up. A provider's own policy applies to their bookings,
+-- else that of the booking's category, else the platform default, which has
+-- neither. Without any policy cancelling is free.
+CREATE TABLE cancellation_policies (
+  `id` INTEGER PRIMARY KEY AUTO_INCREMENT,
+  `provider_id` VARCHAR(255) DEFAULT NULL UNIQUE,
+  `category_id` INT(20) DEFAULT NULL UNIQUE,
+  -- Cancelling is free until this many hours before the booking starts.
+  `free_hours` INT(11) NOT NULL,
+  `fee_percent` DECIMAL(5,2) NOT NULL,
+  `no_show_percent` DECIMAL(5,2) NOT NULL,
+  `updated_at` DATETIME NOT NULL,
+  FOREIGN KEY (`provider_id`) REFERENCES `providers` (`provider_id`),
+  FOREIGN KEY (`category_id`) REFERENCES `categories` (`id`)
+);
+
+-- Part of a refunded escrow kept as the fee for cancelling its booking.
+ALTER TABLE escrows ADD COLUMN cancellation_fee INT NOT NULL DEFAULT 0;
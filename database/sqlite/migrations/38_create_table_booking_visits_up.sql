@@ -0,0 +1,28 @@
+-- Providers check in when they arrive at a booking and check out when they
+-- finish, recording where they were and how long the job took. Distances are
+-- in km from the booking location. A booking re-opened and accepted again
+-- gets a new visit; the latest is the current one.
+CREATE TABLE booking_visits (
+  `visit_id` INTEGER PRIMARY KEY AUTO_INCREMENT,
+  `booking_id` VARCHAR(255) NOT NULL,
+  `provider_user_id` VARCHAR(255) NOT NULL,
+  `check_in_at` DATETIME NOT NULL,
+  `check_in_latitude` DECIMAL(10,7) NOT NULL,
+  `check_in_longitude` DECIMAL(10,7) NOT NULL,
+  `check_in_distance` DECIMAL(10,3) NOT NULL,
+  `check_out_at` DATETIME DEFAULT NULL,
+  `check_out_latitude` DECIMAL(10,7) DEFAULT NULL,
+  `check_out_longitude` DECIMAL(10,7) DEFAULT NULL,
+  `check_out_distance` DECIMAL(10,3) DEFAULT NULL,
+  -- Minutes from check in to check out.
+  `duration` INT(11) DEFAULT NULL,
+  -- Hash of the code sent to the client on check out, which they give to
+  -- the provider to confirm the job is done.
+  `completion_code_hash` VARCHAR(255) DEFAULT NULL,
+  `code_attempts` INT(11) NOT NULL DEFAULT 0,
+  `confirmed_by` VARCHAR(255) DEFAULT NULL,
+  `confirmed_at` DATETIME DEFAULT NULL,
+  FOREIGN KEY (`booking_id`) REFERENCES `bookings` (`booking_id`)
+);
+
+CREATE INDEX booking_visits_booking_id ON booking_visits (booking_id);
@@ -0,0 +1,2 @@
+-- The payment requested to renew a subscription, until it is paid or fails.
+ALTER TABLE subscriptions ADD COLUMN charge_id VARCHAR(255) DEFAULT NULL;
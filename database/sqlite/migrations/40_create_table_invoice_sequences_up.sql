@@ -0,0 +1,12 @@
+-- The last number issued of each kind of invoice. Invoices take the next
+-- number by updating their row, so concurrent invoices are numbered in turn.
+CREATE TABLE invoice_sequences (
+    kind VARCHAR(255) PRIMARY KEY,
+    seq INT NOT NULL
+);
+
+INSERT INTO invoice_sequences (kind, seq)
+SELECT 'invoice', COALESCE(MAX(seq), 0) FROM invoices WHERE kind = 'invoice';
+
+INSERT INTO invoice_sequences (kind, seq)
+SELECT 'receipt', COALESCE(MAX(seq), 0) FROM invoices WHERE kind = 'receipt';
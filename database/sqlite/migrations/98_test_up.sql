@@ -1,4 +0,0 @@
-CREATE TABLE dates(
-    date_id VARCHAR(255) PRIMARY KEY,
-    start_at DATETIME
-);
\ No newline at end of file
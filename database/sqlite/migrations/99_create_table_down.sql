@@ -1 +1 @@
-DROP TABLE `bids`, `bookings`, `categories`, `industries`, `locations`, `migrations`, `photos`, `portfolios`, `providers`, `rates`, `reviews`, `services`, `transactions`, `users`, `user_locations`, `dates`;
+DROP TABLE `invoice_sequences`, `booking_visits`, `cancellation_policies`, `booking_series_occurrences`, `booking_series`, `booking_reschedules`, `blackouts`, `availability_exceptions`, `working_hours`, `invoice_items`, `invoices`, `commission_rates`, `payouts`, `escrows`, `payment_methods`, `verification_codes`, `subscriptions`, `booking_events`, `bids`, `bookings`, `categories`, `industries`, `locations`, `migrations`, `photos`, `plans`, `portfolios`, `providers`, `rates`, `reviews`, `services`, `transactions`, `users`, `user_locations`;
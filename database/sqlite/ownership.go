@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/google/uuid"
+)
+
+// OwnershipService looks up the users involved with bookings and bids.
+type OwnershipService struct {
+	db *DB
+}
+
+func NewOwnershipService(db *DB) *OwnershipService {
+	return &OwnershipService{db}
+}
+
+func (s *OwnershipService) FindBookingParties(ctx context.Context, id uuid.UUID) (*app.BookingParties, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findBookingParties(ctx, tx, id)
+}
+
+func findBookingParties(ctx context.Context, tx *Tx, id uuid.UUID) (*app.BookingParties, error) {
+	var parties app.BookingParties
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bookings.booking_id,
+			bookings.client_id,
+			providers.user_id
+		FROM bookings
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		WHERE bookings.booking_id = ?
+		`,
+		id,
+	).Scan(
+		&parties.BookingID,
+		&parties.ClientID,
+		&parties.ProviderUserID,
+	); err != nil {
+		return nil, err
+	}
+	return &parties, nil
+}
+
+func (s *OwnershipService) FindBidParties(ctx context.Context, id int) (*app.BidParties, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findBidParties(ctx, tx, id)
+}
+
+func findBidParties(ctx context.Context, tx *Tx, id int) (*app.BidParties, error) {
+	var parties app.BidParties
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bids.id,
+			bids.booking_id,
+			bookings.client_id,
+			providers.user_id
+		FROM bids
+		JOIN bookings ON bookings.booking_id = bids.booking_id
+		JOIN providers ON providers.provider_id = bids.provider_id
+		WHERE bids.id = ?
+		`,
+		id,
+	).Scan(
+		&parties.BidID,
+		&parties.BookingID,
+		&parties.ClientID,
+		&parties.BidderID,
+	); err != nil {
+		return nil, err
+	}
+	return &parties, nil
+}
+
+// IsAdmin reports whether the user is an administrator. Unknown users are not.
+func (s *OwnershipService) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var isAdmin bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT is_admin
+		FROM users
+		WHERE user_id = ?
+		`,
+		userID,
+	).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return isAdmin, nil
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed password validations allowed before a user is locked out, and for
+// how long the lockout lasts.
+const (
+	maxFailedLogins = 5
+	lockoutDuration = 15 * time.Minute
+)
+
+// passwordCost is the bcrypt cost passwords are hashed with. Hashes made with
+// a lower cost are upgraded the next time the user logs in.
+const passwordCost = bcrypt.DefaultCost
+
+// hashPassword returns the bcrypt hash of a password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash reports whether a stored password is a bcrypt hash rather
+// than a plaintext password written before passwords were hashed.
+func isPasswordHash(stored string) bool {
+	if len(stored) != 60 {
+		return false
+	}
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword reports whether password matches the stored password, and
+// whether the stored password should be rehashed. Plaintext passwords are
+// still accepted until `serviceappcli users rehash` has converted them.
+func checkPassword(stored string, password string) (ok bool, rehash bool) {
+	if !isPasswordHash(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost < passwordCost
+}
@@ -0,0 +1,696 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+// Account references are accountReferencePrefix followed by random
+// characters, short enough for the 12 characters M-Pesa allows.
+const (
+	accountReferencePrefix  = "HA"
+	accountReferenceLength  = 8
+	accountReferenceCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// chargeTimeout is how long a client has to pay a renewal charge before it
+// counts as failed. M-Pesa prompts expire well before that.
+const chargeTimeout = 10 * time.Minute
+
+// PaymentService requests payments through a payment gateway and records them
+// in the transactions table.
+type PaymentService struct {
+	db      *DB
+	gateway app.PaymentGateway
+}
+
+func NewPaymentService(db *DB, gateway app.PaymentGateway) *PaymentService {
+	return &PaymentService{db: db, gateway: gateway}
+}
+
+// RequestSubscriptionPayment asks the client to pay the plan price of their
+// subscription.
+func (s *PaymentService) RequestSubscriptionPayment(ctx context.Context, subscriptionID string, clientID string, phone string) (*app.PaymentRequest, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	state, err := findSubscriptionState(ctx, tx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if state.clientID != clientID {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Subscription not found.")
+	}
+	if state.status == app.SubscriptionCancelled || state.status == app.SubscriptionExpired {
+		return nil, app.Errorf(app.CONFLICT_ERR, "Subscription has ended.")
+	}
+
+	plan, err := findPlanByID(ctx, tx, state.planID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Price == 0 {
+		return nil, app.Errorf(app.CONFLICT_ERR, "There is nothing to pay for a free plan.")
+	}
+
+	return s.requestPayment(ctx, tx, &pendingPaymentRequest{
+		clientID:       clientID,
+		subscriptionID: subscriptionID,
+		amount:         plan.Price,
+		currency:       plan.Currency,
+		phone:          phone,
+		description:    plan.Name + " subscription",
+	})
+}
+
+// ChargeSubscription asks the client to pay for the next period of their
+// subscription with an M-Pesa prompt to the payment method on file. The
+// payment renews the subscription when it is recorded, so the charge stays
+// pending until then. A charge that fails or is not paid within
+// chargeTimeout returns an error and the next one sends a new prompt.
+func (s *PaymentService) ChargeSubscription(ctx context.Context, subscription *app.Subscription) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var chargeID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT charge_id FROM subscriptions WHERE subscription_id = ?
+		`,
+		subscription.SubscriptionID,
+	).Scan(&chargeID); err != nil {
+		return "", err
+	}
+
+	if chargeID.Valid {
+		var status, createdAt string
+		var resultDesc sql.NullString
+		if err := tx.QueryRowContext(ctx, `
+			SELECT status, result_desc, created_at
+			FROM transactions
+			WHERE transaction_id = ?
+			`,
+			chargeID.String,
+		).Scan(&status, &resultDesc, &createdAt); err != nil {
+			return "", err
+		}
+		chargedAt, err := parseTime(createdAt)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case status == app.TransactionCompleted:
+			// The payment was recorded without renewing the subscription.
+			return chargeID.String, nil
+		case status == app.TransactionPending && tx.now.Sub(chargedAt) < chargeTimeout:
+			return "", app.ErrChargePending
+		case status == app.TransactionPending:
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE transactions SET
+					status = ?,
+					result_desc = ?,
+					updated_at = ?
+				WHERE transaction_id = ?
+				`,
+				app.TransactionFailed,
+				"The client did not pay in time.",
+				tx.now,
+				chargeID.String,
+			); err != nil {
+				return "", err
+			}
+			if err := tx.Commit(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("payment %s was not paid in time", chargeID.String)
+		default:
+			return "", fmt.Errorf("payment %s failed: %s", chargeID.String, resultDesc.String)
+		}
+	}
+
+	state, err := findSubscriptionState(ctx, tx, subscription.SubscriptionID)
+	if err != nil {
+		return "", err
+	}
+	plan, err := findPlanByID(ctx, tx, state.planID)
+	if err != nil {
+		return "", err
+	}
+	// A free plan renews without a payment.
+	if plan.Price == 0 {
+		return "", nil
+	}
+	phone, err := findChargePhone(ctx, tx, subscription)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.requestPayment(ctx, tx, &pendingPaymentRequest{
+		clientID:       state.clientID,
+		subscriptionID: subscription.SubscriptionID,
+		renewal:        true,
+		amount:         plan.Price,
+		currency:       plan.Currency,
+		phone:          phone,
+		description:    plan.Name + " subscription renewal",
+	}); err != nil {
+		return "", err
+	}
+	return "", app.ErrChargePending
+}
+
+// findChargePhone returns the M-Pesa number to charge a subscription to: its
+// payment method, else the client's default one. Only verified numbers are
+// charged.
+func findChargePhone(ctx context.Context, tx *Tx, subscription *app.Subscription) (string, error) {
+	var phone string
+	err := tx.QueryRowContext(ctx, `
+		SELECT phone
+		FROM payment_methods
+		WHERE user_id = ?
+			AND type = ?
+			AND phone IS NOT NULL
+			AND verified_at IS NOT NULL
+			AND (payment_method_id = ? OR is_default = TRUE)
+		ORDER BY payment_method_id = ? DESC
+		LIMIT 1
+		`,
+		subscription.ClientID,
+		app.MobilePaymentMethod,
+		subscription.PaymentMethodID,
+		subscription.PaymentMethodID,
+	).Scan(&phone)
+	if err == sql.ErrNoRows {
+		return "", errors.New("no verified M-Pesa payment method on file")
+	}
+	return phone, err
+}
+
+// RequestBookingPayment asks the client to pay the amount of the escrow of
+// their booking.
+func (s *PaymentService) RequestBookingPayment(ctx context.Context, bookingID string, clientID string, phone string) (*app.PaymentRequest, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var escrowClientID, status, currency string
+	var amount int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT client_id, status, amount, currency
+		FROM escrows
+		WHERE booking_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+		`,
+		bookingID,
+	).Scan(&escrowClientID, &status, &amount, &currency); err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "The booking has no payment due.")
+	} else if err != nil {
+		return nil, err
+	}
+	if escrowClientID != clientID {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Booking not found.")
+	}
+	if status != app.EscrowAwaitingPayment {
+		return nil, app.Errorf(app.CONFLICT_ERR, "The booking has no payment due.")
+	}
+
+	return s.requestPayment(ctx, tx, &pendingPaymentRequest{
+		clientID:    clientID,
+		bookingID:   bookingID,
+		amount:      amount,
+		currency:    currency,
+		phone:       phone,
+		description: "Payment for booking",
+	})
+}
+
+// pendingPaymentRequest is a payment to request for a subscription or a
+// booking.
+type pendingPaymentRequest struct {
+	clientID       string
+	subscriptionID string
+	// renewal marks the payment as the charge renewing the subscription.
+	renewal     bool
+	bookingID   string
+	amount      int
+	currency    string
+	phone       string
+	description string
+}
+
+// requestPayment stores the pending transaction of a payment request and
+// commits tx before the gateway is called, so the callback always finds it.
+func (s *PaymentService) requestPayment(ctx context.Context, tx *Tx, p *pendingPaymentRequest) (*app.PaymentRequest, error) {
+	accountReference, err := newAccountReference()
+	if err != nil {
+		return nil, err
+	}
+	request := &app.PaymentRequest{
+		TransactionID:    uuid.NewString(),
+		AccountReference: accountReference,
+		Amount:           p.amount,
+		Currency:         p.currency,
+		Phone:            p.phone,
+		Status:           app.TransactionPending,
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions (
+			transaction_id,
+			user_id,
+			subscription_id,
+			booking_id,
+			direction,
+			method,
+			account_reference,
+			phone,
+			amount,
+			currency,
+			status,
+			description,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		request.TransactionID,
+		p.clientID,
+		nullString(p.subscriptionID),
+		nullString(p.bookingID),
+		app.TransactionDebit,
+		app.MpesaSTKMethod,
+		request.AccountReference,
+		request.Phone,
+		request.Amount,
+		request.Currency,
+		request.Status,
+		p.description,
+		tx.now,
+		tx.now,
+	); err != nil {
+		return nil, err
+	}
+	if p.renewal {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE subscriptions SET
+				charge_id = ?,
+				updated_at = ?
+			WHERE subscription_id = ?
+			`,
+			request.TransactionID,
+			tx.now,
+			p.subscriptionID,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	reference, err := s.gateway.RequestPayment(ctx, p.phone, request.Amount, request.AccountReference, p.description)
+	if err != nil {
+		log.Printf("payment request %s failed: %s", request.TransactionID, err)
+		if err := s.failTransaction(ctx, request.TransactionID, err.Error()); err != nil {
+			return nil, err
+		}
+		return nil, app.Errorf(app.CONFLICT_ERR, "The payment could not be requested. Please try again.")
+	}
+
+	if err := s.setTransactionReference(ctx, request.TransactionID, reference); err != nil {
+		return nil, err
+	}
+
+	request.Message = fmt.Sprintf("Enter your M-Pesa PIN on your phone to pay %s %d, or pay to our paybill with account number %s.", request.Currency, request.Amount, request.AccountReference)
+	return request, nil
+}
+
+func (s *PaymentService) setTransactionReference(ctx context.Context, id string, reference string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE transactions SET
+			reference = ?,
+			updated_at = ?
+		WHERE transaction_id = ?
+		`,
+		reference,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PaymentService) failTransaction(ctx context.Context, id string, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE transactions SET
+			status = ?,
+			result_desc = ?,
+			updated_at = ?
+		WHERE transaction_id = ?
+		`,
+		app.TransactionFailed,
+		reason,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CompleteSTKPayment records the outcome of an STK push. Outcomes of requests
+// that are no longer pending have already been recorded and are ignored.
+func (s *PaymentService) CompleteSTKPayment(ctx context.Context, result *model.STKResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payment, err := findPendingPayment(ctx, tx, "reference = ?", result.CheckoutRequestID)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Unknown payment request %s.", result.CheckoutRequestID)
+	} else if err != nil {
+		return err
+	}
+	if payment.status != app.TransactionPending {
+		log.Printf("duplicate callback for payment request %s", result.CheckoutRequestID)
+		return nil
+	}
+
+	if result.ResultCode != 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE transactions SET
+				status = ?,
+				result_code = ?,
+				result_desc = ?,
+				updated_at = ?
+			WHERE transaction_id = ?
+			`,
+			app.TransactionFailed,
+			fmt.Sprint(result.ResultCode),
+			result.ResultDesc,
+			tx.now,
+			payment.id,
+		); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE transactions SET
+			status = ?,
+			receipt = ?,
+			result_code = ?,
+			result_desc = ?,
+			updated_at = ?
+		WHERE transaction_id = ?
+		`,
+		app.TransactionCompleted,
+		result.Receipt,
+		"0",
+		result.ResultDesc,
+		tx.now,
+		payment.id,
+	); err != nil {
+		return err
+	}
+	if err := applyPayment(ctx, tx, payment); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ValidateC2BPayment accepts a paybill payment only if its account reference
+// belongs to a payment request that can still be paid and it pays at least
+// the amount asked.
+func (s *PaymentService) ValidateC2BPayment(ctx context.Context, payment *model.C2BPayment) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pending, err := findPayablePayment(ctx, tx, payment.AccountReference)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Unknown account number %s.", payment.AccountReference)
+	} else if err != nil {
+		return err
+	}
+	if payment.Amount < pending.amount {
+		return app.Errorf(app.INVALID_ERR, "Amount must be at least %d.", pending.amount)
+	}
+	return nil
+}
+
+// ConfirmC2BPayment records a paybill payment. It completes the payment
+// request with the same account reference if the amount covers it.
+// Other payments are recorded without a link for reconciliation.
+func (s *PaymentService) ConfirmC2BPayment(ctx context.Context, payment *model.C2BPayment) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var recorded int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM transactions
+		WHERE receipt = ?
+		`,
+		payment.TransID,
+	).Scan(&recorded); err != nil {
+		return err
+	}
+	if recorded > 0 {
+		log.Printf("duplicate confirmation of payment %s", payment.TransID)
+		return nil
+	}
+
+	pending, err := findPayablePayment(ctx, tx, payment.AccountReference)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil && payment.Amount >= pending.amount {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE transactions SET
+				method = ?,
+				status = ?,
+				receipt = ?,
+				phone = ?,
+				amount = ?,
+				result_code = ?,
+				result_desc = ?,
+				updated_at = ?
+			WHERE transaction_id = ?
+			`,
+			app.MpesaC2BMethod,
+			app.TransactionCompleted,
+			payment.TransID,
+			payment.Phone,
+			payment.Amount,
+			"0",
+			"Paid to paybill",
+			tx.now,
+			pending.id,
+		); err != nil {
+			return err
+		}
+		if err := applyPayment(ctx, tx, pending); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	log.Printf("unmatched paybill payment %s to account %s", payment.TransID, payment.AccountReference)
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions (
+			transaction_id,
+			direction,
+			method,
+			receipt,
+			account_reference,
+			phone,
+			amount,
+			currency,
+			status,
+			result_code,
+			result_desc,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		uuid.NewString(),
+		app.TransactionDebit,
+		app.MpesaC2BMethod,
+		payment.TransID,
+		payment.AccountReference,
+		payment.Phone,
+		payment.Amount,
+		"Ksh",
+		app.TransactionCompleted,
+		"0",
+		"Unmatched payment",
+		tx.now,
+		tx.now,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pendingPayment is what recording a payment needs to know about the
+// transaction created when it was requested.
+type pendingPayment struct {
+	id             string
+	status         string
+	amount         int
+	subscriptionID sql.NullString
+	bookingID      sql.NullString
+}
+
+func findPendingPayment(ctx context.Context, tx *Tx, where string, args ...interface{}) (*pendingPayment, error) {
+	var payment pendingPayment
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			transaction_id,
+			status,
+			amount,
+			subscription_id,
+			booking_id
+		FROM transactions
+		WHERE `+where+`
+		ORDER BY created_at DESC
+		LIMIT 1
+		`,
+		args...,
+	).Scan(
+		&payment.id,
+		&payment.status,
+		&payment.amount,
+		&payment.subscriptionID,
+		&payment.bookingID,
+	); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// findPayablePayment returns the payment request a paybill payment to
+// accountReference pays. Clients are offered the paybill as well as the STK
+// push, so a request stays payable after its push fails or is cancelled,
+// until it or a later request for the same booking or subscription is paid.
+func findPayablePayment(ctx context.Context, tx *Tx, accountReference string) (*pendingPayment, error) {
+	return findPendingPayment(ctx, tx, `
+		account_reference = ?
+		AND receipt IS NULL
+		AND status IN (?, ?)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM transactions paid
+			WHERE paid.status = ?
+				AND paid.created_at >= transactions.created_at
+				AND (paid.booking_id = transactions.booking_id OR paid.subscription_id = transactions.subscription_id)
+		)
+		`,
+		accountReference,
+		app.TransactionPending,
+		app.TransactionFailed,
+		app.TransactionCompleted,
+	)
+}
+
+// applyPayment activates or renews the subscription, or marks the booking as
+// paid and holds the payment in its escrow, that a completed payment was
+// requested for. A payment for a
+// subscription that has ended is only logged, as it has to be refunded by
+// hand.
+func applyPayment(ctx context.Context, tx *Tx, payment *pendingPayment) error {
+	if payment.subscriptionID.Valid {
+		state, err := findSubscriptionState(ctx, tx, payment.subscriptionID.String)
+		if err != nil {
+			return err
+		}
+		switch state.status {
+		case app.SubscriptionPending:
+			err = activateSubscription(ctx, tx, payment.subscriptionID.String, payment.id)
+		case app.SubscriptionActive, app.SubscriptionPastDue:
+			err = renewSubscription(ctx, tx, payment.subscriptionID.String, payment.id)
+		default:
+			err = app.Errorf(app.CONFLICT_ERR, "Subscription is %s.", state.status)
+		}
+
+		var appErr *app.Error
+		if errors.As(err, &appErr) {
+			log.Printf("payment %s not applied to subscription %s: %s", payment.id, payment.subscriptionID.String, appErr.Message)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if payment.bookingID.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE bookings SET
+				paid_at = ?
+			WHERE booking_id = ? AND paid_at IS NULL
+			`,
+			tx.now,
+			payment.bookingID.String,
+		); err != nil {
+			return err
+		}
+		if err := holdEscrow(ctx, tx, payment.bookingID.String); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newAccountReference returns a random account reference for a payment.
+func newAccountReference() (string, error) {
+	buf := make([]byte, accountReferenceLength)
+	max := big.NewInt(int64(len(accountReferenceCharset)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = accountReferenceCharset[n.Int64()]
+	}
+	return accountReferencePrefix + string(buf), nil
+}
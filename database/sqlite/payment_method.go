@@ -0,0 +1,399 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+// PaymentMethodService stores payment methods and verifies M-Pesa numbers
+// with codes sent by SMS.
+type PaymentMethodService struct {
+	db  *DB
+	sms app.SMSSender
+}
+
+func NewPaymentMethodService(db *DB, sms app.SMSSender) *PaymentMethodService {
+	return &PaymentMethodService{db: db, sms: sms}
+}
+
+// AddPaymentMethod stores a payment method. An M-Pesa number that is the
+// verified phone number of the account, and a card, are verified straight
+// away; any other number is sent a verification code. The first payment
+// method of a user becomes their default.
+func (s *PaymentMethodService) AddPaymentMethod(ctx context.Context, method *model.PaymentMethod) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM payment_methods
+		WHERE user_id = ? AND (phone = ? OR card_token = ?)
+		`,
+		method.UserID,
+		nullString(method.PhoneNumber),
+		nullString(method.CardToken),
+	).Scan(&existing); err != nil {
+		return err
+	}
+	if existing > 0 {
+		return app.Errorf(app.CONFLICT_ERR, "You have already added this payment method.")
+	}
+
+	verified := method.Type() == app.CardPaymentMethod
+	if !verified {
+		var accountPhone int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM users
+			WHERE user_id = ? AND phone = ? AND verified = TRUE
+			`,
+			method.UserID,
+			method.PhoneNumber,
+		).Scan(&accountPhone); err != nil {
+			return err
+		}
+		verified = accountPhone > 0
+	}
+	var verifiedAt *time.Time
+	if verified {
+		verifiedAt = &tx.now
+	}
+
+	var methods int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM payment_methods
+		WHERE user_id = ? AND verified_at IS NOT NULL
+		`,
+		method.UserID,
+	).Scan(&methods); err != nil {
+		return err
+	}
+
+	method.ID = uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO payment_methods (
+			payment_method_id,
+			user_id,
+			method,
+			type,
+			phone,
+			card_token,
+			card_last4,
+			card_expiry,
+			is_default,
+			verified_at,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		method.ID,
+		method.UserID,
+		method.Method,
+		method.Type(),
+		nullString(method.PhoneNumber),
+		nullString(method.CardToken),
+		nullString(method.CardLast4),
+		nullString(method.CardExpiry),
+		verified && methods == 0,
+		verifiedAt,
+		tx.now,
+		tx.now,
+	); err != nil {
+		return err
+	}
+
+	var code string
+	if !verified {
+		if code, err = createVerificationCode(ctx, tx, method.PhoneNumber, app.VerifyPaymentMethodPurpose); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if code != "" {
+		return s.sendCode(ctx, method.PhoneNumber, code)
+	}
+	return nil
+}
+
+func (s *PaymentMethodService) sendCode(ctx context.Context, phone string, code string) error {
+	return s.sms.SendSMS(ctx, phone, fmt.Sprintf("Your HudumaApp code to add this M-Pesa number is %s. It expires in %d minutes.", code, int(codeTTL.Minutes())))
+}
+
+func (s *PaymentMethodService) FindPaymentMethodByID(ctx context.Context, id string, userID string) (*app.PaymentMethod, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	method, err := findPaymentMethodByID(ctx, tx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return method, tx.Commit()
+}
+
+func findPaymentMethodByID(ctx context.Context, tx *Tx, id string, userID string) (*app.PaymentMethod, error) {
+	methods, err := listPaymentMethods(ctx, tx, "payment_method_id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return methods[0], nil
+}
+
+func (s *PaymentMethodService) ListPaymentMethods(ctx context.Context, userID string) ([]*app.PaymentMethod, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	methods, err := listPaymentMethods(ctx, tx, "user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	return methods, tx.Commit()
+}
+
+func listPaymentMethods(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.PaymentMethod, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			payment_method_id,
+			method,
+			type,
+			phone,
+			card_last4,
+			card_expiry,
+			is_default,
+			verified_at IS NOT NULL,
+			created_at
+		FROM payment_methods
+		WHERE `+where+`
+		ORDER BY is_default DESC, created_at DESC
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []*app.PaymentMethod{}
+	for rows.Next() {
+		var method app.PaymentMethod
+		var phone, last4 sql.NullString
+		if err := rows.Scan(
+			&method.ID,
+			&method.Method,
+			&method.Type,
+			&phone,
+			&last4,
+			&method.Expiry,
+			&method.IsDefault,
+			&method.Verified,
+			&method.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if method.Type == app.CardPaymentMethod {
+			method.Number = "**** **** **** " + last4.String
+		} else {
+			method.Number = phone.String
+		}
+		methods = append(methods, &method)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+func (s *PaymentMethodService) SetDefaultPaymentMethod(ctx context.Context, id string, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	method, err := findPaymentMethodByID(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !method.Verified {
+		return app.Errorf(app.CONFLICT_ERR, "Verify the payment method first.")
+	}
+
+	if err := setDefaultPaymentMethod(ctx, tx, id, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setDefaultPaymentMethod(ctx context.Context, tx *Tx, id string, userID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE payment_methods SET
+			is_default = (payment_method_id = ?),
+			updated_at = ?
+		WHERE user_id = ?
+		`,
+		id,
+		tx.now,
+		userID,
+	)
+	return err
+}
+
+func (s *PaymentMethodService) SendPaymentMethodCode(ctx context.Context, id string, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	method, err := findPaymentMethodByID(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if method.Verified {
+		return app.Errorf(app.CONFLICT_ERR, "The payment method is already verified.")
+	}
+
+	code, err := createVerificationCode(ctx, tx, method.Number, app.VerifyPaymentMethodPurpose)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.sendCode(ctx, method.Number, code)
+}
+
+// VerifyPaymentMethod verifies an M-Pesa number with the code sent to it. It
+// becomes the default payment method if the user has no other verified one.
+func (s *PaymentMethodService) VerifyPaymentMethod(ctx context.Context, id string, userID string, code string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	method, err := findPaymentMethodByID(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if method.Verified {
+		return nil
+	}
+
+	if err := checkVerificationCode(ctx, tx, method.Number, app.VerifyPaymentMethodPurpose, code); err == errWrongCode {
+		return commitWrongCode(tx)
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE payment_methods SET
+			verified_at = ?,
+			updated_at = ?
+		WHERE payment_method_id = ?
+		`,
+		tx.now,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+
+	if err := ensureDefaultPaymentMethod(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePaymentMethod removes a payment method. If it was the default, the
+// most recently added verified method becomes the default.
+func (s *PaymentMethodService) DeletePaymentMethod(ctx context.Context, id string, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := findPaymentMethodByID(ctx, tx, id, userID); err != nil {
+		return err
+	}
+
+	var subscriptions int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM subscriptions
+		WHERE payment_method_id = ? AND status IN (?, ?, ?)
+		`,
+		id,
+		app.SubscriptionPending,
+		app.SubscriptionActive,
+		app.SubscriptionPastDue,
+	).Scan(&subscriptions); err != nil {
+		return err
+	}
+	if subscriptions > 0 {
+		return app.Errorf(app.CONFLICT_ERR, "The payment method is used by your subscription. Change the payment method of the subscription or cancel it first.")
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM payment_methods WHERE payment_method_id = ?
+		`,
+		id,
+	); err != nil {
+		return err
+	}
+
+	if err := ensureDefaultPaymentMethod(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureDefaultPaymentMethod makes the most recently added verified payment
+// method of the user the default if they have no default.
+func ensureDefaultPaymentMethod(ctx context.Context, tx *Tx, userID string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		SELECT payment_method_id
+		FROM payment_methods
+		WHERE user_id = ? AND verified_at IS NOT NULL
+		ORDER BY is_default DESC, created_at DESC
+		LIMIT 1
+		`,
+		userID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return setDefaultPaymentMethod(ctx, tx, id, userID)
+}
+
+// nullString returns NULL for an empty string.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -2,9 +2,13 @@ package sqlite
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"log"
 
+	app "github.com/andrwkng/hudumaapp"
 	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
 )
 
 type PlanService struct {
@@ -29,27 +33,46 @@ func (s *PlanService) CreatePlan(ctx context.Context, plan *model.Plan) error {
 }
 
 func createPlan(ctx context.Context, tx *Tx, plan *model.Plan) error {
-	slugName := plan.Name
+	plan.ID = uuid.NewString()
+	if plan.Interval == 0 {
+		plan.Interval = 1
+	}
+
+	features, err := planFeatures(plan)
+	if err != nil {
+		return err
+	}
+
 	if _, err := tx.ExecContext(ctx, `
-		INSERT INTO plan (
+		INSERT INTO plans (
+			plan_id,
+			code,
 			name,
 			description,
-			code,
-			period,
-			interval,
-			billing_cycles,
-			amount,
-			currency
-		) VALUES (?,?,?,?,?,?,?,?)
+			price,
+			currency,
+			interval_count,
+			interval_unit,
+			trial_days,
+			features,
+			status,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)
 		`,
+		plan.ID,
+		plan.Code,
 		plan.Name,
 		plan.Description,
-		slugName,
-		plan.Period,
-		plan.Interval,
-		plan.BillingCycles,
 		plan.Price,
 		plan.Currency,
+		plan.Interval,
+		plan.IntervalUnit,
+		plan.TrialDays,
+		features,
+		app.PlanActive,
+		tx.now,
+		tx.now,
 	); err != nil {
 		log.Println("Failed inserting plan into db:", err)
 		return err
@@ -57,3 +80,196 @@ func createPlan(ctx context.Context, tx *Tx, plan *model.Plan) error {
 
 	return nil
 }
+
+func (s *PlanService) UpdatePlan(ctx context.Context, plan *model.Plan) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updatePlan(ctx, tx, plan); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updatePlan(ctx context.Context, tx *Tx, plan *model.Plan) error {
+	if plan.Interval == 0 {
+		plan.Interval = 1
+	}
+
+	features, err := planFeatures(plan)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE plans SET
+			code = ?,
+			name = ?,
+			description = ?,
+			price = ?,
+			currency = ?,
+			interval_count = ?,
+			interval_unit = ?,
+			trial_days = ?,
+			features = ?,
+			updated_at = ?
+		WHERE plan_id = ?
+		`,
+		plan.Code,
+		plan.Name,
+		plan.Description,
+		plan.Price,
+		plan.Currency,
+		plan.Interval,
+		plan.IntervalUnit,
+		plan.TrialDays,
+		features,
+		tx.now,
+		plan.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ArchivePlan stops a plan from being offered. Existing subscriptions to it
+// are left alone.
+func (s *PlanService) ArchivePlan(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE plans SET
+			status = ?,
+			updated_at = ?
+		WHERE plan_id = ?
+		`,
+		app.PlanArchived,
+		tx.now,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func (s *PlanService) FindPlanByID(ctx context.Context, id string) (*app.Plan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findPlanByID(ctx, tx, id)
+}
+
+func findPlanByID(ctx context.Context, tx *Tx, id string) (*app.Plan, error) {
+	plans, err := listPlans(ctx, tx, "plan_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return plans[0], nil
+}
+
+func (s *PlanService) ListPlans(ctx context.Context, includeArchived bool) ([]*app.Plan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if includeArchived {
+		return listPlans(ctx, tx, "1 = 1")
+	}
+	return listPlans(ctx, tx, "status = ?", app.PlanActive)
+}
+
+func listPlans(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.Plan, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			plan_id,
+			code,
+			name,
+			description,
+			price,
+			currency,
+			interval_count,
+			interval_unit,
+			trial_days,
+			features,
+			status
+		FROM plans
+		WHERE `+where+`
+		ORDER BY price, name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := make([]*app.Plan, 0)
+	for rows.Next() {
+		var plan app.Plan
+		var description, features sql.NullString
+		if err := rows.Scan(
+			&plan.ID,
+			&plan.Code,
+			&plan.Name,
+			&description,
+			&plan.Price,
+			&plan.Currency,
+			&plan.Interval,
+			&plan.IntervalUnit,
+			&plan.TrialDays,
+			&features,
+			&plan.Status,
+		); err != nil {
+			return nil, err
+		}
+		plan.Description = description.String
+		if features.Valid && features.String != "" {
+			if err := json.Unmarshal([]byte(features.String), &plan.Features); err != nil {
+				return nil, err
+			}
+		}
+		plans = append(plans, &plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+// planFeatures returns the JSON stored in the features column of a plan.
+func planFeatures(plan *model.Plan) (string, error) {
+	buf, err := json.Marshal(app.PlanFeatures{
+		SearchRadiusKm: plan.SearchRadiusKm,
+		Premium:        plan.Premium,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
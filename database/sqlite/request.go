@@ -0,0 +1,509 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+// UpdateRequest edits a request. Requests can be edited until a bid on them
+// is accepted. Photos are replaced only if the update has any.
+func (s *RequestService) UpdateRequest(ctx context.Context, request *model.Request) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status, err := findRequestStatus(ctx, tx, request.ID)
+	if err != nil {
+		return err
+	}
+	if status != app.BookingRequested && status != app.BookingBidding {
+		return app.Errorf(app.CONFLICT_ERR, "Request can no longer be edited.")
+	}
+	request.Status = status
+
+	if err := resolveRequestCategory(ctx, tx, request); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bookings SET
+			title = ?,
+			description = ?,
+			start_at = ?,
+			location_id = ?,
+			category_id = ?,
+			is_urgent = ?,
+			updated_at = ?
+		WHERE booking_id = ?
+		`,
+		request.Title,
+		request.Note,
+		request.StartDate,
+		request.LocationID,
+		request.CategoryID,
+		request.Urgent,
+		tx.now,
+		request.ID,
+	); err != nil {
+		return err
+	}
+
+	if request.Photos != nil {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM photos WHERE booking_id = ?
+			`,
+			request.ID,
+		); err != nil {
+			return err
+		}
+		for _, photoUrl := range request.Photos {
+			photo := model.Photo{
+				OwnerID:   request.ClientID,
+				Url:       photoUrl,
+				BookingID: request.ID.String(),
+			}
+			if err := createPhoto(ctx, tx, photo); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// CancelRequest cancels a request and its open bids under its cancellation
+// policy, and lets every provider who bid on it know by SMS.
+func (s *RequestService) CancelRequest(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := findRequestStatus(ctx, tx, c.BookingID); err != nil {
+		return nil, err
+	}
+	if err := expireBids(ctx, tx); err != nil {
+		return nil, err
+	}
+	phones, err := findBidderPhones(ctx, tx, c.BookingID, app.BidActive, app.BidAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := cancelBooking(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRequestBidsStatus(ctx, tx, c.BookingID, app.BidActive, app.BidRejected); err != nil {
+		return nil, err
+	}
+
+	title, err := findRequestTitle(ctx, tx, c.BookingID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("HudumaApp: the request %q you bid on was cancelled by the client.", title)
+	if c.Reason != nil {
+		msg += " Reason: " + *c.Reason
+	}
+	s.notify(ctx, phones, msg)
+	return decision, nil
+}
+
+// ReopenRequest opens a request for bidding again after the provider whose
+// bid was accepted cancelled it. Their bid is withdrawn and the bids rejected
+// when it was accepted become active again.
+func (s *RequestService) ReopenRequest(ctx context.Context, id uuid.UUID, actorID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status, err := findRequestStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if status != app.BookingCancelled {
+		return app.Errorf(app.CONFLICT_ERR, "Only cancelled requests can be re-opened.")
+	}
+
+	// The request must have been cancelled by the provider it was assigned to.
+	var cancelledBy string
+	var providerUserID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT actor_id FROM booking_events WHERE booking_id = bookings.booking_id ORDER BY id DESC LIMIT 1),
+			providers.user_id
+		FROM bookings
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		WHERE bookings.booking_id = ?
+		`,
+		id,
+	).Scan(&cancelledBy, &providerUserID); err != nil {
+		return err
+	}
+	if !providerUserID.Valid || providerUserID.String != cancelledBy {
+		return app.Errorf(app.CONFLICT_ERR, "Only requests cancelled by their provider can be re-opened.")
+	}
+
+	if err := setRequestBidsStatus(ctx, tx, id, app.BidAccepted, app.BidWithdrawn); err != nil {
+		return err
+	}
+	if err := setRequestBidsStatus(ctx, tx, id, app.BidRejected, app.BidActive); err != nil {
+		return err
+	}
+	if err := expireBids(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bookings SET provider_id = NULL WHERE booking_id = ?
+		`,
+		id,
+	); err != nil {
+		return err
+	}
+
+	if err := transitionBooking(ctx, tx, &model.BookingTransition{
+		BookingID: id,
+		Status:    app.BookingBidding,
+		ActorID:   actorID,
+	}); err != nil {
+		return err
+	}
+
+	phones, err := findBidderPhones(ctx, tx, id, app.BidActive)
+	if err != nil {
+		return err
+	}
+	title, err := findRequestTitle(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.notify(ctx, phones, fmt.Sprintf("HudumaApp: the request %q is open for bidding again and your bid on it is active.", title))
+	return nil
+}
+
+func (s *RequestService) ListRequestCandidates(ctx context.Context, userID string) (*app.ProviderProfile, []*app.RequestCandidate, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := expireBids(ctx, tx); err != nil {
+		return nil, nil, err
+	}
+	profile, candidates, err := listRequestCandidates(ctx, tx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return profile, candidates, tx.Commit()
+}
+
+func listRequestCandidates(ctx context.Context, tx *Tx, userID string) (*app.ProviderProfile, []*app.RequestCandidate, error) {
+	// Users who are not providers get candidates without a category, services
+	// or bids to match.
+	var providerID, categoryID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT provider_id, category_id FROM providers WHERE user_id = ?
+		`,
+		userID,
+	).Scan(&providerID, &categoryID); err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	var userLatitude, userLongitude *float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT locations.latitude, locations.longitude
+		FROM users
+		LEFT JOIN locations ON locations.location_id = users.location_id
+		WHERE users.user_id = ?
+		`,
+		userID,
+	).Scan(&userLatitude, &userLongitude); err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	profile := &app.ProviderProfile{HasCategory: categoryID.Valid}
+	depths := map[string]int{}
+	if categoryID.Valid {
+		var err error
+		if depths, err = categoryDepths(ctx, tx, categoryID.String); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	serviceCategories := map[string]bool{}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT category_id
+		FROM services
+		WHERE provider_id = ? AND category_id IS NOT NULL AND deleted_at IS NULL
+		`,
+		providerID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		serviceCategories[id] = true
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	// Count the provider's decided bids, in total and by category.
+	accepted, decided := map[string]int{}, map[string]int{}
+	rows, err = tx.QueryContext(ctx, `
+		SELECT COALESCE(bookings.category_id, ''), bids.status, COUNT(*)
+		FROM bids
+		JOIN bookings ON bookings.booking_id = bids.booking_id
+		WHERE bids.provider_id = ? AND bids.status IN (?, ?)
+		GROUP BY bookings.category_id, bids.status
+		`,
+		providerID,
+		app.BidAccepted,
+		app.BidRejected,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var category, status string
+		var n int
+		if err := rows.Scan(&category, &status, &n); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		decided[category] += n
+		profile.BidsDecided += n
+		if status == app.BidAccepted {
+			accepted[category] += n
+			profile.BidsAccepted += n
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT
+			bookings.booking_id,
+			bookings.title,
+			categories.name,
+			COALESCE(bookings.category_id, ''),
+			bookings.is_urgent,
+			bookings.start_at,
+			bookings.created_at,
+			locations.latitude,
+			locations.longitude,
+			locations.address,
+			(SELECT COUNT(*) FROM reviews WHERE reviews.author_id = bookings.client_id AND reviews.deleted_at IS NULL),
+			(SELECT AVG(rating) FROM reviews WHERE reviews.author_id = bookings.client_id AND reviews.deleted_at IS NULL)
+		FROM bookings
+		LEFT JOIN categories ON categories.id = bookings.category_id
+		LEFT JOIN locations ON locations.location_id = bookings.location_id
+		WHERE bookings.is_request = 1
+		AND bookings.status = ?
+		AND bookings.client_id <> ?
+		AND NOT EXISTS (
+			SELECT 1 FROM bids
+			WHERE bids.booking_id = bookings.booking_id AND bids.provider_id = ? AND bids.status IN (?, ?)
+		)
+		`,
+		app.BookingBidding,
+		userID,
+		providerID,
+		app.BidActive,
+		app.BidAccepted,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*app.RequestCandidate{}
+	for rows.Next() {
+		var candidate app.RequestCandidate
+		var category, createdAt string
+		var latitude, longitude *float64
+		var address sql.NullString
+		var clientRating sql.NullFloat64
+		if err := rows.Scan(
+			&candidate.Request.ID,
+			&candidate.Request.Title,
+			&candidate.Request.Category,
+			&category,
+			&candidate.Request.Urgent,
+			&candidate.Request.StartAt,
+			&createdAt,
+			&latitude,
+			&longitude,
+			&address,
+			&candidate.ClientReviews,
+			&clientRating,
+		); err != nil {
+			return nil, nil, err
+		}
+		if candidate.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, nil, err
+		}
+		candidate.Request.CreatedAt = candidate.CreatedAt.Format(time.RFC3339)
+		candidate.Request.Address = address.String
+		candidate.ClientRating = clientRating.Float64
+
+		if userLatitude != nil && userLongitude != nil && latitude != nil && longitude != nil {
+			distance := calculateDistance(*userLatitude, *userLongitude, *latitude, *longitude)
+			candidate.DistanceKm = &distance
+			candidate.Request.Distance = fmt.Sprintf("%.1f", distance)
+		}
+		if depth, ok := depths[category]; ok {
+			candidate.CategoryDepth = &depth
+		}
+		candidate.ServiceMatch = serviceCategories[category]
+		candidate.BidsAccepted = accepted[category]
+		candidate.BidsDecided = decided[category]
+
+		candidates = append(candidates, &candidate)
+	}
+	return profile, candidates, rows.Err()
+}
+
+// notify sends msg to each phone. The change it reports is already saved, so
+// failures are only logged.
+func (s *RequestService) notify(ctx context.Context, phones []string, msg string) {
+	for _, phone := range phones {
+		if err := s.sms.SendSMS(ctx, phone, msg); err != nil {
+			log.Printf("failed notifying bidder %s: %s", phone, err)
+		}
+	}
+}
+
+// resolveRequestCategory checks that the category of a request exists, or
+// suggests one from its title if it has none.
+func resolveRequestCategory(ctx context.Context, tx *Tx, request *model.Request) error {
+	if request.CategoryID == "" {
+		categories, err := suggestCategories(ctx, tx, request.Title)
+		if err != nil {
+			return err
+		}
+		if len(categories) == 0 {
+			return app.Errorf(app.INVALID_ERR, "category_id: no category matches the title, choose one")
+		}
+		request.CategoryID = strconv.Itoa(categories[0].ID)
+		return nil
+	}
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM categories WHERE id = ?
+		`,
+		request.CategoryID,
+	).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return app.Errorf(app.INVALID_ERR, "Category not found.")
+	}
+	return nil
+}
+
+func findRequestStatus(ctx context.Context, tx *Tx, id uuid.UUID) (string, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, `
+		SELECT status FROM bookings WHERE booking_id = ? AND is_request = 1
+		`,
+		id,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", app.Errorf(app.NOTFOUND_ERR, "Request not found.")
+	}
+	return status, err
+}
+
+func findRequestTitle(ctx context.Context, tx *Tx, id uuid.UUID) (string, error) {
+	var title sql.NullString
+	err := tx.QueryRowContext(ctx, `
+		SELECT title FROM bookings WHERE booking_id = ?
+		`,
+		id,
+	).Scan(&title)
+	return title.String, err
+}
+
+// findBidderPhones returns the phone numbers of the providers whose bids on a
+// request have one of the given statuses.
+func findBidderPhones(ctx context.Context, tx *Tx, id uuid.UUID, statuses ...string) ([]string, error) {
+	args := []interface{}{id}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT users.phone
+		FROM bids
+		JOIN providers ON providers.provider_id = bids.provider_id
+		JOIN users ON users.user_id = providers.user_id
+		WHERE bids.booking_id = ? AND bids.status IN (`+placeholders(len(statuses))+`)
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	phones := []string{}
+	for rows.Next() {
+		var phone string
+		if err := rows.Scan(&phone); err != nil {
+			return nil, err
+		}
+		phones = append(phones, phone)
+	}
+	return phones, rows.Err()
+}
+
+// placeholders returns n comma separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// setRequestBidsStatus moves the bids on a request from one status to another.
+func setRequestBidsStatus(ctx context.Context, tx *Tx, id uuid.UUID, from string, to string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE bids SET
+			status = ?,
+			updated_at = ?
+		WHERE booking_id = ? AND status = ?
+		`,
+		to,
+		tx.now,
+		id,
+		from,
+	)
+	return err
+}
@@ -0,0 +1,376 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+func (s *BookingService) ProposeReschedule(ctx context.Context, p *model.RescheduleProposal) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := findReschedulableBooking(ctx, tx, p.BookingID, p.ActorID)
+	if err != nil {
+		return err
+	}
+
+	start, err := app.ParseDateTime(p.StartDate)
+	if err != nil {
+		return app.Errorf(app.INVALID_ERR, "start_date: must be like %s.", app.DateTimeFormat)
+	}
+	if start.Equal(booking.startAt) {
+		return app.Errorf(app.INVALID_ERR, "start_date: the booking already starts at this time.")
+	}
+	end, err := reserveSlot(ctx, tx, booking.providerID, start, booking.duration, p.BookingID.String())
+	if err != nil {
+		return err
+	}
+	p.StartDate = start.Format(app.DateTimeFormat)
+
+	// A proposal replaces the pending one, countering it if the other party
+	// made it.
+	action := "Proposed"
+	var pendingID int
+	var proposedBy string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, proposed_by
+		FROM booking_reschedules
+		WHERE booking_id = ? AND status = ?
+		`,
+		p.BookingID,
+		app.ReschedulePending,
+	).Scan(&pendingID, &proposedBy)
+	if err == nil {
+		status := app.RescheduleSuperseded
+		if proposedBy != p.ActorID {
+			status, action = app.RescheduleCountered, "Countered with"
+		}
+		if err := closeReschedule(ctx, tx, pendingID, status); err != nil {
+			return err
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_reschedules (
+			booking_id,
+			proposed_by,
+			from_start_at,
+			start_at,
+			end_at,
+			status,
+			reason,
+			created_at
+		) VALUES (?,?,?,?,?,?,?,?)
+		`,
+		p.BookingID,
+		p.ActorID,
+		booking.startAt.Format(app.DateTimeFormat),
+		p.StartDate,
+		end.Format(app.DateTimeFormat),
+		app.ReschedulePending,
+		p.Reason,
+		tx.now,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.ID = int(id)
+
+	reason := fmt.Sprintf("%s moving the booking to %s.", action, p.StartDate)
+	if p.Reason != nil {
+		reason += " " + *p.Reason
+	}
+	if err := createRescheduleEvent(ctx, tx, p.BookingID, booking.status, p.ActorID, reason, p.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *BookingService) DecideReschedule(ctx context.Context, d *model.RescheduleDecision) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, proposedBy, proposedStart string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT status, proposed_by, start_at
+		FROM booking_reschedules
+		WHERE id = ? AND booking_id = ?
+		`,
+		d.RescheduleID,
+		d.BookingID,
+	).Scan(&status, &proposedBy, &proposedStart); err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Reschedule not found.")
+	} else if err != nil {
+		return err
+	}
+	startAt, err := parseTime(proposedStart)
+	if err != nil {
+		return err
+	}
+	if status != app.ReschedulePending {
+		return app.Errorf(app.CONFLICT_ERR, "This proposal is already %s.", status)
+	}
+	if proposedBy == d.ActorID {
+		return app.Errorf(app.FORBIDDEN_ERR, "Only the other party can accept or decline this proposal.")
+	}
+
+	booking, err := findReschedulableBooking(ctx, tx, d.BookingID, d.ActorID)
+	if err != nil {
+		return err
+	}
+	to := startAt.Format(app.DateTimeFormat)
+
+	if !d.Accept {
+		if err := closeReschedule(ctx, tx, d.RescheduleID, app.RescheduleDeclined); err != nil {
+			return err
+		}
+		reason := fmt.Sprintf("Declined moving the booking to %s.", to)
+		if err := createRescheduleEvent(ctx, tx, d.BookingID, booking.status, d.ActorID, reason, d.RescheduleID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// The time may have been taken since it was proposed.
+	end, err := reserveSlot(ctx, tx, booking.providerID, startAt, booking.duration, d.BookingID.String())
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE bookings
+		SET start_at = ?, end_at = ?, updated_at = ?
+		WHERE booking_id = ? AND status = ?
+		`,
+		to,
+		end.Format(app.DateTimeFormat),
+		tx.now,
+		d.BookingID,
+		booking.status,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return app.Errorf(app.CONFLICT_ERR, "Booking was modified concurrently.")
+	}
+
+	if err := closeReschedule(ctx, tx, d.RescheduleID, app.RescheduleAccepted); err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("Moved the booking from %s to %s.", booking.startAt.Format(app.DateTimeFormat), to)
+	if err := createRescheduleEvent(ctx, tx, d.BookingID, booking.status, d.ActorID, reason, d.RescheduleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *BookingService) ListReschedules(ctx context.Context, bookingID uuid.UUID) ([]*app.BookingReschedule, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			booking_id,
+			proposed_by,
+			from_start_at,
+			start_at,
+			end_at,
+			status,
+			reason,
+			created_at,
+			decided_at
+		FROM booking_reschedules
+		WHERE booking_id = ?
+		ORDER BY id
+		`,
+		bookingID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reschedules := make([]*app.BookingReschedule, 0)
+	for rows.Next() {
+		var r app.BookingReschedule
+		var from, start, end string
+		if err := rows.Scan(
+			&r.ID,
+			&r.BookingID,
+			&r.ProposedBy,
+			&from,
+			&start,
+			&end,
+			&r.Status,
+			&r.Reason,
+			&r.CreatedAt,
+			&r.DecidedAt,
+		); err != nil {
+			return nil, err
+		}
+		for _, t := range []struct {
+			value string
+			field *string
+		}{
+			{from, &r.FromStartAt},
+			{start, &r.StartAt},
+			{end, &r.EndAt},
+		} {
+			parsed, err := parseTime(t.value)
+			if err != nil {
+				return nil, err
+			}
+			*t.field = parsed.Format(app.DateTimeFormat)
+		}
+		reschedules = append(reschedules, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reschedules, tx.Commit()
+}
+
+// reschedulableBooking is what moving a booking needs to know about it.
+type reschedulableBooking struct {
+	status     string
+	providerID string
+	startAt    time.Time
+	duration   time.Duration
+}
+
+// findReschedulableBooking returns a booking that an actor, its client or
+// provider, may move to another time.
+func findReschedulableBooking(ctx context.Context, tx *Tx, id uuid.UUID, actorID string) (*reschedulableBooking, error) {
+	var b reschedulableBooking
+	var startAt, clientID string
+	var providerID, providerUserID sql.NullString
+	var duration sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bookings.status,
+			bookings.start_at,
+			bookings.client_id,
+			bookings.provider_id,
+			providers.user_id,
+			services.duration
+		FROM bookings
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		LEFT JOIN services ON services.id = bookings.service_id
+		WHERE bookings.booking_id = ?
+		`,
+		id,
+	).Scan(&b.status, &startAt, &clientID, &providerID, &providerUserID, &duration); err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Booking not found.")
+	} else if err != nil {
+		return nil, err
+	}
+	var err error
+	if b.startAt, err = parseTime(startAt); err != nil {
+		return nil, err
+	}
+
+	if actorID != clientID && actorID != providerUserID.String {
+		return nil, app.Errorf(app.FORBIDDEN_ERR, "Only the client and provider can reschedule this booking.")
+	}
+	reschedulable := false
+	for _, status := range app.ReschedulableBookingStatuses {
+		reschedulable = reschedulable || b.status == status
+	}
+	if !reschedulable {
+		return nil, app.Errorf(app.CONFLICT_ERR, "A booking that is %s cannot be rescheduled.", b.status)
+	}
+	if !providerID.Valid {
+		return nil, app.Errorf(app.CONFLICT_ERR, "A booking without a provider cannot be rescheduled, edit the request instead.")
+	}
+
+	b.providerID = providerID.String
+	b.duration = app.DefaultServiceDuration * time.Minute
+	if duration.Valid {
+		b.duration = time.Duration(duration.Int64) * time.Minute
+	}
+	return &b, nil
+}
+
+// closeReschedule moves a pending proposal to a final status.
+func closeReschedule(ctx context.Context, tx *Tx, id int, status string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE booking_reschedules
+		SET status = ?, decided_at = ?
+		WHERE id = ? AND status = ?
+		`,
+		status,
+		tx.now,
+		id,
+		app.ReschedulePending,
+	)
+	return err
+}
+
+// cancelPendingReschedules cancels the pending proposal to move a booking, if
+// the booking moved to a status in which it can no longer be rescheduled.
+func cancelPendingReschedules(ctx context.Context, tx *Tx, bookingID uuid.UUID, status string) error {
+	for _, s := range app.ReschedulableBookingStatuses {
+		if s == status {
+			return nil
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE booking_reschedules
+		SET status = ?, decided_at = ?
+		WHERE booking_id = ? AND status = ?
+		`,
+		app.RescheduleCancelled,
+		tx.now,
+		bookingID,
+		app.ReschedulePending,
+	)
+	return err
+}
+
+// createRescheduleEvent records a step of a proposal to move a booking in its
+// history. The status of the booking does not change.
+func createRescheduleEvent(ctx context.Context, tx *Tx, bookingID uuid.UUID, status string, actorID string, reason string, rescheduleID int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_events (
+			booking_id,
+			from_status,
+			to_status,
+			actor_id,
+			reason,
+			reschedule_id,
+			created_at
+		) VALUES (?,?,?,?,?,?,?)
+		`,
+		bookingID,
+		status,
+		status,
+		actorID,
+		reason,
+		rescheduleID,
+		tx.now,
+	)
+	return err
+}
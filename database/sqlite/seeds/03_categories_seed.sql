@@ -3,35 +3,41 @@ INSERT INTO `categories` (
     `parent_id`,
     `icon_url`,
     `level`,
-    `industry_id`
+    `industry_id`,
+    `keywords`
 ) VALUES (
     'Artisan',
     NULL,
     'https://www.clipartmax.com/png/middle/106-1061107_plumber-free-icon-construction.png',
     0,
-    NULL
+    NULL,
+    'repair,fix,install,build'
 ), (
     'Professional',
     NULL,
     'https://www.clipartmax.com/png/middle/106-1061107_plumber-free-icon-construction.png',
     0,
+    NULL,
     NULL
 ), (
     'Optician',
     1,
     'https://www.clipartmax.com/png/middle/106-1061107_plumber-free-icon-construction.png',
     1,
-    1
+    1,
+    'eye,eyes,glasses,spectacles,lens,lenses,sight'
 ), (
     'Surgeon',
     2,
     'https://www.clipartmax.com/png/middle/106-1061107_plumber-free-icon-construction.png',
     1,
-    1
+    1,
+    'surgery,operation,surgical'
 ), (
     'Nurse',
     1,
     'https://www.clipartmax.com/png/middle/106-1061107_plumber-free-icon-construction.png',
     1,
-    1
+    1,
+    'nursing,care,injection,dressing,wound'
 );
\ No newline at end of file
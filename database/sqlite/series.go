@@ -0,0 +1,630 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+type SeriesService struct {
+	db *DB
+	// Horizon is how far ahead the bookings of a series are made.
+	Horizon time.Duration
+}
+
+func NewSeriesService(db *DB) *SeriesService {
+	return &SeriesService{db: db, Horizon: app.DefaultSeriesHorizon}
+}
+
+func (s *SeriesService) CreateSeries(ctx context.Context, series *model.BookingSeries) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rule := series.Rule
+	if preset, ok := app.RecurrencePresets[series.Frequency]; ok {
+		rule = preset
+	}
+	parsed, err := app.ParseRecurrenceRule(rule)
+	if err != nil {
+		return err
+	}
+	series.Rule = parsed.String()
+
+	start, err := app.ParseDateTime(series.StartDate)
+	if err != nil {
+		return app.Errorf(app.INVALID_ERR, "start_date: must be like %s.", app.DateTimeFormat)
+	}
+	series.StartDate = start.Format(app.DateTimeFormat)
+
+	// Bookings of a series are made without the client at hand, so they
+	// need a way to pay for them.
+	if ok, err := hasVerifiedPaymentMethod(ctx, tx, series.ClientID); err != nil {
+		return err
+	} else if !ok {
+		return app.Errorf(app.INVALID_ERR, "Add and verify a payment method before booking a recurring service.")
+	}
+
+	series.ID = uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_series (
+			series_id,
+			client_id,
+			provider_id,
+			service_id,
+			location_id,
+			rule,
+			start_at,
+			status,
+			generated_until,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		series.ID,
+		series.ClientID,
+		series.ProviderID,
+		series.ServiceID,
+		series.LocationID,
+		series.Rule,
+		series.StartDate,
+		app.SeriesActive,
+		series.StartDate,
+		tx.now,
+		tx.now,
+	); err != nil {
+		return err
+	}
+
+	occurrences, err := generateSeries(ctx, tx, series.ID, wallClock(tx.now).Add(s.Horizon))
+	if err != nil {
+		return err
+	}
+	// The first booking must be made for the series to be created.
+	if len(occurrences) > 0 && occurrences[0].Status == app.OccurrenceFailed {
+		return app.Errorf(app.INVALID_ERR, "start_date: %s", *occurrences[0].Note)
+	}
+	return tx.Commit()
+}
+
+func (s *SeriesService) FindSeriesByID(ctx context.Context, id string, userID string) (*app.BookingSeries, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	series, err := findSeries(ctx, tx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if series.Occurrences, err = listSeriesOccurrences(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	return series, tx.Commit()
+}
+
+func (s *SeriesService) ListSeries(ctx context.Context, userID string) ([]*app.BookingSeries, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+seriesColumns+`
+		FROM booking_series
+		WHERE client_id = ?
+		OR provider_id IN (SELECT provider_id FROM providers WHERE user_id = ?)
+		ORDER BY created_at DESC
+		`,
+		userID,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]*app.BookingSeries, 0)
+	for rows.Next() {
+		series, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, series)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, tx.Commit()
+}
+
+func (s *SeriesService) SkipOccurrence(ctx context.Context, id string, date string, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	series, err := findSeries(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if series.Status != app.SeriesActive {
+		return app.Errorf(app.CONFLICT_ERR, "The series is %s.", series.Status)
+	}
+
+	day, err := time.Parse(app.DateFormat, date)
+	if err != nil {
+		return app.Errorf(app.INVALID_ERR, "date: must be like %s.", app.DateFormat)
+	}
+	rule, err := app.ParseRecurrenceRule(series.Rule)
+	if err != nil {
+		return err
+	}
+	start, _ := app.ParseDateTime(series.StartAt)
+	occurrences := rule.Occurrences(start, day, day.AddDate(0, 0, 1))
+	if len(occurrences) == 0 {
+		return app.Errorf(app.INVALID_ERR, "date: the series does not recur on %s.", date)
+	}
+
+	note := "Skipped by the provider."
+	if userID == series.ClientID {
+		note = "Skipped by the client."
+	}
+	for _, t := range occurrences {
+		if t.Before(wallClock(tx.now)) {
+			return app.Errorf(app.INVALID_ERR, "date: the occurrence on %s has passed.", date)
+		}
+		occursAt := t.Format(app.DateTimeFormat)
+
+		var status string
+		var bookingID, bookingStatus sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT
+				booking_series_occurrences.status,
+				booking_series_occurrences.booking_id,
+				bookings.status
+			FROM booking_series_occurrences
+			LEFT JOIN bookings ON bookings.booking_id = booking_series_occurrences.booking_id
+			WHERE booking_series_occurrences.series_id = ?
+			AND booking_series_occurrences.occurs_at = ?
+			`,
+			id,
+			occursAt,
+		).Scan(&status, &bookingID, &bookingStatus)
+		if err == sql.ErrNoRows {
+			// Not booked yet, so it will not be.
+			if err := createSeriesOccurrence(ctx, tx, id, occursAt, app.OccurrenceSkipped, nil, &note); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if status == app.OccurrenceSkipped {
+			return app.Errorf(app.CONFLICT_ERR, "The occurrence on %s is already skipped.", date)
+		}
+		switch bookingStatus.String {
+		case "", app.BookingCancelled:
+		case app.BookingRequested, app.BookingAccepted:
+			reason := "Skipped in its series."
+			if _, err := cancelBooking(ctx, tx, &model.BookingCancellation{
+				BookingID: uuid.MustParse(bookingID.String),
+				ActorID:   userID,
+				Reason:    &reason,
+			}); err != nil {
+				return err
+			}
+		default:
+			return app.Errorf(app.CONFLICT_ERR, "The booking on %s is %s and cannot be skipped.", date, bookingStatus.String)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE booking_series_occurrences
+			SET status = ?, note = ?
+			WHERE series_id = ? AND occurs_at = ?
+			`,
+			app.OccurrenceSkipped,
+			note,
+			id,
+			occursAt,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SeriesService) CancelSeries(ctx context.Context, id string, userID string, reason *string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	series, err := findSeries(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if series.Status != app.SeriesActive {
+		return app.Errorf(app.CONFLICT_ERR, "The series is already %s.", series.Status)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE booking_series SET status = ?, updated_at = ? WHERE series_id = ?
+		`,
+		app.SeriesCancelled,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+
+	// Cancel the bookings made ahead of time that have not started.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT booking_id
+		FROM bookings
+		WHERE series_id = ? AND status IN (?, ?)
+		`,
+		id,
+		app.BookingRequested,
+		app.BookingAccepted,
+	)
+	if err != nil {
+		return err
+	}
+	var bookingIDs []uuid.UUID
+	for rows.Next() {
+		var bookingID uuid.UUID
+		if err := rows.Scan(&bookingID); err != nil {
+			rows.Close()
+			return err
+		}
+		bookingIDs = append(bookingIDs, bookingID)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if reason == nil {
+		msg := "The series was cancelled."
+		reason = &msg
+	}
+	// Bookings that cost a fee to cancel are cancelled one by one, accepting
+	// the fee, before the series.
+	for _, bookingID := range bookingIDs {
+		if _, err := cancelBooking(ctx, tx, &model.BookingCancellation{
+			BookingID: bookingID,
+			ActorID:   userID,
+			Reason:    reason,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SeriesService) GenerateSeriesBookings(ctx context.Context) (int, error) {
+	horizon := wallClock(s.db.Now()).Add(s.Horizon)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT series_id
+		FROM booking_series
+		WHERE status = ? AND generated_until < ?
+		ORDER BY generated_until
+		`,
+		app.SeriesActive,
+		horizon.Format(app.DateTimeFormat),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	tx.Rollback()
+
+	// Each series is generated in its own transaction so that one failing
+	// does not hold back the others.
+	handled := 0
+	for _, id := range ids {
+		n, err := s.generate(ctx, id, horizon)
+		if err != nil {
+			log.Printf("generating series %s failed: %s", id, err)
+			continue
+		}
+		handled += n
+	}
+	return handled, nil
+}
+
+func (s *SeriesService) generate(ctx context.Context, id string, horizon time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	occurrences, err := generateSeries(ctx, tx, id, horizon)
+	if err != nil {
+		return 0, err
+	}
+	return len(occurrences), tx.Commit()
+}
+
+// generateSeries makes the bookings of the occurrences of a series from the
+// time it was generated until up to horizon and returns the occurrences. An
+// occurrence whose booking cannot be made fails with the reason as its note.
+// Occurrences already recorded, because they were skipped, are left alone.
+func generateSeries(ctx context.Context, tx *Tx, id string, horizon time.Time) ([]*app.SeriesOccurrence, error) {
+	var series app.BookingSeries
+	var startAt, generatedUntilAt string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT client_id, provider_id, service_id, location_id, rule, start_at, generated_until
+		FROM booking_series
+		WHERE series_id = ? AND status = ?
+		`,
+		id,
+		app.SeriesActive,
+	).Scan(
+		&series.ClientID,
+		&series.ProviderID,
+		&series.ServiceID,
+		&series.LocationID,
+		&series.Rule,
+		&startAt,
+		&generatedUntilAt,
+	); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	start, err := parseTime(startAt)
+	if err != nil {
+		return nil, err
+	}
+	generatedUntil, err := parseTime(generatedUntilAt)
+	if err != nil {
+		return nil, err
+	}
+	if !generatedUntil.Before(horizon) {
+		return nil, nil
+	}
+
+	rule, err := app.ParseRecurrenceRule(series.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the series so that a concurrent run does not generate it too.
+	status := app.SeriesActive
+	if rule.EndsBefore(start, horizon) {
+		status = app.SeriesEnded
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE booking_series
+		SET generated_until = ?, status = ?, updated_at = ?
+		WHERE series_id = ? AND generated_until = ?
+		`,
+		horizon.Format(app.DateTimeFormat),
+		status,
+		tx.now,
+		id,
+		generatedUntil.Format(app.DateTimeFormat),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, nil
+	}
+
+	hasPaymentMethod, err := hasVerifiedPaymentMethod(ctx, tx, series.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var occurrences []*app.SeriesOccurrence
+	for _, t := range rule.Occurrences(start, generatedUntil, horizon) {
+		occursAt := t.Format(app.DateTimeFormat)
+		var recorded int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM booking_series_occurrences WHERE series_id = ? AND occurs_at = ?
+			`,
+			id,
+			occursAt,
+		).Scan(&recorded); err != nil {
+			return nil, err
+		}
+		if recorded > 0 {
+			continue
+		}
+
+		occurrence := &app.SeriesOccurrence{OccursAt: occursAt, Status: app.OccurrenceBooked}
+		booking := &model.Booking{
+			ID:         uuid.New(),
+			StartDate:  occursAt,
+			LocationID: series.LocationID,
+			ProviderID: series.ProviderID,
+			ClientID:   series.ClientID,
+			ServiceID:  strconv.Itoa(series.ServiceID),
+			SeriesID:   &id,
+		}
+
+		var appErr *app.Error
+		if !hasPaymentMethod {
+			note := "The client has no verified payment method."
+			occurrence.Status, occurrence.Note = app.OccurrenceFailed, &note
+		} else if err := createBooking(ctx, tx, booking); errors.As(err, &appErr) {
+			occurrence.Status, occurrence.Note = app.OccurrenceFailed, &appErr.Message
+		} else if err != nil {
+			return nil, err
+		} else {
+			bookingID := booking.ID.String()
+			occurrence.BookingID = &bookingID
+		}
+
+		if err := createSeriesOccurrence(ctx, tx, id, occursAt, occurrence.Status, occurrence.BookingID, occurrence.Note); err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences, nil
+}
+
+func createSeriesOccurrence(ctx context.Context, tx *Tx, seriesID string, occursAt string, status string, bookingID *string, note *string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_series_occurrences (
+			series_id,
+			occurs_at,
+			status,
+			booking_id,
+			note,
+			created_at
+		) VALUES (?,?,?,?,?,?)
+		`,
+		seriesID,
+		occursAt,
+		status,
+		bookingID,
+		note,
+		tx.now,
+	)
+	return err
+}
+
+// hasVerifiedPaymentMethod reports whether a user has a payment method they
+// verified.
+func hasVerifiedPaymentMethod(ctx context.Context, tx *Tx, userID string) (bool, error) {
+	var n int
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM payment_methods WHERE user_id = ? AND verified_at IS NOT NULL
+		`,
+		userID,
+	).Scan(&n)
+	return n > 0, err
+}
+
+const seriesColumns = `
+	series_id,
+	client_id,
+	provider_id,
+	service_id,
+	location_id,
+	rule,
+	start_at,
+	status,
+	generated_until,
+	created_at
+`
+
+func scanSeries(row interface{ Scan(...interface{}) error }) (*app.BookingSeries, error) {
+	var series app.BookingSeries
+	var startAt, generatedUntilAt string
+	if err := row.Scan(
+		&series.ID,
+		&series.ClientID,
+		&series.ProviderID,
+		&series.ServiceID,
+		&series.LocationID,
+		&series.Rule,
+		&startAt,
+		&series.Status,
+		&generatedUntilAt,
+		&series.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	start, err := parseTime(startAt)
+	if err != nil {
+		return nil, err
+	}
+	generatedUntil, err := parseTime(generatedUntilAt)
+	if err != nil {
+		return nil, err
+	}
+	series.StartAt = start.Format(app.DateTimeFormat)
+	series.GeneratedUntil = generatedUntil.Format(app.DateTimeFormat)
+	return &series, nil
+}
+
+// findSeries returns a series of which the user is the client or provider.
+func findSeries(ctx context.Context, tx *Tx, id string, userID string) (*app.BookingSeries, error) {
+	series, err := scanSeries(tx.QueryRowContext(ctx, `
+		SELECT `+seriesColumns+`
+		FROM booking_series
+		WHERE series_id = ?
+		AND (client_id = ? OR provider_id IN (SELECT provider_id FROM providers WHERE user_id = ?))
+		`,
+		id,
+		userID,
+		userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Series not found.")
+	}
+	return series, err
+}
+
+func listSeriesOccurrences(ctx context.Context, tx *Tx, id string) ([]*app.SeriesOccurrence, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			booking_series_occurrences.occurs_at,
+			booking_series_occurrences.status,
+			booking_series_occurrences.booking_id,
+			bookings.status,
+			booking_series_occurrences.note
+		FROM booking_series_occurrences
+		LEFT JOIN bookings ON bookings.booking_id = booking_series_occurrences.booking_id
+		WHERE booking_series_occurrences.series_id = ?
+		ORDER BY booking_series_occurrences.occurs_at
+		`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := make([]*app.SeriesOccurrence, 0)
+	for rows.Next() {
+		var o app.SeriesOccurrence
+		var occursAt string
+		if err := rows.Scan(&occursAt, &o.Status, &o.BookingID, &o.BookingStatus, &o.Note); err != nil {
+			return nil, err
+		}
+		t, err := parseTime(occursAt)
+		if err != nil {
+			return nil, err
+		}
+		o.OccursAt = t.Format(app.DateTimeFormat)
+		occurrences = append(occurrences, &o)
+	}
+	return occurrences, rows.Err()
+}
@@ -7,16 +7,13 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	//_ "github.com/mattn/go-sqlite3"
-)
-
-const (
-	statusPending  = "pending"
-	statusCanceled = "canceled"
 )
 
 //go:embed migrations/*.sql
@@ -35,16 +32,23 @@ type DB struct {
 	ctx    context.Context // background context
 	cancel func()          // cancel background context
 	Now    func() time.Time
+	// TaxRate is the percentage of tax included in invoiced prices.
+	TaxRate float64
 	// Datasource name.
 	DSN string
+	// Driver and driver specific datasource derived from DSN.
+	driver string
+	source string
 }
 
 // NewDB returns a new instance of DB associated with the given datasource name.
+// The database driver is chosen from the DSN scheme, see parseDSN.
 func NewDB(dsn string) *DB {
 	db := &DB{
 		DSN: dsn,
 		Now: time.Now,
 	}
+	db.driver, db.source = parseDSN(dsn)
 	db.ctx, db.cancel = context.WithCancel(context.Background())
 	return db
 }
@@ -56,15 +60,12 @@ func (db *DB) Open() (err error) {
 		return fmt.Errorf("dsn required")
 	}
 
-	// Make the parent directory unless using an in-memory db.
-	/*if db.DSN != ":memory:" {
-		if err := os.MkdirAll(filepath.Dir(db.DSN), 0700); err != nil {
-			return err
-		}
-	}*/
+	if db.driver == driverSQLite {
+		return db.openSQLite()
+	}
 
 	// Connect to the database.
-	if db.db, err = sql.Open("mysql", db.DSN); err != nil {
+	if db.db, err = sql.Open(db.driver, db.source); err != nil {
 		return err
 	}
 
@@ -72,29 +73,54 @@ func (db *DB) Open() (err error) {
 	db.db.SetMaxOpenConns(25)
 	db.db.SetMaxIdleConns(25)
 
+	return nil
+}
+
+func (db *DB) openSQLite() (err error) {
+	// Make the parent directory unless using an in-memory db or a URI.
+	if !isMemorySource(db.source) && !strings.HasPrefix(db.source, "file:") {
+		if dir := filepath.Dir(db.source); dir != "." {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Connect to the database. Foreign key checks are switched on for every
+	// connection by sqliteConnect.
+	if db.db, err = sql.Open(db.driver, db.source); err != nil {
+		return err
+	}
+
+	// SQLite allows a single writer at a time, so share one connection. This
+	// also keeps an in-memory database alive for the lifetime of the DB.
+	db.db.SetMaxOpenConns(1)
+	db.db.SetMaxIdleConns(1)
+	db.db.SetConnMaxLifetime(0)
+
 	// Enable WAL. SQLite performs better with the WAL  because it allows
 	// multiple readers to operate while data is being written.
-	//if _, err := db.db.Exec(`PRAGMA journal_mode = wal;`); err != nil {
-	//	return fmt.Errorf("enable wal: %w", err)
-	//}
-
-	// Enable foreign key checks. For historical reasons, SQLite does not check
-	// foreign key constraints by default... which is kinda insane. There's some
-	// overhead on inserts to verify foreign key integrity but it's definitely
-	// worth it.
-	//if _, err := db.db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
-	//	return fmt.Errorf("foreign keys pragma: %w", err)
-	//}
-	/*
-		if err := db.migrate(); err != nil {
-			return fmt.Errorf("migrate: %w", err)
+	if !isMemorySource(db.source) {
+		if _, err := db.db.Exec(`PRAGMA journal_mode = wal;`); err != nil {
+			return fmt.Errorf("enable wal: %w", err)
 		}
-		time.Sleep(1 * time.Second)
+	}
 
-		if err := db.seed(); err != nil {
-			log.Println("seeding error:", err)
-		}
-	*/
+	return nil
+}
+
+// IsMemory reports whether the database lives in memory only, in which case
+// it has to be migrated every time it is opened.
+func (db *DB) IsMemory() bool {
+	return db.driver == driverSQLite && isMemorySource(db.source)
+}
+
+// Close closes the database connection and cancels the background context.
+func (db *DB) Close() error {
+	db.cancel()
+	if db.db != nil {
+		return db.db.Close()
+	}
 	return nil
 }
 
@@ -132,7 +158,7 @@ func (db *DB) Drop() error {
 	sort.Strings(names)
 
 	// disable foreign key checks
-	if _, err := db.db.Exec(`SET foreign_key_checks = 0;`); err == nil {
+	if _, err := db.db.Exec(db.foreignKeyChecks(false)); err == nil {
 		log.Println("disable foreign key checks:")
 	}
 
@@ -144,7 +170,7 @@ func (db *DB) Drop() error {
 	}
 
 	// enable foreign key checks
-	if _, err := db.db.Exec(`SET foreign_key_checks = 1;`); err == nil {
+	if _, err := db.db.Exec(db.foreignKeyChecks(true)); err == nil {
 		log.Println("enable foreign key checks:")
 	}
 	log.Println("tables dropped!")
@@ -162,7 +188,7 @@ func (db *DB) dropFile(name string) error {
 	// Read and execute drop file.
 	if buf, err := fs.ReadFile(migrationFS, name); err != nil {
 		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
+	} else if err := db.execFile(tx, string(buf)); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -251,7 +277,7 @@ func (db *DB) migrateFile(name string) error {
 	// Read and execute migration file.
 	if buf, err := fs.ReadFile(migrationFS, name); err != nil {
 		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
+	} else if err := db.execFile(tx, string(buf)); err != nil {
 		return err
 	}
 
@@ -292,9 +318,20 @@ func (db *DB) seedFile(name string) error {
 	// Read and execute seed file.
 	if buf, err := fs.ReadFile(seedFS, name); err != nil {
 		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
+	} else if err := db.execFile(tx, string(buf)); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
+
+// execFile executes the statements of an embedded SQL file one by one after
+// translating them to the dialect of the open database.
+func (db *DB) execFile(tx *sql.Tx, buf string) error {
+	for _, stmt := range splitStatements(db.translate(buf)) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,9 +2,13 @@ package sqlite
 
 import (
 	"context"
+	"database/sql"
 	"log"
+	"time"
 
+	app "github.com/andrwkng/hudumaapp"
 	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
 )
 
 type SubscriptionService struct {
@@ -15,6 +19,10 @@ func NewSubscriptionService(db *DB) *SubscriptionService {
 	return &SubscriptionService{db}
 }
 
+// CreateSubscription subscribes a client to a plan. The subscription stays
+// pending until its first payment, unless the plan has a trial in which case
+// it is active until the trial ends. Pending subscriptions the client already
+// has are cancelled.
 func (s *SubscriptionService) CreateSubscription(ctx context.Context, subscription *model.Subscription) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -29,40 +37,689 @@ func (s *SubscriptionService) CreateSubscription(ctx context.Context, subscripti
 }
 
 func createSubscription(ctx context.Context, tx *Tx, subscription *model.Subscription) error {
+	plan, err := findPlanByID(ctx, tx, subscription.PlanID)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Plan not found.")
+	} else if err != nil {
+		return err
+	}
+	if plan.Status != app.PlanActive {
+		return app.Errorf(app.CONFLICT_ERR, "This plan is no longer offered.")
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM subscriptions
+		WHERE client_id = ? AND status IN (?, ?)
+		`,
+		subscription.ClientID,
+		app.SubscriptionActive,
+		app.SubscriptionPastDue,
+	).Scan(&current); err != nil {
+		return err
+	}
+	if current > 0 {
+		return app.Errorf(app.CONFLICT_ERR, "You already have an active subscription.")
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			auto_renew = FALSE,
+			cancelled_at = ?,
+			ends_at = ?,
+			updated_at = ?
+		WHERE client_id = ? AND status = ?
+		`,
+		app.SubscriptionCancelled,
+		tx.now,
+		tx.now,
+		tx.now,
+		subscription.ClientID,
+		app.SubscriptionPending,
+	); err != nil {
+		return err
+	}
+
+	subscription.SubscriptionID = uuid.NewString()
+	subscription.Status = app.SubscriptionPending
+
+	var activatedAt, startsAt, nextBillingAt, endsAt *time.Time
+	if plan.TrialDays > 0 {
+		trialEnd := tx.now.AddDate(0, 0, plan.TrialDays)
+		subscription.Status = app.SubscriptionActive
+		activatedAt, startsAt, nextBillingAt = &tx.now, &tx.now, &trialEnd
+		if !subscription.AutoRenew {
+			endsAt = &trialEnd
+		}
+	}
+
+	var paymentMethodID *string
+	if subscription.PaymentMethodID != "" {
+		method, err := findPaymentMethodByID(ctx, tx, subscription.PaymentMethodID, subscription.ClientID)
+		if err == sql.ErrNoRows {
+			return app.Errorf(app.NOTFOUND_ERR, "Payment method not found.")
+		} else if err != nil {
+			return err
+		}
+		if !method.Verified {
+			return app.Errorf(app.CONFLICT_ERR, "Verify the payment method first.")
+		}
+		paymentMethodID = &subscription.PaymentMethodID
+	}
+
 	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO subscriptions (
 			subscription_id,
 			client_id,
 			payment_id,
+			payment_method_id,
 			plan_id,
 			auto_renew,
 			status,
 			billing_cycles,
 			next_billing_at,
 			activated_at,
-			cancelled_at,
 			starts_at,
-			expires_at,
-			ends_at
-		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)
+			ends_at,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)
 		`,
 		subscription.SubscriptionID,
 		subscription.ClientID,
-		subscription.PaymentMethodID,
+		"",
+		paymentMethodID,
 		subscription.PlanID,
 		subscription.AutoRenew,
 		subscription.Status,
-		subscription.BillingCycles,
-		subscription.NextBilling,
-		subscription.ActivatedAt,
-		subscription.CancelledAt,
-		subscription.StartsAt,
-		subscription.ExpireBy,
-		subscription.EndsAt,
+		0,
+		nextBillingAt,
+		activatedAt,
+		startsAt,
+		endsAt,
+		tx.now,
+		tx.now,
 	); err != nil {
 		log.Println("failed inserting subscription into db:", err)
 		return err
 	}
 
+	// There is nothing to pay for a free plan, so it starts straight away.
+	if plan.Price == 0 && plan.TrialDays == 0 {
+		subscription.Status = app.SubscriptionActive
+		return activateSubscription(ctx, tx, subscription.SubscriptionID, "")
+	}
 	return nil
 }
+
+// ActivateSubscription starts the first period of a pending subscription once
+// it has been paid for. A subscription that does not renew automatically ends
+// with its first period.
+func (s *SubscriptionService) ActivateSubscription(ctx context.Context, id string, paymentID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := activateSubscription(ctx, tx, id, paymentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func activateSubscription(ctx context.Context, tx *Tx, id string, paymentID string) error {
+	state, err := findSubscriptionState(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if state.status != app.SubscriptionPending {
+		return app.Errorf(app.CONFLICT_ERR, "Subscription cannot be activated while %s.", state.status)
+	}
+
+	plan, err := findPlanByID(ctx, tx, state.planID)
+	if err != nil {
+		return err
+	}
+
+	periodEnd := plan.PeriodEnd(tx.now)
+	var endsAt *time.Time
+	if !state.autoRenew {
+		endsAt = &periodEnd
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			payment_id = ?,
+			billing_cycles = 1,
+			activated_at = ?,
+			starts_at = ?,
+			next_billing_at = ?,
+			ends_at = ?,
+			updated_at = ?
+		WHERE subscription_id = ?
+		`,
+		app.SubscriptionActive,
+		paymentID,
+		tx.now,
+		tx.now,
+		periodEnd,
+		endsAt,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return issueSubscriptionReceipt(ctx, tx, state.clientID, id, paymentID, plan, tx.now)
+}
+
+// RenewSubscription records the payment for the next period of an active or
+// past due subscription. The new period follows on from the previous one, so
+// paying late does not move the billing date. Failed charges are forgotten
+// and the billing lease is released.
+func (s *SubscriptionService) RenewSubscription(ctx context.Context, id string, paymentID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := renewSubscription(ctx, tx, id, paymentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func renewSubscription(ctx context.Context, tx *Tx, id string, paymentID string) error {
+	state, err := findSubscriptionState(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if state.status == app.SubscriptionPending {
+		return app.Errorf(app.CONFLICT_ERR, "Subscription must be activated before it is renewed.")
+	}
+	if err := app.ValidateSubscriptionTransition(state.status, app.SubscriptionActive); err != nil {
+		return err
+	}
+	if !state.autoRenew {
+		return app.Errorf(app.CONFLICT_ERR, "Subscription is set to end with the current period.")
+	}
+
+	plan, err := findPlanByID(ctx, tx, state.planID)
+	if err != nil {
+		return err
+	}
+
+	periodStart := tx.now
+	if state.nextBillingAt.Valid {
+		if periodStart, err = parseTime(state.nextBillingAt.String); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			payment_id = ?,
+			billing_cycles = billing_cycles + 1,
+			next_billing_at = ?,
+			failed_charges = 0,
+			next_attempt_at = NULL,
+			charge_id = NULL,
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = ?
+		WHERE subscription_id = ?
+		`,
+		app.SubscriptionActive,
+		paymentID,
+		plan.PeriodEnd(periodStart),
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return issueSubscriptionReceipt(ctx, tx, state.clientID, id, paymentID, plan, periodStart)
+}
+
+// MarkSubscriptionPastDue records that a renewal payment has failed.
+func (s *SubscriptionService) MarkSubscriptionPastDue(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	state, err := findSubscriptionState(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := app.ValidateSubscriptionTransition(state.status, app.SubscriptionPastDue); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			updated_at = ?
+		WHERE subscription_id = ?
+		`,
+		app.SubscriptionPastDue,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelSubscription turns off renewal of an active subscription so it
+// expires at the end of the period paid for. Pending and past due
+// subscriptions, and any subscription when immediately is set, are cancelled
+// straight away.
+func (s *SubscriptionService) CancelSubscription(ctx context.Context, id string, clientID string, immediately bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	state, err := findSubscriptionState(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if state.clientID != clientID {
+		return app.Errorf(app.NOTFOUND_ERR, "Subscription not found.")
+	}
+	if err := app.ValidateSubscriptionTransition(state.status, app.SubscriptionCancelled); err != nil {
+		return err
+	}
+
+	if state.status == app.SubscriptionActive && state.nextBillingAt.Valid && !immediately {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions SET
+				auto_renew = FALSE,
+				cancelled_at = ?,
+				ends_at = next_billing_at,
+				updated_at = ?
+			WHERE subscription_id = ?
+			`,
+			tx.now,
+			tx.now,
+			id,
+		)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions SET
+				status = ?,
+				auto_renew = FALSE,
+				cancelled_at = ?,
+				ends_at = ?,
+				updated_at = ?
+			WHERE subscription_id = ?
+			`,
+			app.SubscriptionCancelled,
+			tx.now,
+			tx.now,
+			tx.now,
+			id,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SubscriptionService) ExpireSubscriptions(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			updated_at = ?
+		WHERE status IN (?, ?) AND ends_at IS NOT NULL AND ends_at <= ?
+		`,
+		app.SubscriptionExpired,
+		tx.now,
+		app.SubscriptionActive,
+		app.SubscriptionPastDue,
+		tx.now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(expired), tx.Commit()
+}
+
+// ClaimDueSubscriptions leases the subscriptions due for renewal to owner.
+// A subscription is due once its billing date has passed, unless a failed
+// charge is waiting for its retry. The lease is taken with a conditional
+// update so that instances claiming at the same time never share a
+// subscription.
+func (s *SubscriptionService) ClaimDueSubscriptions(ctx context.Context, owner string, lease time.Duration, limit int) ([]*app.Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT subscription_id
+		FROM subscriptions
+		WHERE status IN (?, ?)
+			AND auto_renew = TRUE
+			AND next_billing_at <= ?
+			AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+			AND (locked_until IS NULL OR locked_until <= ?)
+		ORDER BY next_billing_at
+		LIMIT ?
+		`,
+		app.SubscriptionActive,
+		app.SubscriptionPastDue,
+		tx.now,
+		tx.now,
+		tx.now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var due []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := make([]*app.Subscription, 0, len(due))
+	for _, id := range due {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE subscriptions SET
+				locked_by = ?,
+				locked_until = ?
+			WHERE subscription_id = ? AND (locked_until IS NULL OR locked_until <= ?)
+			`,
+			owner,
+			tx.now.Add(lease),
+			id,
+			tx.now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		subscriptions, err := listSubscriptions(ctx, tx, "subscriptions.subscription_id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, subscriptions...)
+	}
+
+	return claimed, tx.Commit()
+}
+
+// RecordFailedRenewal counts a failed charge and releases the billing lease.
+// The subscription is past due until the retry, or expires if there is none.
+func (s *SubscriptionService) RecordFailedRenewal(ctx context.Context, id string, retryAt *time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	state, err := findSubscriptionState(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	status := app.SubscriptionPastDue
+	var endsAt *time.Time
+	if retryAt == nil {
+		status, endsAt = app.SubscriptionExpired, &tx.now
+	}
+	if state.status != status {
+		if err := app.ValidateSubscriptionTransition(state.status, status); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			status = ?,
+			failed_charges = failed_charges + 1,
+			next_attempt_at = ?,
+			charge_id = NULL,
+			ends_at = COALESCE(?, ends_at),
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = ?
+		WHERE subscription_id = ?
+		`,
+		status,
+		retryAt,
+		endsAt,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordPendingRenewal releases the billing lease of a subscription whose
+// charge is waiting for the payment, so it is checked again at checkAt.
+func (s *SubscriptionService) RecordPendingRenewal(ctx context.Context, id string, checkAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			next_attempt_at = ?,
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = ?
+		WHERE subscription_id = ?
+		`,
+		checkAt,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SubscriptionService) FindSubscriptionByID(ctx context.Context, id string, clientID string) (*app.Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscriptions, err := listSubscriptions(ctx, tx, "subscriptions.subscription_id = ? AND subscriptions.client_id = ?", id, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return subscriptions[0], nil
+}
+
+func (s *SubscriptionService) FindActiveSubscription(ctx context.Context, clientID string) (*app.Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscriptions, err := listSubscriptions(ctx, tx,
+		"subscriptions.client_id = ? AND subscriptions.status IN (?, ?)",
+		clientID,
+		app.SubscriptionActive,
+		app.SubscriptionPastDue,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return subscriptions[0], nil
+}
+
+func (s *SubscriptionService) ListSubscriptions(ctx context.Context, clientID string) ([]*app.Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return listSubscriptions(ctx, tx, "subscriptions.client_id = ?", clientID)
+}
+
+func listSubscriptions(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.Subscription, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			subscriptions.subscription_id,
+			subscriptions.client_id,
+			subscriptions.plan_id,
+			plans.code,
+			plans.name,
+			plans.price,
+			plans.currency,
+			plans.interval_count,
+			plans.interval_unit,
+			subscriptions.payment_method_id,
+			subscriptions.auto_renew,
+			subscriptions.status,
+			subscriptions.billing_cycles,
+			subscriptions.failed_charges,
+			subscriptions.next_billing_at,
+			subscriptions.activated_at,
+			subscriptions.cancelled_at,
+			subscriptions.starts_at,
+			subscriptions.ends_at,
+			subscriptions.created_at
+		FROM subscriptions
+		INNER JOIN plans ON plans.plan_id = subscriptions.plan_id
+		WHERE `+where+`
+		ORDER BY subscriptions.created_at DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]*app.Subscription, 0)
+	for rows.Next() {
+		var subscription app.Subscription
+		var plan app.Plan
+		var billingCycles sql.NullInt64
+		if err := rows.Scan(
+			&subscription.SubscriptionID,
+			&subscription.ClientID,
+			&subscription.PlanID,
+			&subscription.Plan,
+			&subscription.PlanName,
+			&plan.Price,
+			&plan.Currency,
+			&plan.Interval,
+			&plan.IntervalUnit,
+			&subscription.PaymentMethodID,
+			&subscription.AutoRenew,
+			&subscription.Status,
+			&billingCycles,
+			&subscription.FailedCharges,
+			&subscription.NextBillingDate,
+			&subscription.ActivatedAt,
+			&subscription.CancelledAt,
+			&subscription.StartsAt,
+			&subscription.EndsAt,
+			&subscription.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		subscription.Price = plan.PriceLabel()
+		subscription.BillingCycles = int(billingCycles.Int64)
+		subscriptions = append(subscriptions, &subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// subscriptionState is what the lifecycle needs to know about a subscription.
+type subscriptionState struct {
+	clientID      string
+	planID        string
+	status        string
+	autoRenew     bool
+	nextBillingAt sql.NullString
+}
+
+func findSubscriptionState(ctx context.Context, tx *Tx, id string) (*subscriptionState, error) {
+	var state subscriptionState
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			client_id,
+			plan_id,
+			status,
+			auto_renew,
+			next_billing_at
+		FROM subscriptions
+		WHERE subscription_id = ?
+		`,
+		id,
+	).Scan(
+		&state.clientID,
+		&state.planID,
+		&state.status,
+		&state.autoRenew,
+		&state.nextBillingAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Subscription not found.")
+	} else if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// parseTime parses a DATETIME column scanned into a string. SQLite returns
+// times in RFC 3339 format and MySQL in its own.
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, time.UTC)
+}
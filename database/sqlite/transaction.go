@@ -0,0 +1,177 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+type TransactionService struct {
+	db *DB
+}
+
+func NewTransactionService(db *DB) *TransactionService {
+	return &TransactionService{db}
+}
+
+func (s *TransactionService) CreateTransaction(ctx context.Context, transaction *model.Transaction) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createTransaction(ctx, tx, transaction); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createTransaction(ctx context.Context, tx *Tx, transaction *model.Transaction) error {
+	if transaction.TransactionID == "" {
+		transaction.TransactionID = uuid.NewString()
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions (
+			transaction_id,
+			user_id,
+			booking_id,
+			subscription_id,
+			direction,
+			method,
+			receipt,
+			account_reference,
+			amount,
+			currency,
+			status,
+			description,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		`,
+		transaction.TransactionID,
+		transaction.UserID,
+		transaction.BookingID,
+		transaction.SubscriptionID,
+		transaction.Direction,
+		transaction.Method,
+		transaction.ProviderReference,
+		transaction.AccountReference,
+		transaction.Amount,
+		transaction.Currency,
+		transaction.Status,
+		transaction.Description,
+		tx.now,
+		tx.now,
+	)
+	return err
+}
+
+func (s *TransactionService) FindTransactionByID(ctx context.Context, id string, userID string) (*app.Transaction, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	transactions, err := listTransactions(ctx, tx, "transactions.transaction_id = ? AND transactions.user_id = ?", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return transactions[0], tx.Commit()
+}
+
+func (s *TransactionService) ListTransactions(ctx context.Context, filter model.TransactionFilter) ([]*app.Transaction, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Each part of the WHERE clause is AND-ed together. Values are appended
+	// to an arg list to avoid SQL injection.
+	where, args := []string{"transactions.user_id = ?"}, []interface{}{filter.UserID}
+	if v := filter.Status; v != "" {
+		where, args = append(where, "transactions.status = ?"), append(args, v)
+	}
+	if v := filter.Direction; v != "" {
+		where, args = append(where, "transactions.direction = ?"), append(args, v)
+	}
+	if v := filter.From; v != nil {
+		where, args = append(where, "transactions.created_at >= ?"), append(args, v.UTC())
+	}
+	if v := filter.To; v != nil {
+		where, args = append(where, "transactions.created_at <= ?"), append(args, v.UTC())
+	}
+
+	transactions, err := listTransactions(ctx, tx, strings.Join(where, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, tx.Commit()
+}
+
+func listTransactions(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.Transaction, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			transactions.transaction_id,
+			transactions.user_id,
+			transactions.booking_id,
+			transactions.subscription_id,
+			transactions.direction,
+			transactions.method,
+			transactions.receipt,
+			transactions.account_reference,
+			transactions.amount,
+			transactions.currency,
+			transactions.status,
+			transactions.description,
+			transactions.created_at,
+			transactions.updated_at
+		FROM transactions
+		WHERE `+where+`
+		ORDER BY transactions.created_at DESC
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []*app.Transaction{}
+	for rows.Next() {
+		var transaction app.Transaction
+		if err := rows.Scan(
+			&transaction.TransactionID,
+			&transaction.UserID,
+			&transaction.BookingID,
+			&transaction.SubscriptionID,
+			&transaction.Direction,
+			&transaction.Method,
+			&transaction.ProviderReference,
+			&transaction.AccountReference,
+			&transaction.Amount,
+			&transaction.Currency,
+			&transaction.Status,
+			&transaction.Description,
+			&transaction.CreatedAt,
+			&transaction.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, &transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"strings"
 
@@ -33,7 +34,12 @@ func (s *UserService) CreateUser(ctx context.Context, user *model.User) error {
 }
 
 func createUser(ctx context.Context, tx *Tx, user *model.User) error {
-	_, err := tx.ExecContext(ctx, `
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO users (
 			user_id,
 			username,
@@ -45,7 +51,7 @@ func createUser(ctx context.Context, tx *Tx, user *model.User) error {
 		user.UserID,
 		user.Username,
 		user.Phone,
-		user.Password,
+		hash,
 		user.IsProvider,
 	)
 	if err != nil {
@@ -85,12 +91,19 @@ func (s *UserService) ResetUserPassword(ctx context.Context, password string, us
 }
 
 func resetUserPassword(ctx context.Context, tx *Tx, newPass string, userID string) error {
+	hash, err := hashPassword(newPass)
+	if err != nil {
+		return err
+	}
+
 	result, err := tx.ExecContext(ctx, `
 		UPDATE users SET
-			password = ?
+			password = ?,
+			failed_logins = 0,
+			locked_until = NULL
 		WHERE user_id = ?
 		`,
-		newPass,
+		hash,
 		userID,
 	)
 	if err != nil {
@@ -120,24 +133,41 @@ func (s *UserService) ChangeUserPassword(ctx context.Context, user *model.PwdCha
 }
 
 func changePassword(ctx context.Context, tx *Tx, user *model.PwdChange) error {
-	result, err := tx.ExecContext(ctx, `
-		UPDATE users SET
-			password = ?
-		WHERE user_id = ? AND password = ?
+	var stored string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT password
+		FROM users
+		WHERE user_id = ?
 		`,
-		user.NewPassword,
 		user.UserID,
-		user.OldPassword,
-	)
-	if err != nil {
+	).Scan(&stored); err != nil {
 		return err
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	if ok, _ := checkPassword(stored, user.OldPassword); !ok {
 		return sql.ErrNoRows
 	}
 
+	return updatePassword(ctx, tx, user.UserID, user.NewPassword)
+}
+
+// updatePassword hashes and stores a new password for the user.
+func updatePassword(ctx context.Context, tx *Tx, userID string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET
+			password = ?
+		WHERE user_id = ?
+		`,
+		hash,
+		userID,
+	); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -619,53 +649,157 @@ func getUserByCriteria(ctx context.Context, tx *Tx, haystack string, needle stri
 	return user, nil
 }
 
-func (s *UserService) ValidateUser(ctx context.Context, phone string, password string, isProvider bool) error {
+// ValidateUser returns the user with the given phone number and password.
+// Returns sql.ErrNoRows if there is no such user, or when isProvider is set
+// and the user is not a provider. After maxFailedLogins wrong passwords in a
+// row the user is locked out for lockoutDuration.
+func (s *UserService) ValidateUser(ctx context.Context, phone string, password string, isProvider bool) (*app.User, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	if isProvider {
-		if err := validateUserAsProvider(ctx, tx, phone, password); err != nil {
-			return err
-		}
-	} else {
-		if err := validateUser(ctx, tx, phone, password); err != nil {
-			return err
+	userID, err := validateUser(ctx, tx, phone, password, isProvider)
+	if err == errWrongPassword {
+		// Keep the failed attempt so repeated guesses lead to a lockout.
+		if err := tx.Commit(); err != nil {
+			return nil, err
 		}
+		return nil, sql.ErrNoRows
+	} else if err != nil {
+		return nil, err
 	}
-	return tx.Commit()
+
+	user, err := getUserByCriteria(ctx, tx, "user_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
 }
 
-func validateUser(ctx context.Context, tx *Tx, phone string, password string) error {
-	err := tx.QueryRowContext(ctx, `
+// errWrongPassword is returned by validateUser after recording a failed
+// attempt, which must be committed.
+var errWrongPassword = errors.New("wrong password")
+
+func validateUser(ctx context.Context, tx *Tx, phone string, password string, isProvider bool) (userID string, err error) {
+	var stored string
+	var failedLogins int
+	var locked bool
+	err = tx.QueryRowContext(ctx, `
 		SELECT
-			username
+			user_id,
+			password,
+			failed_logins,
+			locked_until IS NOT NULL AND locked_until > ?
 		FROM users
-		WHERE phone = ? AND password = ? 
-	`, phone, password).Scan(
-		&phone,
+		WHERE phone = ? AND (is_provider = true OR ? = false)
+	`, tx.now, phone, isProvider).Scan(
+		&userID,
+		&stored,
+		&failedLogins,
+		&locked,
 	)
 	if err != nil {
+		return "", err
+	}
+
+	if locked {
+		return "", app.Errorf(app.RATELIMIT_ERR, "Too many failed attempts. Try again later.")
+	}
+
+	ok, rehash := checkPassword(stored, password)
+	if !ok {
+		return "", recordFailedLogin(ctx, tx, userID, failedLogins+1)
+	}
+
+	if failedLogins > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET
+				failed_logins = 0,
+				locked_until = NULL
+			WHERE user_id = ?
+		`, userID); err != nil {
+			return "", err
+		}
+	}
+
+	// Upgrade plaintext and outdated hashes while we know the password.
+	if rehash {
+		if err := updatePassword(ctx, tx, userID, password); err != nil {
+			return "", err
+		}
+	}
+	return userID, nil
+}
+
+// recordFailedLogin stores a failed password validation, locking the user out
+// once they reach maxFailedLogins. Returns errWrongPassword on success.
+func recordFailedLogin(ctx context.Context, tx *Tx, userID string, failedLogins int) error {
+	var lockedUntil interface{}
+	if failedLogins >= maxFailedLogins {
+		failedLogins, lockedUntil = 0, tx.now.Add(lockoutDuration)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET
+			failed_logins = ?,
+			locked_until = ?
+		WHERE user_id = ?
+	`, failedLogins, lockedUntil, userID); err != nil {
 		return err
 	}
-	return nil
+	return errWrongPassword
 }
 
-func validateUserAsProvider(ctx context.Context, tx *Tx, phone string, password string) error {
-	err := tx.QueryRowContext(ctx, `
-		SELECT
-			username
+// RehashPasswords hashes every password still stored in plaintext and returns
+// the number of users updated.
+func (s *UserService) RehashPasswords(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := rehashPasswords(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func rehashPasswords(ctx context.Context, tx *Tx) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT user_id, password
 		FROM users
-		WHERE phone = ? AND password = ? AND is_provider = true
-	`, phone, password).Scan(
-		&phone,
-	)
+	`)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	defer rows.Close()
+
+	// Collect the users first; MySQL cannot update while rows are open.
+	plaintext := make(map[string]string)
+	for rows.Next() {
+		var userID, stored string
+		if err := rows.Scan(&userID, &stored); err != nil {
+			return 0, err
+		}
+		if !isPasswordHash(stored) {
+			plaintext[userID] = stored
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for userID, password := range plaintext {
+		if err := updatePassword(ctx, tx, userID, password); err != nil {
+			return 0, err
+		}
+	}
+	return len(plaintext), nil
 }
 
 func filterProviders(ctx context.Context, tx *Tx, filter model.ProviderFilter) (_ []*app.ProviderBrief, err error) {
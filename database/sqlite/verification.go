@@ -0,0 +1,260 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Verification code settings. A code expires after codeTTL or after
+// maxCodeAttempts wrong guesses, and a new code can only be requested once
+// per codeResendInterval.
+const (
+	codeDigits         = 6
+	codeTTL            = 10 * time.Minute
+	maxCodeAttempts    = 5
+	codeResendInterval = time.Minute
+)
+
+// VerificationService issues and checks one-time codes sent by SMS.
+type VerificationService struct {
+	db  *DB
+	sms app.SMSSender
+}
+
+func NewVerificationService(db *DB, sms app.SMSSender) *VerificationService {
+	return &VerificationService{db: db, sms: sms}
+}
+
+// SendCode issues a new code for the phone number and purpose and sends it by
+// SMS. Numbers without an account get a code too, which is never sent, so they
+// are limited in the same way and the endpoint cannot be used to find
+// registered numbers.
+func (s *VerificationService) SendCode(ctx context.Context, phone string, purpose string) error {
+	if purpose != app.VerifyPhonePurpose && purpose != app.ResetPasswordPurpose {
+		return app.Errorf(app.INVALID_ERR, "Unknown verification purpose %q.", purpose)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	registered := true
+	if _, err := getUserByCriteria(ctx, tx, "phone", phone); err == sql.ErrNoRows {
+		registered = false
+	} else if err != nil {
+		return err
+	}
+
+	code, err := createVerificationCode(ctx, tx, phone, purpose)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if !registered {
+		log.Printf("verification code requested for unknown phone %s", phone)
+		return nil
+	}
+
+	return s.sms.SendSMS(ctx, phone, fmt.Sprintf("Your HudumaApp code is %s. It expires in %d minutes.", code, int(codeTTL.Minutes())))
+}
+
+// VerifyPhone marks the user's phone number as verified.
+func (s *VerificationService) VerifyPhone(ctx context.Context, phone string, code string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkVerificationCode(ctx, tx, phone, app.VerifyPhonePurpose, code); err == errWrongCode {
+		return commitWrongCode(tx)
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET
+			verified = TRUE
+		WHERE phone = ?
+		`,
+		phone,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetPassword sets a new password for the user after checking the code sent
+// to their phone. It also lifts any lockout.
+func (s *VerificationService) ResetPassword(ctx context.Context, reset *model.PasswordReset) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkVerificationCode(ctx, tx, reset.Phone, app.ResetPasswordPurpose, reset.Code); err == errWrongCode {
+		return commitWrongCode(tx)
+	} else if err != nil {
+		return err
+	}
+
+	user, err := getUserByCriteria(ctx, tx, "phone", reset.Phone)
+	if err != nil {
+		return err
+	}
+	if err := resetUserPassword(ctx, tx, reset.NewPassword, user.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// errWrongCode is returned by checkVerificationCode after recording a wrong
+// guess, which must be committed.
+var errWrongCode = errors.New("wrong verification code")
+
+// commitWrongCode keeps the attempt recorded by checkVerificationCode and
+// returns the error reported to the user.
+func commitWrongCode(tx *Tx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return app.Errorf(app.INVALID_ERR, "Code is invalid or has expired.")
+}
+
+// createVerificationCode stores the hash of a new random code and returns the
+// code. Earlier codes for the same phone and purpose stop being valid.
+func createVerificationCode(ctx context.Context, tx *Tx, phone string, purpose string) (string, error) {
+	var recent int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM verification_codes
+		WHERE phone = ? AND purpose = ? AND created_at > ?
+		`,
+		phone,
+		purpose,
+		tx.now.Add(-codeResendInterval),
+	).Scan(&recent); err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", app.Errorf(app.RATELIMIT_ERR, "A code was sent recently. Try again in a minute.")
+	}
+
+	code, err := randomCode(codeDigits)
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE verification_codes SET
+			consumed_at = ?
+		WHERE phone = ? AND purpose = ? AND consumed_at IS NULL
+		`,
+		tx.now,
+		phone,
+		purpose,
+	); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO verification_codes (
+			phone,
+			purpose,
+			code_hash,
+			expires_at,
+			created_at
+		) VALUES (?,?,?,?,?)
+		`,
+		phone,
+		purpose,
+		string(hash),
+		tx.now.Add(codeTTL),
+		tx.now,
+	); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// checkVerificationCode consumes the current code for the phone and purpose
+// if it matches. A wrong guess is counted and errWrongCode returned.
+func checkVerificationCode(ctx context.Context, tx *Tx, phone string, purpose string, code string) error {
+	var id, attempts int
+	var hash string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, code_hash, attempts
+		FROM verification_codes
+		WHERE phone = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?
+		ORDER BY id DESC
+		LIMIT 1
+		`,
+		phone,
+		purpose,
+		tx.now,
+	).Scan(&id, &hash, &attempts)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.INVALID_ERR, "Code is invalid or has expired.")
+	} else if err != nil {
+		return err
+	}
+
+	if attempts >= maxCodeAttempts {
+		return app.Errorf(app.RATELIMIT_ERR, "Too many wrong codes. Request a new code.")
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE verification_codes SET
+				attempts = attempts + 1
+			WHERE id = ?
+			`,
+			id,
+		); err != nil {
+			return err
+		}
+		return errWrongCode
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE verification_codes SET
+			consumed_at = ?
+		WHERE id = ?
+		`,
+		tx.now,
+		id,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+// randomCode returns a random numeric code with the given number of digits.
+func randomCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
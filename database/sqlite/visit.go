@@ -0,0 +1,394 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (s *BookingService) CheckIn(ctx context.Context, c *model.BookingCheckpoint) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := findVisitBooking(ctx, tx, c.BookingID)
+	if err != nil {
+		return err
+	}
+	if c.ActorID != booking.providerUserID.String {
+		return app.Errorf(app.FORBIDDEN_ERR, "Only the provider can check in to this booking.")
+	}
+	if booking.status != app.BookingAccepted {
+		return app.Errorf(app.CONFLICT_ERR, "A booking that is %s cannot be checked in to.", booking.status)
+	}
+	latitude, longitude, distance, err := s.checkOnSite(booking, c, "check in")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO booking_visits (
+			booking_id,
+			provider_user_id,
+			check_in_at,
+			check_in_latitude,
+			check_in_longitude,
+			check_in_distance
+		) VALUES (?,?,?,?,?,?)
+		`,
+		c.BookingID,
+		c.ActorID,
+		tx.now,
+		latitude,
+		longitude,
+		distance,
+	); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("Checked in %.2f km from the booking location.", distance)
+	if err := transitionBooking(ctx, tx, &model.BookingTransition{
+		BookingID: c.BookingID,
+		Status:    app.BookingInProgress,
+		ActorID:   c.ActorID,
+		Reason:    &reason,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *BookingService) CheckOut(ctx context.Context, c *model.BookingCheckpoint) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := findVisitBooking(ctx, tx, c.BookingID)
+	if err != nil {
+		return err
+	}
+	if c.ActorID != booking.providerUserID.String {
+		return app.Errorf(app.FORBIDDEN_ERR, "Only the provider can check out of this booking.")
+	}
+	if booking.status != app.BookingInProgress {
+		return app.Errorf(app.CONFLICT_ERR, "A booking that is %s cannot be checked out of.", booking.status)
+	}
+	visit, err := findVisitState(ctx, tx, c.BookingID)
+	if err == sql.ErrNoRows {
+		return app.Errorf(app.CONFLICT_ERR, "Check in before checking out.")
+	} else if err != nil {
+		return err
+	}
+	if visit.checkOutAt.Valid {
+		return app.Errorf(app.CONFLICT_ERR, "You already checked out of this booking.")
+	}
+	latitude, longitude, distance, err := s.checkOnSite(booking, c, "check out")
+	if err != nil {
+		return err
+	}
+
+	code, err := randomCode(codeDigits)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	duration := visitDuration(visit.checkInAt, tx.now)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE booking_visits SET
+			check_out_at = ?,
+			check_out_latitude = ?,
+			check_out_longitude = ?,
+			check_out_distance = ?,
+			duration = ?,
+			completion_code_hash = ?
+		WHERE visit_id = ?
+		`,
+		tx.now,
+		latitude,
+		longitude,
+		distance,
+		duration,
+		string(hash),
+		visit.id,
+	); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("Checked out after %d minutes, %.2f km from the booking location.", duration, distance)
+	if err := createBookingEvent(ctx, tx, c.BookingID, &booking.status, booking.status, c.ActorID, &reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The check out is saved, and the client can still confirm in the app,
+	// so a failed SMS is only logged.
+	if booking.clientPhone.Valid {
+		msg := fmt.Sprintf("Your provider has finished the job. Give them the code %s to confirm it is done, or confirm it in the HudumaApp.", code)
+		if err := s.sms.SendSMS(ctx, booking.clientPhone.String, msg); err != nil {
+			log.Printf("completion code sms for booking %s: %v", c.BookingID, err)
+		}
+	}
+	return nil
+}
+
+// CompleteBooking completes a booking. Its provider only completes it once
+// checked out, with the completion code the client gives them. The client
+// confirms by themselves, and administrators complete bookings without either.
+func (s *BookingService) CompleteBooking(ctx context.Context, c *model.BookingCompletion) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := findVisitBooking(ctx, tx, c.BookingID)
+	if err != nil {
+		return err
+	}
+	visit, err := findVisitState(ctx, tx, c.BookingID)
+	if err == sql.ErrNoRows {
+		visit = nil
+	} else if err != nil {
+		return err
+	}
+	// Only the visit to a booking in progress is still open.
+	if booking.status != app.BookingInProgress {
+		visit = nil
+	}
+
+	var reason string
+	switch c.ActorID {
+	case booking.providerUserID.String:
+		if visit == nil || !visit.checkOutAt.Valid {
+			return app.Errorf(app.CONFLICT_ERR, "Check in and out of the booking before completing the job.")
+		}
+		if visit.codeAttempts >= maxCodeAttempts {
+			return app.Errorf(app.RATELIMIT_ERR, "Too many wrong codes. Ask the client to confirm the job in their app.")
+		}
+		if c.Code == nil || bcrypt.CompareHashAndPassword([]byte(visit.completionCodeHash.String), []byte(*c.Code)) != nil {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE booking_visits SET code_attempts = code_attempts + 1 WHERE visit_id = ?
+				`,
+				visit.id,
+			); err != nil {
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			return app.Errorf(app.INVALID_ERR, "code: ask the client for the completion code sent to them.")
+		}
+		reason = "Confirmed with the client's completion code."
+	case booking.clientID:
+		reason = "Confirmed by the client."
+	default:
+		reason = "Completed by an administrator."
+	}
+
+	if visit != nil {
+		if err := confirmVisit(ctx, tx, visit, c.ActorID); err != nil {
+			return err
+		}
+	}
+	if err := transitionBooking(ctx, tx, &model.BookingTransition{
+		BookingID: c.BookingID,
+		Status:    app.BookingCompleted,
+		ActorID:   c.ActorID,
+		Reason:    &reason,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *BookingService) FindBookingVisit(ctx context.Context, id uuid.UUID) (*app.BookingVisit, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var visit app.BookingVisit
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			booking_visits.visit_id,
+			booking_visits.booking_id,
+			booking_visits.check_in_at,
+			booking_visits.check_in_latitude,
+			booking_visits.check_in_longitude,
+			booking_visits.check_in_distance,
+			booking_visits.check_out_at,
+			booking_visits.check_out_latitude,
+			booking_visits.check_out_longitude,
+			booking_visits.check_out_distance,
+			booking_visits.duration,
+			booking_visits.confirmed_by,
+			booking_visits.confirmed_at
+		FROM booking_visits
+		WHERE booking_visits.booking_id = ?
+		ORDER BY booking_visits.visit_id DESC
+		LIMIT 1
+		`,
+		id,
+	).Scan(
+		&visit.ID,
+		&visit.BookingID,
+		&visit.CheckInAt,
+		&visit.CheckInLatitude,
+		&visit.CheckInLongitude,
+		&visit.CheckInDistance,
+		&visit.CheckOutAt,
+		&visit.CheckOutLatitude,
+		&visit.CheckOutLongitude,
+		&visit.CheckOutDistance,
+		&visit.Duration,
+		&visit.ConfirmedBy,
+		&visit.ConfirmedAt,
+	); err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "The provider has not checked in yet.")
+	} else if err != nil {
+		return nil, err
+	}
+	return &visit, tx.Commit()
+}
+
+// visitBooking is what checking in and out of a booking needs to know about
+// it.
+type visitBooking struct {
+	status         string
+	clientID       string
+	clientPhone    sql.NullString
+	providerUserID sql.NullString
+	latitude       sql.NullFloat64
+	longitude      sql.NullFloat64
+}
+
+func findVisitBooking(ctx context.Context, tx *Tx, id uuid.UUID) (*visitBooking, error) {
+	var b visitBooking
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			bookings.status,
+			bookings.client_id,
+			clients.phone,
+			providers.user_id,
+			locations.latitude,
+			locations.longitude
+		FROM bookings
+		LEFT JOIN users clients ON clients.user_id = bookings.client_id
+		LEFT JOIN providers ON providers.provider_id = bookings.provider_id
+		LEFT JOIN locations ON locations.location_id = bookings.location_id
+		WHERE bookings.booking_id = ?
+		`,
+		id,
+	).Scan(&b.status, &b.clientID, &b.clientPhone, &b.providerUserID, &b.latitude, &b.longitude); err == sql.ErrNoRows {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Booking not found.")
+	} else if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// checkOnSite returns the position of a checkpoint and its distance from the
+// location of the booking, or an error if it is further than the check-in
+// radius.
+func (s *BookingService) checkOnSite(b *visitBooking, c *model.BookingCheckpoint, action string) (latitude, longitude, distance float64, err error) {
+	if !b.latitude.Valid || !b.longitude.Valid {
+		return 0, 0, 0, app.Errorf(app.CONFLICT_ERR, "The booking has no location to %s at.", action)
+	}
+	if latitude, err = strconv.ParseFloat(c.Latitude, 64); err != nil {
+		return 0, 0, 0, app.Errorf(app.INVALID_ERR, "latitude: must be a number.")
+	}
+	if longitude, err = strconv.ParseFloat(c.Longitude, 64); err != nil {
+		return 0, 0, 0, app.Errorf(app.INVALID_ERR, "longitude: must be a number.")
+	}
+
+	distance = calculateDistance(b.latitude.Float64, b.longitude.Float64, latitude, longitude)
+	if distance > s.CheckInRadius {
+		return 0, 0, 0, app.Errorf(app.INVALID_ERR, "You are %.1f km from the booking location, %s on site.", distance, action)
+	}
+	return latitude, longitude, distance, nil
+}
+
+// visitState is what completing a booking needs to know about its visit.
+type visitState struct {
+	id                 int
+	checkInAt          time.Time
+	checkOutAt         sql.NullString
+	completionCodeHash sql.NullString
+	codeAttempts       int
+}
+
+// findVisitState returns the current visit to a booking, the latest one.
+func findVisitState(ctx context.Context, tx *Tx, bookingID uuid.UUID) (*visitState, error) {
+	var v visitState
+	var checkInAt string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT visit_id, check_in_at, check_out_at, completion_code_hash, code_attempts
+		FROM booking_visits
+		WHERE booking_id = ?
+		ORDER BY visit_id DESC
+		LIMIT 1
+		`,
+		bookingID,
+	).Scan(&v.id, &checkInAt, &v.checkOutAt, &v.completionCodeHash, &v.codeAttempts); err != nil {
+		return nil, err
+	}
+	var err error
+	if v.checkInAt, err = parseTime(checkInAt); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// confirmVisit records who confirmed the job of a visit is done. A visit the
+// provider did not check out of ends when the client confirms it.
+func confirmVisit(ctx context.Context, tx *Tx, v *visitState, actorID string) error {
+	if !v.checkOutAt.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE booking_visits SET
+				check_out_at = ?,
+				duration = ?
+			WHERE visit_id = ?
+			`,
+			tx.now,
+			visitDuration(v.checkInAt, tx.now),
+			v.id,
+		); err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE booking_visits SET
+			confirmed_by = ?,
+			confirmed_at = ?
+		WHERE visit_id = ?
+		`,
+		actorID,
+		tx.now,
+		v.id,
+	)
+	return err
+}
+
+// visitDuration returns the minutes from checking in to checking out.
+func visitDuration(checkIn, checkOut time.Time) int {
+	return int(math.Round(checkOut.Sub(checkIn).Minutes()))
+}
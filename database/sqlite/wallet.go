@@ -0,0 +1,669 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+// WalletService derives the earnings of providers from the transaction ledger
+// and pays out withdrawals and refunds through the payouts table.
+type WalletService struct {
+	db            *DB
+	minimumPayout int
+}
+
+func NewWalletService(db *DB, minimumPayout int) *WalletService {
+	return &WalletService{db: db, minimumPayout: minimumPayout}
+}
+
+func (s *WalletService) FindWallet(ctx context.Context, userID string) (*app.Wallet, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	wallet, err := findWallet(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	wallet.MinimumPayout = s.minimumPayout
+	return wallet, tx.Commit()
+}
+
+// findWallet works out the balances of a provider. Earnings credited to them
+// count towards the available balance, less withdrawals that have not
+// failed. Funds held in escrow for their jobs, less commission, are pending.
+func findWallet(ctx context.Context, tx *Tx, userID string) (*app.Wallet, error) {
+	var providers int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM providers WHERE user_id = ?
+		`,
+		userID,
+	).Scan(&providers); err != nil {
+		return nil, err
+	}
+	if providers == 0 {
+		return nil, app.Errorf(app.NOTFOUND_ERR, "Only providers have earnings.")
+	}
+
+	wallet := app.Wallet{Currency: "Ksh"}
+	var withdrawing int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN direction = ? AND method = ? AND status = ? THEN amount ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN direction = ? AND method = ? AND status = ? THEN amount ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN direction = ? AND method = ? AND status = ? THEN amount ELSE 0 END), 0)
+		FROM transactions
+		WHERE user_id = ?
+		`,
+		app.TransactionCredit, app.EarningsMethod, app.TransactionCompleted,
+		app.TransactionDebit, app.MpesaB2CMethod, app.TransactionPending,
+		app.TransactionDebit, app.MpesaB2CMethod, app.TransactionCompleted,
+		userID,
+	).Scan(&wallet.TotalEarned, &withdrawing, &wallet.TotalPaidOut); err != nil {
+		return nil, err
+	}
+	wallet.Available = wallet.TotalEarned - withdrawing - wallet.TotalPaidOut
+
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount - commission), 0)
+		FROM escrows
+		WHERE provider_user_id = ? AND status = ?
+		`,
+		userID,
+		app.EscrowHeld,
+	).Scan(&wallet.Pending); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// RequestPayout withdraws earnings of a provider to the phone of their account
+// or one of their verified M-Pesa payment methods. The ledger entry debiting
+// the withdrawal is written with the payout, so the amount cannot be
+// withdrawn twice.
+func (s *WalletService) RequestPayout(ctx context.Context, request *model.PayoutRequest) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if ok, err := isPayoutPhone(ctx, tx, request.UserID, request.Phone); err != nil {
+		return err
+	} else if !ok {
+		return app.Errorf(app.FORBIDDEN_ERR, "Withdrawals go to the phone of your account or a verified M-Pesa payment method. Add and verify %s first.", request.Phone)
+	}
+
+	wallet, err := findWallet(ctx, tx, request.UserID)
+	if err != nil {
+		return err
+	}
+	amount := request.Amount
+	if amount == 0 {
+		amount = wallet.Available
+	}
+	if amount > wallet.Available {
+		return app.Errorf(app.CONFLICT_ERR, "You can withdraw at most %s %d.", wallet.Currency, wallet.Available)
+	}
+	if amount < s.minimumPayout {
+		return app.Errorf(app.CONFLICT_ERR, "You can withdraw from %s %d.", wallet.Currency, s.minimumPayout)
+	}
+
+	request.ID, err = createPayout(ctx, tx, &payoutRequest{
+		userID:      request.UserID,
+		phone:       request.Phone,
+		amount:      amount,
+		currency:    wallet.Currency,
+		direction:   app.TransactionDebit,
+		description: "Withdrawal to " + request.Phone,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isPayoutPhone reports whether earnings of the user may be withdrawn to the
+// phone: the phone of their account or of a verified M-Pesa payment method.
+func isPayoutPhone(ctx context.Context, tx *Tx, userID string, phone string) (bool, error) {
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE user_id = ? AND phone = ?) +
+			(SELECT COUNT(*) FROM payment_methods WHERE user_id = ? AND type = ? AND phone = ? AND verified_at IS NOT NULL)
+		`,
+		userID, phone,
+		userID, app.MobilePaymentMethod, phone,
+	).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// payoutRequest is a payout to create with its ledger entry.
+type payoutRequest struct {
+	userID    string
+	escrowID  string
+	bookingID string
+	phone     string
+	amount    int
+	currency  string
+	// direction is credit for refunds and debit for withdrawals.
+	direction   string
+	description string
+}
+
+// createPayout stores a requested payout and a pending ledger entry
+// for it, referencing the payout, and returns the ID of the payout.
+func createPayout(ctx context.Context, tx *Tx, p *payoutRequest) (string, error) {
+	id := uuid.NewString()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO payouts (
+			payout_id,
+			user_id,
+			escrow_id,
+			phone,
+			amount,
+			currency,
+			status,
+			description,
+			created_at,
+			updated_at
+		) VALUES (?,?,?,?,?,?,?,?,?,?)
+		`,
+		id,
+		p.userID,
+		nullString(p.escrowID),
+		p.phone,
+		p.amount,
+		p.currency,
+		app.PayoutRequested,
+		p.description,
+		tx.now,
+		tx.now,
+	); err != nil {
+		return "", err
+	}
+
+	transaction := &model.Transaction{
+		UserID:           p.userID,
+		Direction:        p.direction,
+		Method:           app.MpesaB2CMethod,
+		AccountReference: id,
+		Amount:           p.amount,
+		Currency:         p.currency,
+		Status:           app.TransactionPending,
+		Description:      p.description,
+	}
+	if p.bookingID != "" {
+		transaction.BookingID = &p.bookingID
+	}
+	if err := createTransaction(ctx, tx, transaction); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *WalletService) FindPayoutByID(ctx context.Context, id string, userID string) (*app.Payout, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payouts, err := listPayouts(ctx, tx, "payout_id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(payouts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return payouts[0], tx.Commit()
+}
+
+func (s *WalletService) ListPayouts(ctx context.Context, userID string) ([]*app.Payout, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payouts, err := listPayouts(ctx, tx, "user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	return payouts, tx.Commit()
+}
+
+func listPayouts(ctx context.Context, tx *Tx, where string, args ...interface{}) ([]*app.Payout, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			payout_id,
+			user_id,
+			escrow_id,
+			phone,
+			amount,
+			currency,
+			status,
+			description,
+			receipt,
+			paid_at,
+			created_at
+		FROM payouts
+		WHERE `+where+`
+		ORDER BY created_at DESC
+		`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := []*app.Payout{}
+	for rows.Next() {
+		var payout app.Payout
+		if err := rows.Scan(
+			&payout.PayoutID,
+			&payout.UserID,
+			&payout.EscrowID,
+			&payout.Phone,
+			&payout.Amount,
+			&payout.Currency,
+			&payout.Status,
+			&payout.Description,
+			&payout.Receipt,
+			&payout.PaidAt,
+			&payout.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, &payout)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// ClaimPayouts leases requested payouts. Another scheduler may claim the same
+// payouts in between, so each lease is only taken if it is still free.
+func (s *WalletService) ClaimPayouts(ctx context.Context, owner string, lease time.Duration, limit int) ([]*app.Payout, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT payout_id
+		FROM payouts
+		WHERE status = ? AND (locked_until IS NULL OR locked_until <= ?)
+		ORDER BY created_at
+		LIMIT ?
+		`,
+		app.PayoutRequested,
+		tx.now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	payouts := []*app.Payout{}
+	for _, id := range ids {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE payouts SET
+				locked_by = ?,
+				locked_until = ?
+			WHERE payout_id = ?
+			AND status = ?
+			AND (locked_until IS NULL OR locked_until <= ?)
+			`,
+			owner,
+			tx.now.Add(lease),
+			id,
+			app.PayoutRequested,
+			tx.now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		claimed, err := listPayouts(ctx, tx, "payout_id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, claimed...)
+	}
+	return payouts, tx.Commit()
+}
+
+// RecordPayout marks a payout as sent and stores its reference on the payout
+// and its ledger entry.
+func (s *WalletService) RecordPayout(ctx context.Context, payoutID string, reference string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE payouts SET
+			status = ?,
+			reference = ?,
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = ?
+		WHERE payout_id = ?
+		`,
+		app.PayoutSent,
+		reference,
+		tx.now,
+		payoutID,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE transactions SET
+			reference = ?,
+			updated_at = ?
+		WHERE account_reference = ? AND method = ?
+		`,
+		reference,
+		tx.now,
+		payoutID,
+		app.MpesaB2CMethod,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CompletePayout records the outcome of a sent payout. A paid refund settles
+// its escrow. A failed refund is requested again, while a failed withdrawal
+// fails and its amount becomes available again.
+func (s *WalletService) CompletePayout(ctx context.Context, result *model.PayoutResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, status string
+	var escrowID sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		SELECT payout_id, status, escrow_id
+		FROM payouts
+		WHERE reference = ?
+		`,
+		result.Reference,
+	).Scan(&id, &status, &escrowID); err == sql.ErrNoRows {
+		return app.Errorf(app.NOTFOUND_ERR, "Unknown payout %s.", result.Reference)
+	} else if err != nil {
+		return err
+	}
+	if status != app.PayoutSent {
+		log.Printf("duplicate result for payout %s", result.Reference)
+		return nil
+	}
+
+	if result.ResultCode == 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE payouts SET
+				status = ?,
+				receipt = ?,
+				result_desc = ?,
+				paid_at = ?,
+				updated_at = ?
+			WHERE payout_id = ?
+			`,
+			app.PayoutPaid,
+			nullString(result.Receipt),
+			result.ResultDesc,
+			tx.now,
+			tx.now,
+			id,
+		); err != nil {
+			return err
+		}
+		if err := completePayoutTransaction(ctx, tx, result, app.TransactionCompleted); err != nil {
+			return err
+		}
+		if escrowID.Valid {
+			if err := setEscrowStatus(ctx, tx, escrowID.String, app.EscrowRefunded); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	log.Printf("payout %s failed: %s", id, result.ResultDesc)
+	if escrowID.Valid {
+		// Refunds are owed to the client, so they are sent again.
+		_, err = tx.ExecContext(ctx, `
+			UPDATE payouts SET
+				status = ?,
+				reference = NULL,
+				result_desc = ?,
+				updated_at = ?
+			WHERE payout_id = ?
+			`,
+			app.PayoutRequested,
+			result.ResultDesc,
+			tx.now,
+			id,
+		)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE transactions SET
+					reference = NULL,
+					result_code = ?,
+					result_desc = ?,
+					updated_at = ?
+				WHERE reference = ?
+				`,
+				fmt.Sprint(result.ResultCode),
+				result.ResultDesc,
+				tx.now,
+				result.Reference,
+			)
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE payouts SET
+				status = ?,
+				result_desc = ?,
+				updated_at = ?
+			WHERE payout_id = ?
+			`,
+			app.PayoutFailed,
+			result.ResultDesc,
+			tx.now,
+			id,
+		)
+		if err == nil {
+			err = completePayoutTransaction(ctx, tx, result, app.TransactionFailed)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func completePayoutTransaction(ctx context.Context, tx *Tx, result *model.PayoutResult, status string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE transactions SET
+			status = ?,
+			receipt = ?,
+			result_code = ?,
+			result_desc = ?,
+			updated_at = ?
+		WHERE reference = ?
+		`,
+		status,
+		nullString(result.Receipt),
+		fmt.Sprint(result.ResultCode),
+		result.ResultDesc,
+		tx.now,
+		result.Reference,
+	)
+	return err
+}
+
+func (s *WalletService) ListCommissionRates(ctx context.Context) ([]*app.CommissionRate, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT category_id, industry_id, percent, updated_at
+		FROM commission_rates
+		ORDER BY category_id IS NOT NULL, industry_id IS NOT NULL, category_id, industry_id
+		`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*app.CommissionRate{}
+	for rows.Next() {
+		var rate app.CommissionRate
+		if err := rows.Scan(
+			&rate.CategoryID,
+			&rate.IndustryID,
+			&rate.Percent,
+			&rate.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rates = append(rates, &rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, tx.Commit()
+}
+
+// SetCommissionRate creates or updates the commission rate of a category, an
+// industry or the default rate. It applies to escrows opened from then on.
+func (s *WalletService) SetCommissionRate(ctx context.Context, rate *model.CommissionRate) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, args := "category_id IS NULL AND industry_id IS NULL", []interface{}{}
+	switch {
+	case rate.CategoryID != nil:
+		where, args = "category_id = ?", append(args, *rate.CategoryID)
+		if err := checkExists(ctx, tx, "categories", "category", *rate.CategoryID); err != nil {
+			return err
+		}
+	case rate.IndustryID != nil:
+		where, args = "industry_id = ?", append(args, *rate.IndustryID)
+		if err := checkExists(ctx, tx, "industries", "industry", *rate.IndustryID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE commission_rates SET
+			percent = ?,
+			updated_at = ?
+		WHERE `+where,
+		append([]interface{}{rate.Percent, tx.now}, args...)...,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO commission_rates (
+				category_id,
+				industry_id,
+				percent,
+				updated_at
+			) VALUES (?,?,?,?)
+			`,
+			rate.CategoryID,
+			rate.IndustryID,
+			rate.Percent,
+			tx.now,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkExists returns a not found error unless table has a row with the id.
+func checkExists(ctx context.Context, tx *Tx, table string, name string, id int) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE id = ?`, id).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return app.Errorf(app.NOTFOUND_ERR, "Unknown %s %d.", name, id)
+	}
+	return nil
+}
+
+// findCommissionPercent returns the commission rate of a category, else of
+// its industry, else the default rate.
+func findCommissionPercent(ctx context.Context, tx *Tx, categoryID sql.NullInt64) (float64, error) {
+	var percent float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT commission_rates.percent
+		FROM commission_rates
+		LEFT JOIN categories ON categories.id = ?
+		WHERE commission_rates.category_id = categories.id
+		OR commission_rates.industry_id = categories.industry_id
+		OR (commission_rates.category_id IS NULL AND commission_rates.industry_id IS NULL)
+		ORDER BY commission_rates.category_id IS NULL, commission_rates.industry_id IS NULL
+		LIMIT 1
+		`,
+		categoryID,
+	).Scan(&percent)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return percent, err
+}
+
+// commission returns the commission on an amount, rounded to the nearest
+// shilling.
+func commission(amount int, percent float64) int {
+	return int(math.Round(float64(amount) * percent / 100))
+}
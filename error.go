@@ -5,7 +5,11 @@ import (
 )
 
 const UNAUTHORIZED_ERR = "unauthorized"
+const FORBIDDEN_ERR = "forbidden"
 const INVALID_ERR = "invalid"
+const NOTFOUND_ERR = "not_found"
+const CONFLICT_ERR = "conflict"
+const RATELIMIT_ERR = "rate_limited"
 
 type Error struct {
 	// Machine-readable error code.
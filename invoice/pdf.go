@@ -0,0 +1,229 @@
+// Package invoice renders invoices and receipts as PDF documents.
+//
+// The documents only use the standard Helvetica fonts that every PDF reader
+// provides, so nothing has to be embedded or fetched to render them.
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+// A4 page size and margin in points.
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 50
+)
+
+// Right edges of the columns of the items table.
+const (
+	quantityRight = 360
+	unitRight     = 450
+	amountRight   = pageWidth - margin
+)
+
+// helveticaWidths are the widths of the printable ASCII characters, from
+// space to tilde, in thousandths of the font size. The bold font differs
+// only in letters, so amounts line up in either.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// Filename returns the name to download the PDF of an invoice as.
+func Filename(inv *app.Invoice) string {
+	return inv.Number + ".pdf"
+}
+
+// WritePDF writes an invoice or receipt as a single page PDF. Issuer is the
+// name of the business printed at the top.
+func WritePDF(w io.Writer, inv *app.Invoice, issuer string) error {
+	var page content
+
+	title := "INVOICE"
+	if inv.Kind == app.ReceiptKind {
+		title = "RECEIPT"
+	}
+	y := pageHeight - margin - 20
+	page.text(margin, y, true, 20, title)
+	page.textRight(amountRight, y, true, 14, issuer)
+
+	y -= 36
+	page.text(margin, y, true, 10, "Number")
+	page.text(margin+90, y, false, 10, inv.Number)
+	page.text(330, y, true, 10, "Billed to")
+	y -= 14
+	page.text(margin, y, true, 10, "Issued")
+	page.text(margin+90, y, false, 10, formatDate(inv.IssuedAt))
+	page.text(330, y, false, 10, inv.BilledTo)
+	y -= 14
+	page.text(margin, y, true, 10, "Status")
+	status := inv.Status
+	if inv.PaidAt != nil {
+		status += " on " + formatDate(*inv.PaidAt)
+	}
+	page.text(margin+90, y, false, 10, status)
+	page.text(330, y, false, 10, inv.Phone)
+
+	y -= 40
+	page.text(margin, y, true, 10, "Description")
+	page.textRight(quantityRight, y, true, 10, "Qty")
+	page.textRight(unitRight, y, true, 10, "Unit price")
+	page.textRight(amountRight, y, true, 10, "Amount ("+inv.Currency+")")
+	y -= 6
+	page.line(margin, y, amountRight, y)
+
+	for _, item := range inv.Items {
+		y -= 16
+		page.text(margin, y, false, 10, truncate(item.Description, 10, quantityRight-margin-40))
+		page.textRight(quantityRight, y, false, 10, strconv.Itoa(item.Quantity))
+		page.textRight(unitRight, y, false, 10, formatAmount(item.UnitAmount))
+		page.textRight(amountRight, y, false, 10, formatAmount(item.Amount))
+	}
+
+	y -= 10
+	page.line(margin, y, amountRight, y)
+	y -= 16
+	page.textRight(unitRight, y, false, 10, "Subtotal")
+	page.textRight(amountRight, y, false, 10, formatAmount(inv.Subtotal))
+	y -= 14
+	page.textRight(unitRight, y, false, 10, "Tax ("+strconv.FormatFloat(inv.TaxRate, 'f', -1, 64)+"%)")
+	page.textRight(amountRight, y, false, 10, formatAmount(inv.Tax))
+	y -= 16
+	page.textRight(unitRight, y, true, 11, "Total")
+	page.textRight(amountRight, y, true, 11, inv.Currency+" "+formatAmount(inv.Total))
+
+	return writeDocument(w, page.Bytes())
+}
+
+// content is the content stream of a page.
+type content struct {
+	bytes.Buffer
+}
+
+func (c *content) text(x, y int, bold bool, size int, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(c, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// textRight writes text that ends at x.
+func (c *content) textRight(x, y int, bold bool, size int, s string) {
+	c.text(x-textWidth(s, size), y, bold, size, s)
+}
+
+func (c *content) line(x1, y1, x2, y2 int) {
+	fmt.Fprintf(c, "0.5 w %d %d m %d %d l S\n", x1, y1, x2, y2)
+}
+
+// writeDocument writes a PDF with a single page drawn by stream.
+func writeDocument(w io.Writer, stream []byte) error {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pageWidth, pageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(stream), stream),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// escape makes s safe to use in a PDF string. Characters outside printable
+// ASCII are not in the fonts' encoding the same way and are replaced.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > '~':
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// textWidth returns the width of s in points at the given font size.
+func textWidth(s string, size int) int {
+	width := 0
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		width += helveticaWidths[r-' ']
+	}
+	return width * size / 1000
+}
+
+// truncate shortens s to fit in width points.
+func truncate(s string, size int, width int) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// formatAmount formats a whole amount with thousands separators.
+func formatAmount(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := strconv.Itoa(amount)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// formatDate formats a time returned by the app as a date, or returns it as
+// it is if it cannot be parsed.
+func formatDate(value string) string {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+	return t.Format("2 Jan 2006")
+}
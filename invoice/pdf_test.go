@@ -0,0 +1,69 @@
+package invoice_test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/invoice"
+)
+
+func TestWritePDF(t *testing.T) {
+	paidAt := "2023-03-02T10:00:00Z"
+	inv := &app.Invoice{
+		Kind:     app.ReceiptKind,
+		Number:   "RCT-000042",
+		BilledTo: "Jane (Doe)",
+		Phone:    "+254123456789",
+		Items: []*app.InvoiceItem{
+			{Description: "Plumbing repair", Quantity: 1, UnitAmount: 11600, Amount: 11600},
+		},
+		Currency: "Ksh",
+		Subtotal: 10000,
+		TaxRate:  16,
+		Tax:      1600,
+		Total:    11600,
+		Status:   app.InvoicePaid,
+		IssuedAt: paidAt,
+		PaidAt:   &paidAt,
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.WritePDF(&buf, inv, "Huduma"); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatalf("not a PDF document:\n%s", pdf)
+	}
+	for _, text := range []string{"(RECEIPT)", "(RCT-000042)", `(Jane \(Doe\))`, "(2 Mar 2023)", "(Ksh 11,600)", "(Tax \\(16%\\))"} {
+		if !strings.Contains(pdf, text) {
+			t.Errorf("PDF does not contain %s", text)
+		}
+	}
+
+	// Every object must be where the cross-reference table says it is.
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if startxref == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(startxref[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[xref:], -1)
+	if len(offsets) != 6 {
+		t.Fatalf("got %d objects, want 6", len(offsets))
+	}
+	for i, offset := range offsets {
+		n, _ := strconv.Atoi(offset[1])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !strings.HasPrefix(pdf[n:], want) {
+			t.Errorf("object %d is not at offset %d", i+1, n)
+		}
+	}
+}
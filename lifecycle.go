@@ -0,0 +1,183 @@
+package app
+
+// Booking statuses. Requests posted for bidding and direct bookings share the
+// same lifecycle:
+//
+//	requested -> bidding -> accepted -> in_progress -> completed
+//
+// with cancelled and disputed as side exits. A request whose provider
+// cancelled may be re-opened for bidding. Moves not listed in
+// bookingTransitions are rejected by ValidateBookingTransition.
+const (
+	BookingRequested  = "requested"
+	BookingBidding    = "bidding"
+	BookingAccepted   = "accepted"
+	BookingInProgress = "in_progress"
+	BookingCompleted  = "completed"
+	BookingCancelled  = "cancelled"
+	BookingDisputed   = "disputed"
+)
+
+var bookingTransitions = map[string][]string{
+	BookingRequested:  {BookingBidding, BookingAccepted, BookingCancelled},
+	BookingBidding:    {BookingAccepted, BookingCancelled},
+	BookingAccepted:   {BookingInProgress, BookingCancelled, BookingDisputed},
+	BookingInProgress: {BookingCompleted, BookingCancelled, BookingDisputed},
+	BookingCompleted:  {BookingDisputed},
+	BookingDisputed:   {BookingCompleted, BookingCancelled},
+	BookingCancelled:  {BookingBidding},
+}
+
+// CanTransitionBooking reports whether a booking may move from one status to another.
+func CanTransitionBooking(from, to string) bool {
+	for _, status := range bookingTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateBookingTransition returns a conflict error if a booking may not move
+// from one status to another.
+func ValidateBookingTransition(from, to string) error {
+	if _, ok := bookingTransitions[to]; !ok {
+		return Errorf(INVALID_ERR, "Unknown booking status %q.", to)
+	}
+	if !CanTransitionBooking(from, to) {
+		return Errorf(CONFLICT_ERR, "Booking cannot move from %s to %s.", from, to)
+	}
+	return nil
+}
+
+// Bid statuses. Providers bid on requests while they are open for bidding and
+// may revise their bid or withdraw it until then:
+//
+//	active -> accepted
+//
+// Accepting a bid rejects the other active bids on the request and closes
+// bidding. A bid past its expiry can no longer be accepted and expires.
+const (
+	BidActive    = "active"
+	BidAccepted  = "accepted"
+	BidRejected  = "rejected"
+	BidWithdrawn = "withdrawn"
+	BidExpired   = "expired"
+)
+
+// Reschedule statuses. Either party to a booking may propose moving it to
+// another time while it is requested or accepted. The other party accepts or
+// declines the proposal, or counters it with a proposal of their own:
+//
+//	pending -> accepted
+//
+// A new proposal by the same party supersedes their pending one. Pending
+// proposals are cancelled when the booking starts or ends.
+const (
+	ReschedulePending    = "pending"
+	RescheduleAccepted   = "accepted"
+	RescheduleDeclined   = "declined"
+	RescheduleCountered  = "countered"
+	RescheduleSuperseded = "superseded"
+	RescheduleCancelled  = "cancelled"
+)
+
+// ReschedulableBookingStatuses are the statuses of bookings that may be moved
+// to another time.
+var ReschedulableBookingStatuses = []string{BookingRequested, BookingAccepted}
+
+// Series statuses. A recurring series is active while its bookings are made
+// ahead of time, and ends once its rule has no more occurrences unless it is
+// cancelled first. Each occurrence of a series is booked, skipped, or failed
+// if its booking could not be made.
+const (
+	SeriesActive    = "active"
+	SeriesCancelled = "cancelled"
+	SeriesEnded     = "ended"
+
+	OccurrenceBooked  = "booked"
+	OccurrenceSkipped = "skipped"
+	OccurrenceFailed  = "failed"
+)
+
+// Subscription statuses. A subscription waits in pending until its first
+// payment, then stays active while renewals are paid:
+//
+//	pending -> active -> past_due -> active
+//
+// and ends as cancelled or expired. A subscription cancelled at the end of
+// its period stays active with auto renew off until it expires.
+const (
+	SubscriptionPending   = "pending"
+	SubscriptionActive    = "active"
+	SubscriptionPastDue   = "past_due"
+	SubscriptionCancelled = "cancelled"
+	SubscriptionExpired   = "expired"
+)
+
+var subscriptionTransitions = map[string][]string{
+	SubscriptionPending:   {SubscriptionActive, SubscriptionCancelled, SubscriptionExpired},
+	SubscriptionActive:    {SubscriptionActive, SubscriptionPastDue, SubscriptionCancelled, SubscriptionExpired},
+	SubscriptionPastDue:   {SubscriptionActive, SubscriptionCancelled, SubscriptionExpired},
+	SubscriptionCancelled: {},
+	SubscriptionExpired:   {},
+}
+
+// ValidateSubscriptionTransition returns a conflict error if a subscription
+// may not move from one status to another.
+func ValidateSubscriptionTransition(from, to string) error {
+	if _, ok := subscriptionTransitions[to]; !ok {
+		return Errorf(INVALID_ERR, "Unknown subscription status %q.", to)
+	}
+	for _, status := range subscriptionTransitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return Errorf(CONFLICT_ERR, "Subscription cannot move from %s to %s.", from, to)
+}
+
+// Escrow statuses. The escrow of a booking is opened when the booking is
+// accepted and holds the client's payment until the job is done:
+//
+//	awaiting_payment -> held -> released
+//
+// Released funds, less the platform commission, are credited to the
+// provider's earnings. Cancelling the booking refunds held funds
+// (held -> refunding -> refunded) and cancels an escrow that was never paid.
+// Funds of a disputed booking stay held until the dispute is resolved.
+const (
+	EscrowAwaitingPayment = "awaiting_payment"
+	EscrowHeld            = "held"
+	EscrowReleased        = "released"
+	EscrowRefunding       = "refunding"
+	EscrowRefunded        = "refunded"
+	EscrowCancelled       = "cancelled"
+)
+
+// Payout statuses. A payout is requested, sent to the payout gateway and
+// then paid or failed once the gateway reports its outcome:
+//
+//	requested -> sent -> paid
+//
+// A failed refund is requested again; a failed withdrawal fails and its
+// amount becomes available to withdraw again.
+const (
+	PayoutRequested = "requested"
+	PayoutSent      = "sent"
+	PayoutPaid      = "paid"
+	PayoutFailed    = "failed"
+)
+
+// Invoice kinds and statuses. A booking completed before it was paid for gets
+// an issued invoice, which is marked paid once the payment arrives or void if
+// the booking is cancelled instead. Every payment for a completed booking or
+// a period of a subscription gets a receipt, which is always paid.
+const (
+	InvoiceKind = "invoice"
+	ReceiptKind = "receipt"
+
+	InvoiceIssued = "issued"
+	InvoicePaid   = "paid"
+	InvoiceVoid   = "void"
+)
@@ -37,6 +37,12 @@ type PwdChange struct {
 	NewPassword string `valid:"required" json:"new_password"`
 }
 
+type PasswordReset struct {
+	Phone       string `valid:"required" json:"phone"`
+	Code        string `valid:"required,numeric" json:"code"`
+	NewPassword string `valid:"required" json:"new_password"`
+}
+
 type Provider struct {
 	ID uuid.UUID `valid:"required" json:"provider_id"`
 	Profile
@@ -52,6 +58,8 @@ type Service struct {
 	Name       string `valid:"required" json:"name"`
 	Rate
 	CategoryID *string `json:"category_id"`
+	// Duration is how long the service takes in minutes.
+	Duration int `json:"duration,string,omitempty"`
 }
 
 type Rate struct {
@@ -89,6 +97,75 @@ type Booking struct {
 	ClientID   string    `valid:"required" json:"client_id"`
 	ServiceID  string    `valid:"required" json:"service_id"`
 	Photos     []string  `json:"-"`
+	// SeriesID is the recurring series the booking was made for.
+	SeriesID *string `json:"series_id,omitempty"`
+}
+
+// BookingTransition describes a status change of a booking made by an actor.
+type BookingTransition struct {
+	BookingID uuid.UUID `valid:"required"`
+	Status    string    `valid:"required" json:"status"`
+	ActorID   string    `valid:"required"`
+	Reason    *string   `json:"reason"`
+}
+
+// BookingCancellation is the cancellation of a booking by an actor.
+type BookingCancellation struct {
+	BookingID uuid.UUID `valid:"required"`
+	ActorID   string    `valid:"required"`
+	Reason    *string   `json:"reason"`
+	// NoShow is set by the provider cancelling because the client did not
+	// show up.
+	NoShow bool `json:"no_show"`
+	// AcceptedFee is the cancellation fee the client agreed to pay.
+	AcceptedFee *int `json:"accept_fee"`
+}
+
+// BookingCheckpoint is where the provider of a booking checks in or out.
+type BookingCheckpoint struct {
+	BookingID uuid.UUID `valid:"required"`
+	ActorID   string    `valid:"required"`
+	Latitude  string    `valid:"required,latitude" json:"latitude"`
+	Longitude string    `valid:"required,longitude" json:"longitude"`
+}
+
+// BookingCompletion is the confirmation that the job of a booking is done.
+// The provider confirms with the completion code the client gives them.
+type BookingCompletion struct {
+	BookingID uuid.UUID `valid:"required"`
+	ActorID   string    `valid:"required"`
+	Code      *string   `json:"code"`
+}
+
+// BookingSeries books a service at recurring times. The times follow the
+// preset Frequency, or Rule if the frequency is custom.
+type BookingSeries struct {
+	ID         string `json:"series_id"`
+	ClientID   string `valid:"required" json:"-"`
+	ProviderID string `valid:"required" json:"provider_id"`
+	ServiceID  string `valid:"required,int" json:"service_id"`
+	LocationID string `valid:"required,uuid" json:"location_id"`
+	StartDate  string `valid:"required" json:"start_date"`
+	Frequency  string `valid:"required,in(weekly|fortnightly|monthly|custom)" json:"frequency"`
+	Rule       string `json:"rule"`
+}
+
+// RescheduleProposal is a proposal by an actor to move a booking to another
+// time.
+type RescheduleProposal struct {
+	ID        int       `json:"reschedule_id"`
+	BookingID uuid.UUID `valid:"required" json:"booking_id"`
+	ActorID   string    `valid:"required" json:"-"`
+	StartDate string    `valid:"required" json:"start_date"`
+	Reason    *string   `json:"reason"`
+}
+
+// RescheduleDecision accepts or declines a proposal to move a booking.
+type RescheduleDecision struct {
+	BookingID    uuid.UUID `valid:"required"`
+	RescheduleID int       `valid:"required"`
+	ActorID      string    `valid:"required"`
+	Accept       bool
 }
 
 type Request struct {
@@ -98,10 +175,11 @@ type Request struct {
 	Note       string    `valid:"required" json:"note"`
 	LocationID string    `valid:"required,uuid" json:"location_id"`
 	ClientID   string    `valid:"required" json:"client_id"`
-	//CategoryID string    `json:"category_id,omitempty"`
-	Photos []string `json:"-"`
-	Status string   `json:"status"`
-	Urgent bool     `json:"urgent,string"`
+	// CategoryID is suggested from the title if it is not given.
+	CategoryID string   `valid:"int,optional" json:"category_id,omitempty"`
+	Photos     []string `json:"-"`
+	Status     string   `json:"status"`
+	Urgent     bool     `json:"urgent,string"`
 }
 
 type Photo struct {
@@ -127,6 +205,7 @@ type Category struct {
 	Description *string `json:"description"`
 	ParentID    *string `json:"parent_id"`
 	IconURL     string  `json:"icon_url" valid:"required"`
+	Keywords    *string `json:"keywords"`
 }
 
 type Industry struct {
@@ -166,9 +245,25 @@ type UserLocation struct {
 }
 
 type Bid struct {
-	BookingID string `valid:"required,uuid" json:"request_id"`
-	BidderID  string `valid:"required" json:"user_id"`
-	Amount    string `valid:"required" json:"amount"`
+	ID        int     `json:"bid_id"`
+	BookingID string  `valid:"required,uuid" json:"request_id"`
+	BidderID  string  `valid:"required" json:"user_id"`
+	Amount    string  `valid:"required,numeric" json:"amount"`
+	Message   *string `json:"message"`
+	// ETA is when the provider can start the job. A bid with ExpiresAt can
+	// no longer be accepted after then.
+	ETA       *time.Time `json:"-"`
+	ExpiresAt *time.Time `json:"-"`
+}
+
+// BidUpdate revises a bid. It replaces the amount, message, ETA and expiry of
+// the bid.
+type BidUpdate struct {
+	ID        int        `valid:"required"`
+	Amount    string     `valid:"required,numeric" json:"amount"`
+	Message   *string    `json:"message"`
+	ETA       *time.Time `json:"-"`
+	ExpiresAt *time.Time `json:"-"`
 }
 
 type Search struct {
@@ -178,15 +273,32 @@ type Search struct {
 	Distance  string
 }
 
+// Transaction is an entry in the ledger of money paid by or to a user.
 type Transaction struct {
-	Model
-	Code       string
-	ServiceID  uuid.UUID
-	BookingID  uuid.UUID
-	UserID     string
-	ProviderID string
-	Amount     int
-	Currency   string
+	TransactionID  string
+	UserID         string `valid:"required"`
+	BookingID      *string
+	SubscriptionID *string
+	Direction      string `valid:"required,in(debit|credit)"`
+	Method         string `valid:"required"`
+	// ProviderReference is the reference of the transaction at the payment
+	// provider, such as an M-Pesa receipt number.
+	ProviderReference *string
+	AccountReference  string
+	Amount            int    `valid:"required"`
+	Currency          string `valid:"required"`
+	Status            string `valid:"required,in(pending|completed|failed)"`
+	Description       string
+}
+
+// TransactionFilter selects the transactions of a user. From and To are
+// inclusive.
+type TransactionFilter struct {
+	UserID    string
+	Status    string
+	Direction string
+	From      *time.Time
+	To        *time.Time
 }
 
 type Profile struct {
@@ -204,9 +316,45 @@ type Profile struct {
 	Verified   bool
 }
 
-type Schedule struct {
-	StartTime string
-	EndTime   string
+// WorkingHours are hours a provider works on a day of the week. Weekday 0 is
+// Sunday.
+type WorkingHours struct {
+	Weekday   int    `json:"weekday"`
+	StartTime string `valid:"required" json:"start_time"`
+	EndTime   string `valid:"required" json:"end_time"`
+}
+
+// WeeklyHours are all the working hours of a provider.
+type WeeklyHours struct {
+	UserID string          `valid:"required" json:"-"`
+	Hours  []*WorkingHours `json:"hours"`
+}
+
+// AvailabilityException replaces the working hours of a provider on a date.
+type AvailabilityException struct {
+	ID        string `json:"exception_id"`
+	UserID    string `valid:"required" json:"-"`
+	Date      string `valid:"required" json:"date"`
+	StartTime string `valid:"required" json:"start_time"`
+	EndTime   string `valid:"required" json:"end_time"`
+}
+
+// Blackout is a run of days a provider does not work.
+type Blackout struct {
+	ID        string  `json:"blackout_id"`
+	UserID    string  `valid:"required" json:"-"`
+	StartDate string  `valid:"required" json:"start_date"`
+	EndDate   string  `valid:"required" json:"end_date"`
+	Reason    *string `json:"reason"`
+}
+
+// SlotFilter selects the free slots of a service of a provider between two
+// dates, inclusive.
+type SlotFilter struct {
+	ProviderID string `valid:"required"`
+	ServiceID  string `valid:"required,int"`
+	From       string `valid:"required"`
+	To         string `valid:"required"`
 }
 
 // ProviderFilter represents a filter used on service providers.
@@ -225,33 +373,81 @@ type RequestFilter struct {
 }
 
 type Plan struct {
-	Code          string
-	Name          string
-	Description   string
-	Currency      string
-	Period        string
-	Price         string
-	Interval      string
-	IntervalUnit  string
-	BillingCycles string
-	// Features      []string
+	ID             string `json:"id"`
+	Code           string `valid:"required" json:"code"`
+	Name           string `valid:"required" json:"name"`
+	Description    string `json:"description"`
+	Price          int    `json:"price,string"`
+	Currency       string `valid:"required" json:"currency"`
+	Interval       int    `json:"interval,string"`
+	IntervalUnit   string `valid:"required,in(day|week|month|year)" json:"interval_unit"`
+	TrialDays      int    `json:"trial_days,string"`
+	SearchRadiusKm int    `json:"search_radius_km,string"`
+	Premium        bool   `json:"premium,string"`
+}
+
+// STKResult is the outcome of an STK push reported to the callback URL.
+type STKResult struct {
+	CheckoutRequestID string
+	ResultCode        int
+	ResultDesc        string
+	// Set when the payment succeeded.
+	Receipt string
+	Amount  int
+	Phone   string
+}
+
+// PayoutResult is the outcome of a payout reported to the result URL.
+type PayoutResult struct {
+	Reference  string
+	ResultCode int
+	ResultDesc string
+	// Set when the payout succeeded.
+	Receipt string
+}
+
+// PayoutRequest withdraws earnings to an M-Pesa number. Without an amount
+// the whole available balance is withdrawn.
+type PayoutRequest struct {
+	ID     string `json:"-"`
+	UserID string `valid:"required" json:"-"`
+	Amount int    `json:"amount"`
+	Phone  string `valid:"required" json:"phone"`
+}
+
+// CommissionRate sets the commission of a category or an industry, or the
+// default commission when neither is given.
+type CommissionRate struct {
+	CategoryID *int    `json:"category_id"`
+	IndustryID *int    `json:"industry_id"`
+	Percent    float64 `json:"percent"`
+}
+
+// CancellationPolicy sets the cancellation policy of the provider UserID, or
+// of a category, or the default policy when neither is given.
+type CancellationPolicy struct {
+	UserID        string  `json:"-"`
+	CategoryID    *int    `json:"category_id"`
+	FreeHours     int     `json:"free_hours"`
+	FeePercent    float64 `json:"fee_percent"`
+	NoShowPercent float64 `json:"no_show_percent"`
+}
+
+// C2BPayment is a payment made directly to the paybill.
+type C2BPayment struct {
+	TransID          string
+	AccountReference string
+	Amount           int
+	Phone            string
 }
 
 type Subscription struct {
-	SubscriptionID  string  `json:"subscription_id"`
-	ClientID        string  `json:"client_id"`
-	PaymentMethodID string  `json:"payment_method_id"`
-	PaymentID       string  `json:"payment_id"`
-	PlanID          string  `json:"plan_id"`
-	AutoRenew       bool    `json:"autorenew"`
-	Status          string  `json:"status"`
-	BillingCycles   int     `json:"billing_cycles"`
-	NextBilling     string  `json:"next_billing"`
-	ActivatedAt     string  `json:"activated_at"`
-	CancelledAt     *string `json:"canceled_at"`
-	StartsAt        string  `json:"starts_at"`
-	ExpireBy        *string `json:"expires_by"`
-	EndsAt          *string `json:"ends_at"`
+	SubscriptionID  string `json:"subscription_id"`
+	ClientID        string `valid:"required" json:"client_id"`
+	PlanID          string `valid:"required" json:"plan_id"`
+	PaymentMethodID string `json:"payment_method_id"`
+	AutoRenew       bool   `json:"autorenew,string"`
+	Status          string `json:"status"`
 }
 
 type Payment struct {
@@ -262,10 +458,22 @@ type Payment struct {
 	subscription_id   string
 }
 
+// PaymentMethod is an M-Pesa number or a card added by a user. Cards are
+// given as the token and last four digits returned by the card processor.
 type PaymentMethod struct {
 	ID          string `json:"id"`
-	Method      string `json:"payment_method"` // eg. visa, mastercard, mpesa
-	CardNumber  string `json:"card_number,omitempty"`
+	UserID      string `valid:"required" json:"user_id"`
+	Method      string `valid:"required,in(mpesa|visa|mastercard)" json:"payment_method"`
 	PhoneNumber string `json:"phone_number,omitempty"`
-	Type        string `json:"type"` // enum eg. Debit, Credit, Mobile, Bank
+	CardToken   string `json:"card_token,omitempty"`
+	CardLast4   string `valid:"numeric,stringlength(4|4)" json:"card_last4,omitempty"`
+	CardExpiry  string `valid:"matches(^(0[1-9]|1[0-2])/[0-9]{2}$)" json:"card_expiry,omitempty"`
+}
+
+// Type returns the type of the payment method.
+func (p PaymentMethod) Type() string {
+	if p.Method == "mpesa" {
+		return "mobile"
+	}
+	return "card"
 }
@@ -1,6 +1,12 @@
 package model
 
-import "github.com/asaskevich/govalidator"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
 
 func (b Booking) Validate() error {
 	_, err := govalidator.ValidateStruct(b)
@@ -10,6 +16,74 @@ func (b Booking) Validate() error {
 	return nil
 }
 
+func (p PasswordReset) Validate() error {
+	_, err := govalidator.ValidateStruct(p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t BookingTransition) Validate() error {
+	_, err := govalidator.ValidateStruct(t)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c BookingCancellation) Validate() error {
+	if _, err := govalidator.ValidateStruct(c); err != nil {
+		return err
+	}
+	if c.AcceptedFee != nil && *c.AcceptedFee < 0 {
+		return errors.New("accept_fee: cannot be negative")
+	}
+	return nil
+}
+
+func (c BookingCheckpoint) Validate() error {
+	_, err := govalidator.ValidateStruct(c)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c BookingCompletion) Validate() error {
+	_, err := govalidator.ValidateStruct(c)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s BookingSeries) Validate() error {
+	if _, err := govalidator.ValidateStruct(s); err != nil {
+		return err
+	}
+	if s.Frequency == "custom" && s.Rule == "" {
+		return fmt.Errorf("rule: required for a custom frequency")
+	}
+	return nil
+}
+
+func (p RescheduleProposal) Validate() error {
+	_, err := govalidator.ValidateStruct(p)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d RescheduleDecision) Validate() error {
+	_, err := govalidator.ValidateStruct(d)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (r Request) Validate() error {
 	_, err := govalidator.ValidateStruct(r)
 	if err != nil {
@@ -55,6 +129,9 @@ func (s Service) Validate() error {
 	if err != nil {
 		return err
 	}
+	if s.Duration < 0 {
+		return errors.New("duration: must not be negative")
+	}
 	return nil
 }
 
@@ -66,6 +143,14 @@ func (b Bid) Validate() error {
 	return nil
 }
 
+func (b BidUpdate) Validate() error {
+	_, err := govalidator.ValidateStruct(b)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (u User) Validate() error {
 	_, err := govalidator.ValidateStruct(u)
 	if err != nil {
@@ -97,3 +182,182 @@ func (s Search) Validate() error {
 	}
 	return nil
 }
+
+func (p Plan) Validate() error {
+	_, err := govalidator.ValidateStruct(p)
+	if err != nil {
+		return err
+	}
+	if p.Price < 0 {
+		return errors.New("price: must not be negative")
+	}
+	return nil
+}
+
+func (s Subscription) Validate() error {
+	_, err := govalidator.ValidateStruct(s)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t Transaction) Validate() error {
+	_, err := govalidator.ValidateStruct(t)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p PaymentMethod) Validate() error {
+	_, err := govalidator.ValidateStruct(p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.Type() == "mobile" && p.PhoneNumber == "":
+		return errors.New("phone_number: non zero value required")
+	case p.Type() == "card" && p.CardToken == "":
+		return errors.New("card_token: non zero value required")
+	case p.Type() == "card" && p.CardLast4 == "":
+		return errors.New("card_last4: non zero value required")
+	}
+	return nil
+}
+
+func (p PayoutRequest) Validate() error {
+	_, err := govalidator.ValidateStruct(p)
+	if err != nil {
+		return err
+	}
+	if p.Amount < 0 {
+		return errors.New("amount: must not be negative")
+	}
+	return nil
+}
+
+func (c CommissionRate) Validate() error {
+	switch {
+	case c.CategoryID != nil && c.IndustryID != nil:
+		return errors.New("category_id: cannot be set together with industry_id")
+	case c.Percent < 0 || c.Percent > 100:
+		return errors.New("percent: must be between 0 and 100")
+	}
+	return nil
+}
+
+func (p CancellationPolicy) Validate() error {
+	switch {
+	case p.UserID != "" && p.CategoryID != nil:
+		return errors.New("category_id: providers set the policy of their own bookings")
+	case p.FreeHours < 0 || p.FreeHours > 720:
+		return errors.New("free_hours: must be between 0 and 720")
+	case p.FeePercent < 0 || p.FeePercent > 100:
+		return errors.New("fee_percent: must be between 0 and 100")
+	case p.NoShowPercent < 0 || p.NoShowPercent > 100:
+		return errors.New("no_show_percent: must be between 0 and 100")
+	}
+	return nil
+}
+
+// ParseClock returns the minutes since midnight of a time of day like 08:30.
+// 24:00 is the end of the day.
+func ParseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("%q is not a time like 08:30", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateHours returns an error unless start and end are times of day and
+// start is before end.
+func validateHours(start, end string) error {
+	from, err := ParseClock(start)
+	if err != nil {
+		return fmt.Errorf("start_time: %s", err)
+	}
+	to, err := ParseClock(end)
+	if err != nil {
+		return fmt.Errorf("end_time: %s", err)
+	}
+	if from >= to {
+		return errors.New("end_time: must be after start_time")
+	}
+	return nil
+}
+
+func (w WeeklyHours) Validate() error {
+	if _, err := govalidator.ValidateStruct(w); err != nil {
+		return err
+	}
+	for i, h := range w.Hours {
+		if h.Weekday < 0 || h.Weekday > 6 {
+			return errors.New("weekday: must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		if err := validateHours(h.StartTime, h.EndTime); err != nil {
+			return err
+		}
+		// Hours of the same day must not overlap.
+		for _, other := range w.Hours[:i] {
+			if other.Weekday == h.Weekday && other.StartTime < h.EndTime && h.StartTime < other.EndTime {
+				return errors.New("hours: overlapping hours on the same day")
+			}
+		}
+	}
+	return nil
+}
+
+func (e AvailabilityException) Validate() error {
+	if _, err := govalidator.ValidateStruct(e); err != nil {
+		return err
+	}
+	if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+		return errors.New("date: must be a date like 2006-01-02")
+	}
+	return validateHours(e.StartTime, e.EndTime)
+}
+
+func (b Blackout) Validate() error {
+	if _, err := govalidator.ValidateStruct(b); err != nil {
+		return err
+	}
+	if _, err := time.Parse("2006-01-02", b.StartDate); err != nil {
+		return errors.New("start_date: must be a date like 2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", b.EndDate); err != nil {
+		return errors.New("end_date: must be a date like 2006-01-02")
+	}
+	if b.EndDate < b.StartDate {
+		return errors.New("end_date: must not be before start_date")
+	}
+	return nil
+}
+
+// maxSlotDays is the longest range of days free slots are listed for.
+const maxSlotDays = 31
+
+func (f SlotFilter) Validate() error {
+	if _, err := govalidator.ValidateStruct(f); err != nil {
+		return err
+	}
+	from, err := time.Parse("2006-01-02", f.From)
+	if err != nil {
+		return errors.New("from: must be a date like 2006-01-02")
+	}
+	to, err := time.Parse("2006-01-02", f.To)
+	if err != nil {
+		return errors.New("to: must be a date like 2006-01-02")
+	}
+	switch {
+	case to.Before(from):
+		return errors.New("to: must not be before from")
+	case to.Sub(from) >= maxSlotDays*24*time.Hour:
+		return fmt.Errorf("to: must be less than %d days after from", maxSlotDays)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package fake provides payment and payout gateways that move no money, for
+// development and tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Payout is a payout sent through a PayoutGateway.
+type Payout struct {
+	Reference string
+	Phone     string
+	Amount    int
+	Remarks   string
+}
+
+// PayoutGateway logs and records the payouts it is asked to send. Their
+// outcome has to be reported to the result URL by hand.
+type PayoutGateway struct {
+	// Err, if set, is returned instead of sending a payout.
+	Err error
+
+	mu      sync.Mutex
+	payouts []Payout
+}
+
+func (g *PayoutGateway) SendPayout(ctx context.Context, phone string, amount int, remarks string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.Err != nil {
+		return "", g.Err
+	}
+	payout := Payout{
+		Reference: fmt.Sprintf("fake-payout-%d", len(g.payouts)+1),
+		Phone:     phone,
+		Amount:    amount,
+		Remarks:   remarks,
+	}
+	g.payouts = append(g.payouts, payout)
+	log.Printf("fake: payout %s of %d to %s: %s", payout.Reference, amount, phone, remarks)
+	return payout.Reference, nil
+}
+
+// Payouts returns the payouts sent so far.
+func (g *PayoutGateway) Payouts() []Payout {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Payout(nil), g.payouts...)
+}
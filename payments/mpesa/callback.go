@@ -0,0 +1,110 @@
+package mpesa
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// STKCallback is the body Daraja posts to the callback URL of an STK push.
+type STKCallback struct {
+	Body struct {
+		StkCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []CallbackItem `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// CallbackItem is a named value in the metadata of a callback. Values are
+// numbers or strings depending on the item.
+type CallbackItem struct {
+	Name  string          `json:"Name"`
+	Value json.RawMessage `json:"Value,omitempty"`
+}
+
+// Item returns the value of the named metadata item as a string, or "" if it
+// is missing.
+func (c *STKCallback) Item(name string) string {
+	for _, item := range c.Body.StkCallback.CallbackMetadata.Item {
+		if item.Name != name || len(item.Value) == 0 {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(item.Value, &s); err == nil {
+			return s
+		}
+		return string(item.Value)
+	}
+	return ""
+}
+
+// Amount returns the amount paid in whole shillings.
+func (c *STKCallback) Amount() (int, error) {
+	return parseAmount(c.Item("Amount"))
+}
+
+// C2BCallback is the body Daraja posts to the validation and confirmation
+// URLs of a C2B payment.
+type C2BCallback struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// Amount returns the amount paid in whole shillings.
+func (c *C2BCallback) Amount() (int, error) {
+	return parseAmount(c.TransAmount)
+}
+
+// B2CResult is the body Daraja posts to the result URL of a B2C payment.
+type B2CResult struct {
+	Result struct {
+		ResultType               int    `json:"ResultType"`
+		ResultCode               int    `json:"ResultCode"`
+		ResultDesc               string `json:"ResultDesc"`
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ConversationID           string `json:"ConversationID"`
+		TransactionID            string `json:"TransactionID"`
+	} `json:"Result"`
+}
+
+// CallbackResponse is the reply Daraja expects to a callback.
+type CallbackResponse struct {
+	ResultCode interface{} `json:"ResultCode"`
+	ResultDesc string      `json:"ResultDesc"`
+}
+
+// Replies to C2B validation requests.
+var (
+	Accepted = CallbackResponse{ResultCode: 0, ResultDesc: "Accepted"}
+	// RejectedAccount rejects a payment to an unknown account number.
+	RejectedAccount = CallbackResponse{ResultCode: "C2B00012", ResultDesc: "Rejected"}
+	// RejectedAmount rejects a payment of the wrong amount.
+	RejectedAmount = CallbackResponse{ResultCode: "C2B00013", ResultDesc: "Rejected"}
+)
+
+// parseAmount parses an amount such as "10", "10.00" or 10.0 into whole
+// shillings. M-Pesa does not move cents.
+func parseAmount(value string) (int, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("mpesa: invalid amount %q", value)
+	}
+	return int(f), nil
+}
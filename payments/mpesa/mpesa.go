@@ -1,211 +1,330 @@
+// Package mpesa is a client of the Safaricom Daraja M-Pesa API.
 package mpesa
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-type Payload struct {
-	BusinessShortCode int    `json:"BusinessShortCode"`
-	Password          string `json:"Password"`
-	Timestamp         string `json:"Timestamp"`
-	TransactionType   string `json:"TransactionType"`
-	Amount            int    `json:"Amount"`
-	PartyA            int    `json:"PartyA"`
-	PartyB            int    `json:"PartyB"`
-	PhoneNumber       int    `json:"PhoneNumber"`
-	CallBackURL       string `json:"CallBackURL"`
-	AccountReference  string `json:"AccountReference"`
-	TransactionDesc   string `json:"TransactionDesc"`
+// Base URLs of the Daraja environments.
+const (
+	SandboxURL    = "https://sandbox.safaricom.co.ke"
+	ProductionURL = "https://api.safaricom.co.ke"
+)
+
+// tokenExpiryMargin is how long before it expires an access token is
+// replaced, so a request never goes out with a token about to expire.
+const tokenExpiryMargin = time.Minute
+
+// Config holds the credentials and URLs of a Daraja app.
+type Config struct {
+	BaseURL        string
+	ConsumerKey    string
+	ConsumerSecret string
+
+	// Lipa na M-Pesa Online (STK push) settings. TransactionType defaults to
+	// CustomerPayBillOnline.
+	ShortCode       string
+	PassKey         string
+	CallbackURL     string
+	TransactionType string
+
+	// C2B settings.
+	C2BShortCode    string
+	ConfirmationURL string
+	ValidationURL   string
+
+	// B2C settings.
+	B2CShortCode       string
+	InitiatorName      string
+	SecurityCredential string
+	B2CResultURL       string
+	B2CTimeoutURL      string
 }
 
-type Response struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   string `json:"expires_in"`
+// Client calls the Daraja API. It fetches an access token on first use and
+// reuses it until it expires.
+type Client struct {
+	cfg        Config
+	HTTPClient *http.Client
+	// Now returns the current time. It is used for token expiry and the
+	// timestamp of STK requests.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
 }
 
-const businessShortCode int = 174379
+func NewClient(cfg Config) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = SandboxURL
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.TransactionType == "" {
+		cfg.TransactionType = CustomerPayBillOnline
+	}
+	return &Client{
+		cfg:        cfg,
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		Now:        time.Now,
+	}
+}
 
-func STKPush() error {
-	url := "https://sandbox.safaricom.co.ke/mpesa/stkpush/v1/processrequest"
-	method := "POST"
+// STKPush asks the owner of the phone number to pay amount to the STK short
+// code. The phone number may be in local or international format.
+func (c *Client) STKPush(ctx context.Context, phone string, amount int, accountReference string, description string) (*STKPushResponse, error) {
+	msisdn, err := FormatPhone(phone)
+	if err != nil {
+		return nil, err
+	}
 
-	passKey := "bfb279f9aa9bdbcf158e97dd71a467cd2e0c893059b10f78e6b72ada1ed2c919"
-	timestamp := time.Now().Format("20060102150405")
+	password, timestamp := c.password()
+	req := STKPushRequest{
+		BusinessShortCode: c.cfg.ShortCode,
+		Password:          password,
+		Timestamp:         timestamp,
+		TransactionType:   c.cfg.TransactionType,
+		Amount:            amount,
+		PartyA:            msisdn,
+		PartyB:            c.cfg.ShortCode,
+		PhoneNumber:       msisdn,
+		CallBackURL:       c.cfg.CallbackURL,
+		AccountReference:  accountReference,
+		TransactionDesc:   description,
+	}
 
-	pw := base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(businessShortCode) + passKey + timestamp))
+	var resp STKPushResponse
+	if err := c.post(ctx, "/mpesa/stkpush/v1/processrequest", req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ResponseCode != "0" {
+		return nil, &Error{StatusCode: http.StatusOK, ErrorCode: resp.ResponseCode, ErrorMessage: resp.ResponseDescription}
+	}
+	return &resp, nil
+}
 
-	payload := &Payload{
-		BusinessShortCode: businessShortCode,
-		Password:          pw,
+// STKQuery looks up the outcome of an STK push.
+func (c *Client) STKQuery(ctx context.Context, checkoutRequestID string) (*STKQueryResponse, error) {
+	password, timestamp := c.password()
+	req := STKQueryRequest{
+		BusinessShortCode: c.cfg.ShortCode,
+		Password:          password,
 		Timestamp:         timestamp,
-		TransactionType:   "CustomerPayBillOnline",
-		Amount:            1,
-		PartyA:            254708374149,
-		PartyB:            businessShortCode,
-		PhoneNumber:       254719825151,
-		CallBackURL:       "https://hudumaapp.herokuapp.com/transactions/confirm",
-		AccountReference:  "CompanyXLTD",
-		TransactionDesc:   "Payment of X",
+		CheckoutRequestID: checkoutRequestID,
 	}
 
-	pl, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	pldStr := strings.NewReader(string(pl[:]))
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, pldStr)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	var resp STKQueryResponse
+	if err := c.post(ctx, "/mpesa/stkpushquery/v1/query", req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	token, err := accessToken()
+// RegisterC2BURLs registers the configured confirmation and validation URLs
+// for payments to the C2B short code.
+func (c *Client) RegisterC2BURLs(ctx context.Context) (*C2BResponse, error) {
+	req := C2BRegisterRequest{
+		ShortCode:       c.cfg.C2BShortCode,
+		ResponseType:    "Completed",
+		ConfirmationURL: c.cfg.ConfirmationURL,
+		ValidationURL:   c.cfg.ValidationURL,
+	}
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+token)
+	var resp C2BResponse
+	if err := c.post(ctx, "/mpesa/c2b/v1/registerurl", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	res, err := client.Do(req)
+// SimulateC2B simulates the owner of the phone number paying amount to the C2B
+// short code. Daraja only offers it in the sandbox.
+func (c *Client) SimulateC2B(ctx context.Context, phone string, amount int, billRef string) (*C2BResponse, error) {
+	msisdn, err := FormatPhone(phone)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return nil, err
 	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return err
+
+	req := C2BSimulateRequest{
+		ShortCode:     c.cfg.C2BShortCode,
+		CommandID:     CustomerPayBillOnline,
+		Amount:        amount,
+		Msisdn:        msisdn,
+		BillRefNumber: billRef,
 	}
-	fmt.Println(string(body))
-	return nil
-}
 
-func GetPayment() error {
-	return nil
+	var resp C2BResponse
+	if err := c.post(ctx, "/mpesa/c2b/v1/simulate", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
 }
 
-func accessToken() (string, error) {
-	url := "https://sandbox.safaricom.co.ke/oauth/v1/generate?grant_type=client_credentials"
-	method := "GET"
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+// B2C pays amount from the B2C short code to the phone number.
+func (c *Client) B2C(ctx context.Context, phone string, amount int, remarks string, occasion string) (*B2CResponse, error) {
+	msisdn, err := FormatPhone(phone)
 	if err != nil {
-		fmt.Println(err)
-		return "", err
+		return nil, err
 	}
 
-	consumerKey := "OS7jGKun8KgrybHTr5koshJPzSUAligA"
-	consumerSecret := "WrZhzVxkakde91Ga"
+	req := B2CRequest{
+		InitiatorName:      c.cfg.InitiatorName,
+		SecurityCredential: c.cfg.SecurityCredential,
+		CommandID:          BusinessPayment,
+		Amount:             amount,
+		PartyA:             c.cfg.B2CShortCode,
+		PartyB:             msisdn,
+		Remarks:            remarks,
+		QueueTimeOutURL:    c.cfg.B2CTimeoutURL,
+		ResultURL:          c.cfg.B2CResultURL,
+		Occasion:           occasion,
+	}
+
+	var resp B2CResponse
+	if err := c.post(ctx, "/mpesa/b2c/v1/paymentrequest", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	token := base64.StdEncoding.EncodeToString([]byte(consumerKey + ":" + consumerSecret))
+// password returns the password and timestamp of an STK request.
+func (c *Client) password() (string, string) {
+	timestamp := c.Now().In(nairobi).Format("20060102150405")
+	password := base64.StdEncoding.EncodeToString([]byte(c.cfg.ShortCode + c.cfg.PassKey + timestamp))
+	return password, timestamp
+}
+
+// nairobi is the time zone of STK timestamps.
+var nairobi = time.FixedZone("EAT", 3*60*60)
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Basic "+token)
+// accessToken returns the cached access token, fetching a new one if it is
+// missing or about to expire.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
 
-	res, err := client.Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
 	if err != nil {
-		fmt.Println(err)
 		return "", err
 	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	fmt.Println(string(body))
+	req.SetBasicAuth(c.cfg.ConsumerKey, c.cfg.ConsumerSecret)
 
-	var response Response
-	json.Unmarshal(body, &response)
-	fmt.Println("Access Token:", response.AccessToken)
+	var resp tokenResponse
+	if err := c.do(req, &resp); err != nil {
+		return "", err
+	}
+	expiresIn, err := strconv.Atoi(resp.ExpiresIn)
+	if err != nil {
+		return "", fmt.Errorf("mpesa: invalid token expiry %q", resp.ExpiresIn)
+	}
 
-	return response.AccessToken, nil
+	c.token = resp.AccessToken
+	c.tokenExpiry = c.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
 }
 
-func C2BRegisterURL() error {
-	body, err := json.Marshal(registerURL{
-		ConfirmationURL: "https://hudumaapp.herokuapp.com/transactions/confirm",
-		ValidationURL:   "https://hudumaapp.herokuapp.com/transactions/validate",
-		ShortCode:       600979,
-		ResponseType:    "completed",
-	})
+// post sends body as JSON to the API path and decodes the response into v.
+func (c *Client) post(ctx context.Context, path string, body interface{}, v interface{}) error {
+	token, err := c.accessToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	auth, err := accessToken()
+	buf, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+path, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	headers := make(map[string]string)
-	headers["Content-Type"] = "application/json"
-	headers["Authorization"] = "Bearer " + auth
-	headers["Cache-Control"] = "no-cache"
-
-	url := baseURL() + "mpesa/c2b/v1/registerurl"
-	fmt.Println(newReq(url, body, headers))
-	return nil
+	return c.do(req, v)
 }
 
-func C2BSimulate() error {
-	body, err := json.Marshal(C2B{
-		ShortCode:     600576,
-		CommandID:     "CustomerPayBillOnline",
-		Amount:        2,
-		Msisdn:        "254708374149",
-		BillRefNumber: "hkjhjkhjkh"})
+// do sends the request and decodes the response into v, or returns the
+// Daraja error of an unsuccessful response.
+func (c *Client) do(req *http.Request, v interface{}) error {
+	res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
-	auth, err := accessToken()
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return err
 	}
 
-	headers := make(map[string]string)
-	headers["Content-Type"] = "application/json"
-	headers["Authorization"] = "Bearer " + auth
+	if res.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: res.StatusCode}
+		if err := json.Unmarshal(body, apiErr); err != nil || apiErr.ErrorMessage == "" {
+			apiErr.ErrorMessage = strings.TrimSpace(string(body))
+		}
+		return apiErr
+	}
 
-	url := baseURL() + "mpesa/c2b/v1/simulate"
-	fmt.Println(newReq(url, body, headers))
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("mpesa: decoding response of %s: %w", req.URL.Path, err)
+	}
 	return nil
 }
 
-func newReq(url string, body []byte, headers map[string]string) (string, error) {
-	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
-	if err != nil {
-		return "", nil
+// FormatPhone returns a Kenyan phone number in the 2547XXXXXXXX format M-Pesa
+// expects. Numbers may start with +254, 254 or 0.
+func FormatPhone(phone string) (string, error) {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	switch {
+	case strings.HasPrefix(phone, "+254"):
+		phone = phone[1:]
+	case strings.HasPrefix(phone, "0"):
+		phone = "254" + phone[1:]
 	}
 
-	for key, value := range headers {
-		request.Header.Set(key, value)
+	if len(phone) != 12 || !strings.HasPrefix(phone, "254") {
+		return "", fmt.Errorf("mpesa: invalid phone number %q", phone)
 	}
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(request)
-	if res != nil {
-		defer res.Body.Close()
+	for _, r := range phone {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("mpesa: invalid phone number %q", phone)
+		}
 	}
+	return phone, nil
+}
+
+// RequestPayment sends an STK push and returns its CheckoutRequestID. It lets
+// the client serve as the payment gateway of the application.
+func (c *Client) RequestPayment(ctx context.Context, phone string, amount int, accountReference string, description string) (string, error) {
+	resp, err := c.STKPush(ctx, phone, amount, accountReference, description)
 	if err != nil {
 		return "", err
 	}
+	return resp.CheckoutRequestID, nil
+}
 
-	stringBody, err := ioutil.ReadAll(res.Body)
+// SendPayout pays amount to the phone number with a B2C payment and returns
+// its ConversationID. It lets the client serve as the payout gateway of the
+// application.
+func (c *Client) SendPayout(ctx context.Context, phone string, amount int, remarks string) (string, error) {
+	resp, err := c.B2C(ctx, phone, amount, remarks, "")
 	if err != nil {
 		return "", err
 	}
-
-	return string(stringBody), nil
-}
-
-func baseURL() string {
-	return "https://sandbox.safaricom.co.ke/"
+	return resp.ConversationID, nil
 }
@@ -0,0 +1,140 @@
+package mpesa_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/payments/mpesa/mpesatest"
+)
+
+func TestSTKPush(t *testing.T) {
+	fake := mpesatest.NewServer()
+	defer fake.Close()
+	client := mpesa.NewClient(fake.Config())
+
+	resp, err := client.STKPush(context.Background(), "0712345678", 999, "SUB-1", "Monthly subscription")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CheckoutRequestID == "" {
+		t.Error("no checkout request ID")
+	}
+
+	if len(fake.STKPushes) != 1 {
+		t.Fatalf("%d STK pushes, want 1", len(fake.STKPushes))
+	}
+	req := fake.STKPushes[0]
+	if req.PhoneNumber != "254712345678" || req.PartyA != "254712345678" || req.Amount != 999 {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if req.TransactionType != mpesa.CustomerPayBillOnline || req.CallBackURL != fake.Config().CallbackURL {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	query, err := client.STKQuery(context.Background(), resp.CheckoutRequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !query.Paid() {
+		t.Errorf("query %+v not paid", query)
+	}
+
+	fake.STKResultCode = "1032"
+	if query, err = client.STKQuery(context.Background(), resp.CheckoutRequestID); err != nil {
+		t.Fatal(err)
+	} else if query.Paid() {
+		t.Errorf("cancelled query %+v paid", query)
+	}
+}
+
+func TestTokenCaching(t *testing.T) {
+	fake := mpesatest.NewServer()
+	defer fake.Close()
+	client := mpesa.NewClient(fake.Config())
+	now := time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC)
+	client.Now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := client.RegisterC2BURLs(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.TokenRequests != 1 {
+		t.Errorf("%d token requests, want 1", fake.TokenRequests)
+	}
+
+	// A token is replaced shortly before it expires.
+	now = now.Add(time.Duration(fake.TokenExpiresIn)*time.Second - 30*time.Second)
+	if _, err := client.SimulateC2B(ctx, "+254708374149", 10, "ref"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.TokenRequests != 2 {
+		t.Errorf("%d token requests, want 2", fake.TokenRequests)
+	}
+}
+
+func TestB2C(t *testing.T) {
+	fake := mpesatest.NewServer()
+	defer fake.Close()
+	client := mpesa.NewClient(fake.Config())
+
+	resp, err := client.B2C(context.Background(), "254708374149", 500, "Payout", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ConversationID == "" {
+		t.Error("no conversation ID")
+	}
+	if len(fake.B2CPayments) != 1 || fake.B2CPayments[0].PartyB != "254708374149" || fake.B2CPayments[0].Amount != 500 {
+		t.Errorf("unexpected payments %+v", fake.B2CPayments)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fake := mpesatest.NewServer()
+	defer fake.Close()
+
+	cfg := fake.Config()
+	cfg.ConsumerSecret = "wrong"
+	_, err := mpesa.NewClient(cfg).RegisterC2BURLs(context.Background())
+	var apiErr *mpesa.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Errorf("bad credentials: err=%v", err)
+	}
+
+	cfg = fake.Config()
+	cfg.PassKey = "wrong"
+	_, err = mpesa.NewClient(cfg).STKPush(context.Background(), "0712345678", 1, "ref", "desc")
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != "400.002.02" {
+		t.Errorf("bad pass key: err=%v", err)
+	}
+
+	if _, err := mpesa.NewClient(fake.Config()).STKPush(context.Background(), "12345", 1, "ref", "desc"); err == nil {
+		t.Error("invalid phone accepted")
+	}
+}
+
+func TestFormatPhone(t *testing.T) {
+	for _, tt := range []struct {
+		phone string
+		want  string
+		ok    bool
+	}{
+		{"+254712345678", "254712345678", true},
+		{"254712345678", "254712345678", true},
+		{"0712 345 678", "254712345678", true},
+		{"0112345678", "254112345678", true},
+		{"071234567", "", false},
+		{"+255712345678", "", false},
+		{"07123456ab", "", false},
+	} {
+		got, err := mpesa.FormatPhone(tt.phone)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("FormatPhone(%q)=%q, %v, want %q", tt.phone, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Package mpesatest provides a fake Daraja server for testing payment flows
+// offline.
+package mpesatest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+)
+
+// Credentials the fake accepts by default.
+const (
+	ConsumerKey    = "test-key"
+	ConsumerSecret = "test-secret"
+	ShortCode      = "174379"
+	PassKey        = "test-passkey"
+)
+
+// Server is a fake Daraja API. It checks credentials and STK passwords,
+// records the requests it receives and answers them successfully.
+type Server struct {
+	*httptest.Server
+
+	// TokenExpiresIn is the lifetime in seconds of the tokens issued.
+	TokenExpiresIn int
+	// STKResultCode is the result STK queries report, "0" meaning paid.
+	STKResultCode string
+
+	mu            sync.Mutex
+	tokens        map[string]bool
+	TokenRequests int
+	STKPushes     []mpesa.STKPushRequest
+	STKQueries    []mpesa.STKQueryRequest
+	C2BRegisters  []mpesa.C2BRegisterRequest
+	C2BSimulates  []mpesa.C2BSimulateRequest
+	B2CPayments   []mpesa.B2CRequest
+}
+
+// NewServer starts a fake Daraja server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		TokenExpiresIn: 3599,
+		STKResultCode:  "0",
+		tokens:         make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/v1/generate", s.handleToken)
+	mux.HandleFunc("/mpesa/stkpush/v1/processrequest", s.authorized(s.handleSTKPush))
+	mux.HandleFunc("/mpesa/stkpushquery/v1/query", s.authorized(s.handleSTKQuery))
+	mux.HandleFunc("/mpesa/c2b/v1/registerurl", s.authorized(s.handleC2BRegister))
+	mux.HandleFunc("/mpesa/c2b/v1/simulate", s.authorized(s.handleC2BSimulate))
+	mux.HandleFunc("/mpesa/b2c/v1/paymentrequest", s.authorized(s.handleB2C))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a client configuration pointing at the fake.
+func (s *Server) Config() mpesa.Config {
+	return mpesa.Config{
+		BaseURL:            s.URL,
+		ConsumerKey:        ConsumerKey,
+		ConsumerSecret:     ConsumerSecret,
+		ShortCode:          ShortCode,
+		PassKey:            PassKey,
+		CallbackURL:        "https://example.com/payments/mpesa/stk",
+		C2BShortCode:       "600979",
+		ConfirmationURL:    "https://example.com/payments/mpesa/c2b/confirm",
+		ValidationURL:      "https://example.com/payments/mpesa/c2b/validate",
+		B2CShortCode:       "600000",
+		InitiatorName:      "testapi",
+		SecurityCredential: "credential",
+		B2CResultURL:       "https://example.com/payments/mpesa/b2c/result",
+		B2CTimeoutURL:      "https://example.com/payments/mpesa/b2c/timeout",
+	}
+}
+
+// ExpireTokens invalidates every token issued so far.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	key, secret, ok := r.BasicAuth()
+	if !ok || key != ConsumerKey || secret != ConsumerSecret {
+		writeError(w, http.StatusBadRequest, "400.008.01", "Invalid Authentication passed")
+		return
+	}
+
+	s.mu.Lock()
+	s.TokenRequests++
+	token := fmt.Sprintf("token-%d", s.TokenRequests)
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]string{
+		"access_token": token,
+		"expires_in":   strconv.Itoa(s.TokenExpiresIn),
+	})
+}
+
+// authorized rejects requests without a valid access token.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := s.tokens[token]
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "404.001.03", "Invalid Access Token")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleSTKPush(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.STKPushRequest
+	if !decode(w, r, &req) || !checkPassword(w, req.BusinessShortCode, req.Password, req.Timestamp) {
+		return
+	}
+
+	s.mu.Lock()
+	s.STKPushes = append(s.STKPushes, req)
+	n := len(s.STKPushes)
+	s.mu.Unlock()
+
+	writeJSON(w, mpesa.STKPushResponse{
+		MerchantRequestID:   fmt.Sprintf("merchant-%d", n),
+		CheckoutRequestID:   fmt.Sprintf("ws_CO_%d", n),
+		ResponseCode:        "0",
+		ResponseDescription: "Success. Request accepted for processing",
+		CustomerMessage:     "Success. Request accepted for processing",
+	})
+}
+
+func (s *Server) handleSTKQuery(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.STKQueryRequest
+	if !decode(w, r, &req) || !checkPassword(w, req.BusinessShortCode, req.Password, req.Timestamp) {
+		return
+	}
+
+	s.mu.Lock()
+	s.STKQueries = append(s.STKQueries, req)
+	resultCode := s.STKResultCode
+	s.mu.Unlock()
+
+	resultDesc := "The service request is processed successfully."
+	if resultCode != "0" {
+		resultDesc = "Request cancelled by user"
+	}
+	writeJSON(w, mpesa.STKQueryResponse{
+		ResponseCode:        "0",
+		ResponseDescription: "The service request has been accepted successsfully",
+		MerchantRequestID:   "merchant",
+		CheckoutRequestID:   req.CheckoutRequestID,
+		ResultCode:          resultCode,
+		ResultDesc:          resultDesc,
+	})
+}
+
+func (s *Server) handleC2BRegister(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.C2BRegisterRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	s.C2BRegisters = append(s.C2BRegisters, req)
+	s.mu.Unlock()
+
+	writeJSON(w, mpesa.C2BResponse{
+		OriginatorConversationID: "register",
+		ResponseCode:             "0",
+		ResponseDescription:      "success",
+	})
+}
+
+func (s *Server) handleC2BSimulate(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.C2BSimulateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	s.C2BSimulates = append(s.C2BSimulates, req)
+	n := len(s.C2BSimulates)
+	s.mu.Unlock()
+
+	writeJSON(w, mpesa.C2BResponse{
+		OriginatorConversationID: fmt.Sprintf("simulate-%d", n),
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	})
+}
+
+func (s *Server) handleB2C(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.B2CRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	s.B2CPayments = append(s.B2CPayments, req)
+	n := len(s.B2CPayments)
+	s.mu.Unlock()
+
+	writeJSON(w, mpesa.B2CResponse{
+		ConversationID:           fmt.Sprintf("AG_%d", n),
+		OriginatorConversationID: fmt.Sprintf("b2c-%d", n),
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	})
+}
+
+// checkPassword rejects STK requests whose password does not match the short
+// code, pass key and timestamp.
+func checkPassword(w http.ResponseWriter, shortCode, password, timestamp string) bool {
+	want := base64.StdEncoding.EncodeToString([]byte(shortCode + PassKey + timestamp))
+	if shortCode != ShortCode || password != want {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Password")
+		return false
+	}
+	return true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "405.001.01", "Method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.01", "Invalid Request Payload")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(mpesa.Error{
+		RequestID:    "fake",
+		ErrorCode:    code,
+		ErrorMessage: message,
+	})
+}
@@ -1,16 +1,135 @@
 package mpesa
 
-type registerURL struct {
-	ShortCode       int
-	ResponseType    string
-	ConfirmationURL string
-	ValidationURL   string
-}
-
-type C2B struct {
-	ShortCode     int
-	CommandID     string
-	Amount        int
-	Msisdn        string
-	BillRefNumber string
+import "fmt"
+
+// Transaction types of STK push and C2B payments.
+const (
+	CustomerPayBillOnline  = "CustomerPayBillOnline"
+	CustomerBuyGoodsOnline = "CustomerBuyGoodsOnline"
+)
+
+// Command IDs of B2C payments.
+const (
+	BusinessPayment  = "BusinessPayment"
+	SalaryPayment    = "SalaryPayment"
+	PromotionPayment = "PromotionPayment"
+)
+
+// tokenResponse is the response of the OAuth endpoint. Daraja returns
+// expires_in as a string of seconds.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+}
+
+// STKPushRequest asks the customer to authorise a payment on their phone.
+type STKPushRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	TransactionType   string `json:"TransactionType"`
+	Amount            int    `json:"Amount"`
+	PartyA            string `json:"PartyA"`
+	PartyB            string `json:"PartyB"`
+	PhoneNumber       string `json:"PhoneNumber"`
+	CallBackURL       string `json:"CallBackURL"`
+	AccountReference  string `json:"AccountReference"`
+	TransactionDesc   string `json:"TransactionDesc"`
+}
+
+// STKPushResponse acknowledges an STK push. The outcome of the payment is
+// posted to the callback URL later, or can be looked up with STKQuery.
+type STKPushResponse struct {
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	CustomerMessage     string `json:"CustomerMessage"`
+}
+
+// STKQueryRequest looks up the outcome of an STK push.
+type STKQueryRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	CheckoutRequestID string `json:"CheckoutRequestID"`
+}
+
+// STKQueryResponse is the outcome of an STK push. ResultCode is "0" when the
+// customer paid.
+type STKQueryResponse struct {
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResultCode          string `json:"ResultCode"`
+	ResultDesc          string `json:"ResultDesc"`
+}
+
+// Paid reports whether the customer completed the payment.
+func (r *STKQueryResponse) Paid() bool {
+	return r.ResultCode == "0"
+}
+
+// C2BRegisterRequest registers the URLs C2B payments to a short code are
+// validated and confirmed on.
+type C2BRegisterRequest struct {
+	ShortCode       string `json:"ShortCode"`
+	ResponseType    string `json:"ResponseType"`
+	ConfirmationURL string `json:"ConfirmationURL"`
+	ValidationURL   string `json:"ValidationURL"`
+}
+
+// C2BResponse acknowledges a C2B request. Daraja misspells the conversation
+// ID field.
+type C2BResponse struct {
+	OriginatorConversationID string `json:"OriginatorCoversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// C2BSimulateRequest simulates a customer paying a short code. It is only
+// available in the sandbox.
+type C2BSimulateRequest struct {
+	ShortCode     string `json:"ShortCode"`
+	CommandID     string `json:"CommandID"`
+	Amount        int    `json:"Amount"`
+	Msisdn        string `json:"Msisdn"`
+	BillRefNumber string `json:"BillRefNumber"`
+}
+
+// B2CRequest pays money from the business to a customer.
+type B2CRequest struct {
+	InitiatorName      string `json:"InitiatorName"`
+	SecurityCredential string `json:"SecurityCredential"`
+	CommandID          string `json:"CommandID"`
+	Amount             int    `json:"Amount"`
+	PartyA             string `json:"PartyA"`
+	PartyB             string `json:"PartyB"`
+	Remarks            string `json:"Remarks"`
+	QueueTimeOutURL    string `json:"QueueTimeOutURL"`
+	ResultURL          string `json:"ResultURL"`
+	Occasion           string `json:"Occasion"`
+}
+
+// B2CResponse acknowledges a B2C payment. The result is posted to the result
+// URL later.
+type B2CResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// Error is an error returned by Daraja.
+type Error struct {
+	// HTTP status code of the response.
+	StatusCode   int    `json:"-"`
+	RequestID    string `json:"requestId"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mpesa: %d %s: %s", e.StatusCode, e.ErrorCode, e.ErrorMessage)
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/andrwkng/hudumaapp/database/sqlite"
+)
+
+type SubscriptionsCommand struct {
+	DB *sqlite.DB
+}
+
+func NewSubscriptionsCommand(db *sqlite.DB) *SubscriptionsCommand {
+	return &SubscriptionsCommand{
+		DB: db,
+	}
+}
+
+func (s *SubscriptionsCommand) Run(ctx context.Context, args []string) error {
+	var cmd string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "expire":
+		return s.expire(ctx)
+	default:
+		return fmt.Errorf("ServiceApp cli subscriptions %s: unknown command", cmd)
+	}
+}
+
+// expire ends the subscriptions whose end date has passed.
+func (s *SubscriptionsCommand) expire(ctx context.Context) error {
+	log.Println("expire")
+	n, err := sqlite.NewSubscriptionService(s.DB).ExpireSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("expired %d subscriptions", n)
+	return nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/andrwkng/hudumaapp/database/sqlite"
+)
+
+type UsersCommand struct {
+	DB *sqlite.DB
+}
+
+func NewUsersCommand(db *sqlite.DB) *UsersCommand {
+	return &UsersCommand{
+		DB: db,
+	}
+}
+
+func (u *UsersCommand) Run(ctx context.Context, args []string) error {
+	var cmd string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "rehash":
+		return u.rehash(ctx)
+	default:
+		return fmt.Errorf("ServiceApp cli users %s: unknown command", cmd)
+	}
+}
+
+// rehash hashes the passwords of users created before passwords were hashed.
+func (u *UsersCommand) rehash(ctx context.Context) error {
+	log.Println("rehash")
+	n, err := sqlite.NewUserService(u.DB).RehashPasswords(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("rehashed %d passwords", n)
+	return nil
+}
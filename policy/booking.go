@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/google/uuid"
+)
+
+// BookingService authorizes calls to a booking service. Methods without an
+// owner to check are passed through.
+type BookingService struct {
+	app.BookingService
+	auth *Authorizer
+}
+
+func NewBookingService(svc app.BookingService, auth *Authorizer) *BookingService {
+	return &BookingService{svc, auth}
+}
+
+func (s *BookingService) FindBookingByID(ctx context.Context, id uuid.UUID) (*app.Booking, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewBooking, id); err != nil {
+		return nil, err
+	}
+	return s.BookingService.FindBookingByID(ctx, id)
+}
+
+func (s *BookingService) FindProviderBookingByID(ctx context.Context, id uuid.UUID, userID string) (*app.ProviderBooking, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewProviderBooking, id); err != nil {
+		return nil, err
+	}
+	return s.BookingService.FindProviderBookingByID(ctx, id, userID)
+}
+
+func (s *BookingService) CheckIn(ctx context.Context, c *model.BookingCheckpoint) error {
+	if err := s.auth.AuthorizeBooking(ctx, VisitBooking, c.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.CheckIn(ctx, c)
+}
+
+func (s *BookingService) CheckOut(ctx context.Context, c *model.BookingCheckpoint) error {
+	if err := s.auth.AuthorizeBooking(ctx, VisitBooking, c.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.CheckOut(ctx, c)
+}
+
+func (s *BookingService) FindBookingVisit(ctx context.Context, id uuid.UUID) (*app.BookingVisit, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewBooking, id); err != nil {
+		return nil, err
+	}
+	return s.BookingService.FindBookingVisit(ctx, id)
+}
+
+func (s *BookingService) CompleteBooking(ctx context.Context, c *model.BookingCompletion) error {
+	if err := s.auth.AuthorizeBooking(ctx, CompleteBooking, c.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.CompleteBooking(ctx, c)
+}
+
+func (s *BookingService) CancelBooking(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	if err := s.auth.AuthorizeBooking(ctx, CancelBooking, c.BookingID); err != nil {
+		return nil, err
+	}
+	return s.BookingService.CancelBooking(ctx, c)
+}
+
+func (s *BookingService) QuoteCancellation(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	if err := s.auth.AuthorizeBooking(ctx, CancelBooking, c.BookingID); err != nil {
+		return nil, err
+	}
+	return s.BookingService.QuoteCancellation(ctx, c)
+}
+
+func (s *BookingService) TransitionBooking(ctx context.Context, t *model.BookingTransition) error {
+	action, ok := transitionActions[t.Status]
+	if !ok {
+		return app.Errorf(app.INVALID_ERR, "Unknown booking status %q.", t.Status)
+	}
+	if err := s.auth.AuthorizeBooking(ctx, action, t.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.TransitionBooking(ctx, t)
+}
+
+func (s *BookingService) ListBookingEvents(ctx context.Context, id uuid.UUID) ([]*app.BookingEvent, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewBookingEvents, id); err != nil {
+		return nil, err
+	}
+	return s.BookingService.ListBookingEvents(ctx, id)
+}
+
+func (s *BookingService) ProposeReschedule(ctx context.Context, p *model.RescheduleProposal) error {
+	if err := s.auth.AuthorizeBooking(ctx, RescheduleBooking, p.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.ProposeReschedule(ctx, p)
+}
+
+func (s *BookingService) DecideReschedule(ctx context.Context, d *model.RescheduleDecision) error {
+	if err := s.auth.AuthorizeBooking(ctx, RescheduleBooking, d.BookingID); err != nil {
+		return err
+	}
+	return s.BookingService.DecideReschedule(ctx, d)
+}
+
+func (s *BookingService) ListReschedules(ctx context.Context, id uuid.UUID) ([]*app.BookingReschedule, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewBooking, id); err != nil {
+		return nil, err
+	}
+	return s.BookingService.ListReschedules(ctx, id)
+}
+
+// BidService authorizes calls to a bid service. Methods without an owner to
+// check, or which are already scoped to the caller, are passed through.
+type BidService struct {
+	app.BidService
+	auth *Authorizer
+}
+
+func NewBidService(svc app.BidService, auth *Authorizer) *BidService {
+	return &BidService{svc, auth}
+}
+
+func (s *BidService) FindBidsByBookingID(ctx context.Context, bookingID string) ([]*app.Bid, error) {
+	id, err := uuid.Parse(bookingID)
+	if err != nil {
+		return nil, app.Errorf(app.INVALID_ERR, "Id is not a valid UUID")
+	}
+	if err := s.auth.AuthorizeBooking(ctx, ViewBids, id); err != nil {
+		return nil, err
+	}
+	return s.BidService.FindBidsByBookingID(ctx, bookingID)
+}
+
+func (s *BidService) UpdateBid(ctx context.Context, update *model.BidUpdate) error {
+	if err := s.auth.AuthorizeBid(ctx, ReviseBid, update.ID); err != nil {
+		return err
+	}
+	return s.BidService.UpdateBid(ctx, update)
+}
+
+func (s *BidService) WithdrawBid(ctx context.Context, bidID int) error {
+	if err := s.auth.AuthorizeBid(ctx, WithdrawBid, bidID); err != nil {
+		return err
+	}
+	return s.BidService.WithdrawBid(ctx, bidID)
+}
+
+func (s *BidService) AcceptBid(ctx context.Context, bidID int, actorID string) error {
+	if err := s.auth.AuthorizeBid(ctx, AcceptBid, bidID); err != nil {
+		return err
+	}
+	return s.BidService.AcceptBid(ctx, bidID, actorID)
+}
+
+// RequestService authorizes the changes a client makes to their requests.
+// Other methods are passed through.
+type RequestService struct {
+	app.RequestService
+	auth *Authorizer
+}
+
+func NewRequestService(svc app.RequestService, auth *Authorizer) *RequestService {
+	return &RequestService{svc, auth}
+}
+
+func (s *RequestService) UpdateRequest(ctx context.Context, request *model.Request) error {
+	if err := s.auth.AuthorizeBooking(ctx, EditRequest, request.ID); err != nil {
+		return err
+	}
+	return s.RequestService.UpdateRequest(ctx, request)
+}
+
+func (s *RequestService) CancelRequest(ctx context.Context, c *model.BookingCancellation) (*app.CancellationDecision, error) {
+	if err := s.auth.AuthorizeBooking(ctx, CancelRequest, c.BookingID); err != nil {
+		return nil, err
+	}
+	return s.RequestService.CancelRequest(ctx, c)
+}
+
+func (s *RequestService) ReopenRequest(ctx context.Context, id uuid.UUID, actorID string) error {
+	if err := s.auth.AuthorizeBooking(ctx, OpenBooking, id); err != nil {
+		return err
+	}
+	return s.RequestService.ReopenRequest(ctx, id, actorID)
+}
@@ -0,0 +1,34 @@
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+// CancellationPolicyService authorizes calls to a cancellation policy
+// service. Only administrators manage the policies of the platform; those of
+// providers are already scoped to the caller.
+type CancellationPolicyService struct {
+	app.CancellationPolicyService
+	auth *Authorizer
+}
+
+func NewCancellationPolicyService(svc app.CancellationPolicyService, auth *Authorizer) *CancellationPolicyService {
+	return &CancellationPolicyService{svc, auth}
+}
+
+func (s *CancellationPolicyService) ListCancellationPolicies(ctx context.Context) ([]*app.CancellationPolicy, error) {
+	if err := s.auth.AuthorizeAdmin(ctx, ManageCancellations); err != nil {
+		return nil, err
+	}
+	return s.CancellationPolicyService.ListCancellationPolicies(ctx)
+}
+
+func (s *CancellationPolicyService) SetCancellationPolicy(ctx context.Context, policy *model.CancellationPolicy) error {
+	if err := s.auth.AuthorizeAdmin(ctx, ManageCancellations); err != nil {
+		return err
+	}
+	return s.CancellationPolicyService.SetCancellationPolicy(ctx, policy)
+}
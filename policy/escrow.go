@@ -0,0 +1,26 @@
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/google/uuid"
+)
+
+// EscrowService authorizes calls to an escrow service. Only the parties to a
+// booking see its escrow; the rest is run by the payout scheduler.
+type EscrowService struct {
+	app.EscrowService
+	auth *Authorizer
+}
+
+func NewEscrowService(svc app.EscrowService, auth *Authorizer) *EscrowService {
+	return &EscrowService{svc, auth}
+}
+
+func (s *EscrowService) FindEscrowByBookingID(ctx context.Context, bookingID uuid.UUID) (*app.Escrow, error) {
+	if err := s.auth.AuthorizeBooking(ctx, ViewEscrow, bookingID); err != nil {
+		return nil, err
+	}
+	return s.EscrowService.FindEscrowByBookingID(ctx, bookingID)
+}
@@ -0,0 +1,49 @@
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+// PlanService authorizes calls to a plan service. Only administrators manage
+// plans or see the archived ones.
+type PlanService struct {
+	app.PlanService
+	auth *Authorizer
+}
+
+func NewPlanService(svc app.PlanService, auth *Authorizer) *PlanService {
+	return &PlanService{svc, auth}
+}
+
+func (s *PlanService) CreatePlan(ctx context.Context, plan *model.Plan) error {
+	if err := s.auth.AuthorizeAdmin(ctx, ManagePlans); err != nil {
+		return err
+	}
+	return s.PlanService.CreatePlan(ctx, plan)
+}
+
+func (s *PlanService) UpdatePlan(ctx context.Context, plan *model.Plan) error {
+	if err := s.auth.AuthorizeAdmin(ctx, ManagePlans); err != nil {
+		return err
+	}
+	return s.PlanService.UpdatePlan(ctx, plan)
+}
+
+func (s *PlanService) ArchivePlan(ctx context.Context, id string) error {
+	if err := s.auth.AuthorizeAdmin(ctx, ManagePlans); err != nil {
+		return err
+	}
+	return s.PlanService.ArchivePlan(ctx, id)
+}
+
+func (s *PlanService) ListPlans(ctx context.Context, includeArchived bool) ([]*app.Plan, error) {
+	if includeArchived {
+		if err := s.auth.AuthorizeAdmin(ctx, ManagePlans); err != nil {
+			return nil, err
+		}
+	}
+	return s.PlanService.ListPlans(ctx, includeArchived)
+}
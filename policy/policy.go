@@ -0,0 +1,182 @@
+// Package policy authorizes calls to the application services. Its types wrap
+// the app service interfaces and check that the logged in user is allowed to
+// act on a resource before handing the call on.
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/google/uuid"
+)
+
+// Role is the relation of a user to a resource.
+type Role string
+
+const (
+	// RoleClient is the user who made the booking or request.
+	RoleClient Role = "client"
+	// RoleProvider is the provider assigned to a booking, or who made a bid.
+	RoleProvider Role = "provider"
+	// RoleAdmin is any administrator.
+	RoleAdmin Role = "admin"
+)
+
+// Action is something a user can do to a resource.
+type Action string
+
+const (
+	ViewBooking         Action = "view this booking"
+	ViewProviderBooking Action = "view this booking as provider"
+	ViewBookingEvents   Action = "view the history of this booking"
+	ViewEscrow          Action = "view the payment of this booking"
+	OpenBooking         Action = "open this booking for bidding"
+	AcceptBooking       Action = "accept this booking"
+	StartBooking        Action = "start this booking"
+	VisitBooking        Action = "check in and out of this booking"
+	CompleteBooking     Action = "complete this booking"
+	CancelBooking       Action = "cancel this booking"
+	DisputeBooking      Action = "dispute this booking"
+	RescheduleBooking   Action = "reschedule this booking"
+	EditRequest         Action = "edit this request"
+	CancelRequest       Action = "cancel this request"
+	ViewBids            Action = "view the bids on this request"
+	AcceptBid           Action = "accept this bid"
+	ReviseBid           Action = "revise this bid"
+	WithdrawBid         Action = "withdraw this bid"
+	ManagePlans         Action = "manage subscription plans"
+	ManageCommissions   Action = "manage commission rates"
+	ManageCancellations Action = "manage cancellation policies"
+)
+
+// rules lists the roles allowed to take each action.
+var rules = map[Action][]Role{
+	ViewBooking:         {RoleClient, RoleProvider, RoleAdmin},
+	ViewProviderBooking: {RoleProvider, RoleAdmin},
+	ViewBookingEvents:   {RoleClient, RoleProvider, RoleAdmin},
+	ViewEscrow:          {RoleClient, RoleProvider, RoleAdmin},
+	OpenBooking:         {RoleClient, RoleAdmin},
+	AcceptBooking:       {RoleProvider, RoleAdmin},
+	StartBooking:        {RoleProvider, RoleAdmin},
+	VisitBooking:        {RoleProvider},
+	CompleteBooking:     {RoleClient, RoleProvider, RoleAdmin},
+	CancelBooking:       {RoleClient, RoleProvider, RoleAdmin},
+	DisputeBooking:      {RoleClient, RoleProvider, RoleAdmin},
+	RescheduleBooking:   {RoleClient, RoleProvider},
+	EditRequest:         {RoleClient, RoleAdmin},
+	CancelRequest:       {RoleClient, RoleAdmin},
+	ViewBids:            {RoleClient, RoleAdmin},
+	AcceptBid:           {RoleClient, RoleAdmin},
+	ReviseBid:           {RoleProvider},
+	WithdrawBid:         {RoleProvider, RoleAdmin},
+	ManagePlans:         {RoleAdmin},
+	ManageCommissions:   {RoleAdmin},
+	ManageCancellations: {RoleAdmin},
+}
+
+// transitionActions maps the status a booking is moved to onto the action
+// being taken.
+var transitionActions = map[string]Action{
+	app.BookingBidding:    OpenBooking,
+	app.BookingAccepted:   AcceptBooking,
+	app.BookingInProgress: StartBooking,
+	app.BookingCompleted:  CompleteBooking,
+	app.BookingCancelled:  CancelBooking,
+	app.BookingDisputed:   DisputeBooking,
+}
+
+// Allowed reports whether a user holding the given roles may take an action.
+func Allowed(action Action, roles []Role) bool {
+	for _, allowed := range rules[action] {
+		for _, role := range roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorizer resolves the roles of the logged in user on a resource and checks
+// them against the rules.
+type Authorizer struct {
+	ownership app.OwnershipService
+}
+
+func NewAuthorizer(ownership app.OwnershipService) *Authorizer {
+	return &Authorizer{ownership}
+}
+
+// AuthorizeBooking returns an error unless the logged in user may take the
+// action on the booking.
+func (a *Authorizer) AuthorizeBooking(ctx context.Context, action Action, bookingID uuid.UUID) error {
+	userID, err := middlewares.UserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	parties, err := a.ownership.FindBookingParties(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	var roles []Role
+	if parties.ClientID == userID.String() {
+		roles = append(roles, RoleClient)
+	}
+	if parties.ProviderUserID != nil && *parties.ProviderUserID == userID.String() {
+		roles = append(roles, RoleProvider)
+	}
+	return a.authorize(ctx, userID, action, roles)
+}
+
+// AuthorizeBid returns an error unless the logged in user may take the action
+// on the bid.
+func (a *Authorizer) AuthorizeBid(ctx context.Context, action Action, bidID int) error {
+	userID, err := middlewares.UserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	parties, err := a.ownership.FindBidParties(ctx, bidID)
+	if err != nil {
+		return err
+	}
+
+	var roles []Role
+	if parties.ClientID == userID.String() {
+		roles = append(roles, RoleClient)
+	}
+	if parties.BidderID == userID.String() {
+		roles = append(roles, RoleProvider)
+	}
+	return a.authorize(ctx, userID, action, roles)
+}
+
+// AuthorizeAdmin returns an error unless the logged in user may take an action
+// that is not tied to a resource they could own.
+func (a *Authorizer) AuthorizeAdmin(ctx context.Context, action Action) error {
+	userID, err := middlewares.UserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return a.authorize(ctx, userID, action, nil)
+}
+
+// authorize checks the roles against the action, looking up whether the user
+// is an administrator only when their other roles do not suffice.
+func (a *Authorizer) authorize(ctx context.Context, userID app.UserID, action Action, roles []Role) error {
+	if Allowed(action, roles) {
+		return nil
+	}
+
+	isAdmin, err := a.ownership.IsAdmin(ctx, userID.String())
+	if err != nil {
+		return err
+	}
+	if isAdmin && Allowed(action, []Role{RoleAdmin}) {
+		return nil
+	}
+	return app.Errorf(app.FORBIDDEN_ERR, "You are not allowed to %s.", action)
+}
@@ -0,0 +1,206 @@
+package policy_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/policy"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/google/uuid"
+)
+
+func TestAllowed(t *testing.T) {
+	for _, tt := range []struct {
+		action policy.Action
+		roles  []policy.Role
+		want   bool
+	}{
+		{policy.ViewBooking, []policy.Role{policy.RoleClient}, true},
+		{policy.ViewBooking, []policy.Role{policy.RoleProvider}, true},
+		{policy.ViewBooking, nil, false},
+		{policy.ViewProviderBooking, []policy.Role{policy.RoleClient}, false},
+		{policy.AcceptBooking, []policy.Role{policy.RoleClient}, false},
+		{policy.AcceptBooking, []policy.Role{policy.RoleProvider}, true},
+		{policy.StartBooking, []policy.Role{policy.RoleClient}, false},
+		{policy.VisitBooking, []policy.Role{policy.RoleAdmin}, false},
+		{policy.CompleteBooking, []policy.Role{policy.RoleClient}, true},
+		{policy.CancelBooking, []policy.Role{policy.RoleProvider}, true},
+		{policy.DisputeBooking, []policy.Role{policy.RoleClient}, true},
+		{policy.OpenBooking, []policy.Role{policy.RoleProvider}, false},
+		{policy.RescheduleBooking, []policy.Role{policy.RoleProvider}, true},
+		{policy.RescheduleBooking, []policy.Role{policy.RoleAdmin}, false},
+		{policy.EditRequest, []policy.Role{policy.RoleClient}, true},
+		{policy.CancelRequest, []policy.Role{policy.RoleProvider}, false},
+		{policy.ViewBids, []policy.Role{policy.RoleProvider}, false},
+		{policy.AcceptBid, []policy.Role{policy.RoleProvider}, false},
+		{policy.AcceptBid, []policy.Role{policy.RoleClient}, true},
+		{policy.AcceptBid, []policy.Role{policy.RoleProvider, policy.RoleAdmin}, true},
+		{policy.ReviseBid, []policy.Role{policy.RoleProvider}, true},
+		{policy.ReviseBid, []policy.Role{policy.RoleClient, policy.RoleAdmin}, false},
+		{policy.WithdrawBid, []policy.Role{policy.RoleClient}, false},
+		{policy.ManagePlans, []policy.Role{policy.RoleClient, policy.RoleProvider}, false},
+		{policy.ManagePlans, []policy.Role{policy.RoleAdmin}, true},
+		{policy.ManageCancellations, []policy.Role{policy.RoleProvider}, false},
+		{policy.Action("unknown"), []policy.Role{policy.RoleAdmin}, false},
+	} {
+		if got := policy.Allowed(tt.action, tt.roles); got != tt.want {
+			t.Errorf("Allowed(%q, %v)=%v, want %v", tt.action, tt.roles, got, tt.want)
+		}
+	}
+}
+
+const (
+	clientID   = "client"
+	providerID = "provider"
+	bidderID   = "bidder"
+	adminID    = "admin"
+	strangerID = "stranger"
+)
+
+var (
+	assignedBooking = uuid.MustParse("5a1c3a56-7f1a-4e0e-9a55-0b0cdd4d61b1")
+	openBooking     = uuid.MustParse("0b9a9a3e-4d0c-4a39-bd3a-5d2c4f5e1a20")
+	missingBooking  = uuid.MustParse("f3b8a1d2-2c4e-4b5f-8a6d-9e0f1a2b3c4d")
+)
+
+func TestBookingService(t *testing.T) {
+	svc := policy.NewBookingService(&bookingService{}, policy.NewAuthorizer(&ownershipService{}))
+
+	for _, tt := range []struct {
+		name    string
+		userID  string
+		booking uuid.UUID
+		status  string
+		code    string
+	}{
+		{"ClientCancels", clientID, assignedBooking, app.BookingCancelled, ""},
+		{"ProviderCancels", providerID, assignedBooking, app.BookingCancelled, ""},
+		{"StrangerCancels", strangerID, assignedBooking, app.BookingCancelled, app.FORBIDDEN_ERR},
+		{"AdminCancels", adminID, assignedBooking, app.BookingCancelled, ""},
+		{"ProviderStarts", providerID, assignedBooking, app.BookingInProgress, ""},
+		{"ClientStarts", clientID, assignedBooking, app.BookingInProgress, app.FORBIDDEN_ERR},
+		{"ClientCompletes", clientID, assignedBooking, app.BookingCompleted, ""},
+		{"StrangerCompletes", strangerID, assignedBooking, app.BookingCompleted, app.FORBIDDEN_ERR},
+		{"UnassignedProviderAccepts", providerID, openBooking, app.BookingAccepted, app.FORBIDDEN_ERR},
+		{"ClientDisputes", clientID, assignedBooking, app.BookingDisputed, ""},
+		{"LoggedOut", "", assignedBooking, app.BookingCancelled, app.UNAUTHORIZED_ERR},
+		{"UnknownStatus", clientID, assignedBooking, "paused", app.INVALID_ERR},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.TransitionBooking(contextWithUser(tt.userID), &model.BookingTransition{
+				BookingID: tt.booking,
+				Status:    tt.status,
+				ActorID:   tt.userID,
+			})
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("code=%q, want %q (err=%v)", code, tt.code, err)
+			}
+		})
+	}
+
+	t.Run("ViewAsStranger", func(t *testing.T) {
+		if _, err := svc.FindBookingByID(contextWithUser(strangerID), assignedBooking); errorCode(err) != app.FORBIDDEN_ERR {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ViewAsProvider", func(t *testing.T) {
+		if _, err := svc.FindBookingByID(contextWithUser(providerID), assignedBooking); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ViewMissing", func(t *testing.T) {
+		if _, err := svc.FindBookingByID(contextWithUser(clientID), missingBooking); err != sql.ErrNoRows {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBidService(t *testing.T) {
+	svc := policy.NewBidService(&bidService{}, policy.NewAuthorizer(&ownershipService{}))
+
+	for _, tt := range []struct {
+		name   string
+		userID string
+		code   string
+	}{
+		{"Client", clientID, ""},
+		{"Admin", adminID, ""},
+		{"Bidder", bidderID, app.FORBIDDEN_ERR},
+		{"Stranger", strangerID, app.FORBIDDEN_ERR},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.AcceptBid(contextWithUser(tt.userID), 1, tt.userID)
+			if code := errorCode(err); code != tt.code {
+				t.Fatalf("code=%q, want %q (err=%v)", code, tt.code, err)
+			}
+		})
+	}
+}
+
+// contextWithUser returns a context with the given user logged in. An empty
+// user ID returns a context with nobody logged in.
+func contextWithUser(userID string) context.Context {
+	if userID == "" {
+		return context.Background()
+	}
+	return middlewares.NewContextWithUser(context.Background(), app.AuthUser{ID: userID})
+}
+
+// errorCode returns the application error code of err, if any.
+func errorCode(err error) string {
+	var e *app.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+type ownershipService struct{}
+
+func (s *ownershipService) FindBookingParties(ctx context.Context, id uuid.UUID) (*app.BookingParties, error) {
+	switch id {
+	case assignedBooking:
+		provider := providerID
+		return &app.BookingParties{BookingID: id, ClientID: clientID, ProviderUserID: &provider}, nil
+	case openBooking:
+		return &app.BookingParties{BookingID: id, ClientID: clientID}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *ownershipService) FindBidParties(ctx context.Context, id int) (*app.BidParties, error) {
+	return &app.BidParties{BidID: id, BookingID: openBooking, ClientID: clientID, BidderID: bidderID}, nil
+}
+
+func (s *ownershipService) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	return userID == adminID, nil
+}
+
+type bookingService struct {
+	app.BookingService
+}
+
+func (s *bookingService) FindBookingByID(ctx context.Context, id uuid.UUID) (*app.Booking, error) {
+	return &app.Booking{ID: id}, nil
+}
+
+func (s *bookingService) TransitionBooking(ctx context.Context, t *model.BookingTransition) error {
+	return nil
+}
+
+type bidService struct {
+	app.BidService
+}
+
+func (s *bidService) AcceptBid(ctx context.Context, bidID int, actorID string) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package policy
+
+import (
+	"context"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+)
+
+// WalletService authorizes calls to a wallet service. Only administrators
+// manage commission rates; wallets and payouts are already scoped to the
+// caller.
+type WalletService struct {
+	app.WalletService
+	auth *Authorizer
+}
+
+func NewWalletService(svc app.WalletService, auth *Authorizer) *WalletService {
+	return &WalletService{svc, auth}
+}
+
+func (s *WalletService) ListCommissionRates(ctx context.Context) ([]*app.CommissionRate, error) {
+	if err := s.auth.AuthorizeAdmin(ctx, ManageCommissions); err != nil {
+		return nil, err
+	}
+	return s.WalletService.ListCommissionRates(ctx)
+}
+
+func (s *WalletService) SetCommissionRate(ctx context.Context, rate *model.CommissionRate) error {
+	if err := s.auth.AuthorizeAdmin(ctx, ManageCommissions); err != nil {
+		return err
+	}
+	return s.WalletService.SetCommissionRate(ctx, rate)
+}
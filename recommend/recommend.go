@@ -0,0 +1,211 @@
+// Package recommend ranks the requests open for bidding for a provider.
+//
+// Every request is scored out of 100 on a few factors, each worth a share of
+// the score. The factors are returned with the score so the app can explain
+// why a request was recommended.
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+// Weights are the points each factor is worth. They add up to 100.
+type Weights struct {
+	Distance float64
+	Category float64
+	Services float64
+	Urgency  float64
+	Recency  float64
+	Client   float64
+	History  float64
+}
+
+var DefaultWeights = Weights{
+	Distance: 25,
+	Category: 20,
+	Services: 15,
+	Urgency:  10,
+	Recency:  10,
+	Client:   10,
+	History:  10,
+}
+
+const (
+	// distanceHalf is the distance at which a request earns half of the
+	// distance points.
+	distanceHalf = 10.0
+	// recencyHalfLife is the age at which a request earns half of the
+	// recency points.
+	recencyHalfLife = 48 * time.Hour
+	// minCategoryBids is how many decided bids in the category of a request
+	// a provider needs before their history in it is used instead of their
+	// overall history.
+	minCategoryBids = 3
+)
+
+// Service recommends requests from the candidates of a request service.
+type Service struct {
+	Requests app.RequestService
+	Weights  Weights
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+func NewService(requests app.RequestService) *Service {
+	return &Service{
+		Requests: requests,
+		Weights:  DefaultWeights,
+		Now:      time.Now,
+	}
+}
+
+func (s *Service) RecommendRequests(ctx context.Context, userID string) ([]*app.RecommendedRequest, error) {
+	profile, candidates, err := s.Requests.ListRequestCandidates(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return Rank(candidates, profile, s.Weights, s.Now()), nil
+}
+
+// Rank scores candidates and returns them best first. Requests with equal
+// scores are ordered newest first.
+func Rank(candidates []*app.RequestCandidate, profile *app.ProviderProfile, w Weights, now time.Time) []*app.RecommendedRequest {
+	type ranked struct {
+		request   *app.RecommendedRequest
+		createdAt time.Time
+	}
+	results := make([]ranked, 0, len(candidates))
+	for _, c := range candidates {
+		results = append(results, ranked{Score(c, profile, w, now), c.CreatedAt})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].request.Score != results[j].request.Score {
+			return results[i].request.Score > results[j].request.Score
+		}
+		return results[i].createdAt.After(results[j].createdAt)
+	})
+
+	requests := make([]*app.RecommendedRequest, 0, len(results))
+	for _, r := range results {
+		requests = append(requests, r.request)
+	}
+	return requests
+}
+
+// Score scores a candidate for a provider.
+func Score(c *app.RequestCandidate, profile *app.ProviderProfile, w Weights, now time.Time) *app.RecommendedRequest {
+	factors := []*app.ScoreFactor{
+		factor("distance", w.Distance)(distanceValue(c)),
+		factor("category", w.Category)(categoryValue(c, profile)),
+		factor("services", w.Services)(servicesValue(c)),
+		factor("urgency", w.Urgency)(urgencyValue(c)),
+		factor("recency", w.Recency)(recencyValue(c, now)),
+		factor("client", w.Client)(clientValue(c)),
+		factor("history", w.History)(historyValue(c, profile)),
+	}
+
+	score := 0.0
+	for _, f := range factors {
+		score += f.Points
+	}
+	return &app.RecommendedRequest{
+		AllRequest: c.Request,
+		Score:      round(score),
+		Factors:    factors,
+	}
+}
+
+// factor returns a function making a factor that earns value, from 0 to 1,
+// of weight points.
+func factor(name string, weight float64) func(value float64, reason string) *app.ScoreFactor {
+	return func(value float64, reason string) *app.ScoreFactor {
+		return &app.ScoreFactor{
+			Name:   name,
+			Weight: weight,
+			Points: round(weight * value),
+			Reason: reason,
+		}
+	}
+}
+
+func distanceValue(c *app.RequestCandidate) (float64, string) {
+	if c.DistanceKm == nil {
+		return 0.5, "Distance is unknown."
+	}
+	return distanceHalf / (distanceHalf + *c.DistanceKm), fmt.Sprintf("%.1f km away.", *c.DistanceKm)
+}
+
+func categoryValue(c *app.RequestCandidate, profile *app.ProviderProfile) (float64, string) {
+	switch {
+	case !profile.HasCategory:
+		return 0.5, "You have not chosen a category."
+	case c.CategoryDepth == nil:
+		return 0, "Not in your category."
+	case *c.CategoryDepth == 0:
+		return 1, "In your category."
+	default:
+		return math.Max(0.5, 1-0.25*float64(*c.CategoryDepth)), "In a category under yours."
+	}
+}
+
+func servicesValue(c *app.RequestCandidate) (float64, string) {
+	if c.ServiceMatch {
+		return 1, "You offer a service in this category."
+	}
+	return 0, "You offer no service in this category."
+}
+
+func urgencyValue(c *app.RequestCandidate) (float64, string) {
+	if c.Request.Urgent {
+		return 1, "Urgent."
+	}
+	return 0, "Not urgent."
+}
+
+func recencyValue(c *app.RequestCandidate, now time.Time) (float64, string) {
+	age := now.Sub(c.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	value := math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+	if age < time.Hour {
+		return value, "Posted less than an hour ago."
+	}
+	if age < 48*time.Hour {
+		return value, fmt.Sprintf("Posted %d hours ago.", int(age.Hours()))
+	}
+	return value, fmt.Sprintf("Posted %d days ago.", int(age.Hours()/24))
+}
+
+func clientValue(c *app.RequestCandidate) (float64, string) {
+	if c.ClientReviews == 0 {
+		return 0.5, "The client has not reviewed a provider yet."
+	}
+	return math.Min(c.ClientRating/5, 1), fmt.Sprintf("The client rates providers %.1f out of 5 on average over %d reviews.", c.ClientRating, c.ClientReviews)
+}
+
+// historyValue is the share of the provider's decided bids that were
+// accepted, in the category of the request if they have bid enough in it.
+// One accepted and one rejected bid are assumed so that a short history does
+// not count for too much.
+func historyValue(c *app.RequestCandidate, profile *app.ProviderProfile) (float64, string) {
+	accepted, decided, where := profile.BidsAccepted, profile.BidsDecided, ""
+	if c.BidsDecided >= minCategoryBids {
+		accepted, decided, where = c.BidsAccepted, c.BidsDecided, " in this category"
+	}
+	if decided == 0 {
+		return 0.5, "You have no accepted or rejected bids yet."
+	}
+	value := float64(accepted+1) / float64(decided+2)
+	return value, fmt.Sprintf("%d of your %d decided bids%s were accepted.", accepted, decided, where)
+}
+
+func round(f float64) float64 {
+	return math.Round(f*10) / 10
+}
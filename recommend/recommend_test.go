@@ -0,0 +1,86 @@
+package recommend_test
+
+import (
+	"testing"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/recommend"
+)
+
+var now = time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func intPtr(i int) *int           { return &i }
+func floatPtr(f float64) *float64 { return &f }
+
+func TestRank(t *testing.T) {
+	profile := &app.ProviderProfile{HasCategory: true, BidsAccepted: 1, BidsDecided: 4}
+	candidates := []*app.RequestCandidate{
+		{
+			Request:    app.AllRequest{Title: "far and old"},
+			CreatedAt:  now.Add(-10 * 24 * time.Hour),
+			DistanceKm: floatPtr(90),
+		},
+		{
+			Request:       app.AllRequest{Title: "near in category", Urgent: true},
+			CreatedAt:     now.Add(-2 * time.Hour),
+			DistanceKm:    floatPtr(2),
+			CategoryDepth: intPtr(0),
+			ServiceMatch:  true,
+			ClientReviews: 2,
+			ClientRating:  4.5,
+		},
+		{
+			Request:       app.AllRequest{Title: "subcategory"},
+			CreatedAt:     now.Add(-2 * time.Hour),
+			DistanceKm:    floatPtr(2),
+			CategoryDepth: intPtr(1),
+		},
+	}
+
+	ranked := recommend.Rank(candidates, profile, recommend.DefaultWeights, now)
+	for i, want := range []string{"near in category", "subcategory", "far and old"} {
+		if ranked[i].Title != want {
+			t.Errorf("ranked[%d]=%q, want %q", i, ranked[i].Title, want)
+		}
+	}
+
+	best := ranked[0]
+	if best.Score <= 0 || best.Score > 100 {
+		t.Fatalf("score %v out of range", best.Score)
+	}
+	total := 0.0
+	for _, f := range best.Factors {
+		if f.Points > f.Weight {
+			t.Errorf("factor %s earned %v of %v points", f.Name, f.Points, f.Weight)
+		}
+		if f.Reason == "" {
+			t.Errorf("factor %s has no reason", f.Name)
+		}
+		total += f.Points
+	}
+	if diff := total - best.Score; diff > 0.1 || diff < -0.1 {
+		t.Errorf("factors add up to %v, score is %v", total, best.Score)
+	}
+}
+
+func TestScoreHistory(t *testing.T) {
+	profile := &app.ProviderProfile{BidsAccepted: 0, BidsDecided: 8}
+	for _, tt := range []struct {
+		name      string
+		candidate *app.RequestCandidate
+		want      string
+	}{
+		{"Overall", &app.RequestCandidate{CreatedAt: now, BidsAccepted: 2, BidsDecided: 2}, "0 of your 8 decided bids were accepted."},
+		{"Category", &app.RequestCandidate{CreatedAt: now, BidsAccepted: 3, BidsDecided: 3}, "3 of your 3 decided bids in this category were accepted."},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			scored := recommend.Score(tt.candidate, profile, recommend.DefaultWeights, now)
+			for _, f := range scored.Factors {
+				if f.Name == "history" && f.Reason != tt.want {
+					t.Fatalf("reason=%q, want %q", f.Reason, tt.want)
+				}
+			}
+		})
+	}
+}
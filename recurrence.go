@@ -0,0 +1,232 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies.
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+// RecurrencePresets are the rules of the frequencies clients pick from. Other
+// schedules are given as rules.
+var RecurrencePresets = map[string]string{
+	"weekly":      "FREQ=WEEKLY",
+	"fortnightly": "FREQ=WEEKLY;INTERVAL=2",
+	"monthly":     "FREQ=MONTHLY",
+}
+
+// DefaultSeriesHorizon is how far ahead the bookings of a series are made.
+const DefaultSeriesHorizon = 28 * 24 * time.Hour
+
+// maxRecurrencePeriods bounds how many periods of a rule are looked at, so a
+// rule whose occurrences all fall on days that do not exist ends.
+const maxRecurrencePeriods = 5000
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// RecurrenceRule is a schedule of recurring bookings in a subset of the
+// iCalendar RRULE syntax, like FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10.
+// Occurrences start at the time of day of the first one. Weeks start on
+// Monday, and monthly occurrences on a day a month does not have are left
+// out.
+type RecurrenceRule struct {
+	Freq     string
+	Interval int
+	// ByDay are the days of the week weekly occurrences fall on. Without
+	// them occurrences fall on the weekday of the first one.
+	ByDay []time.Weekday
+	// Count is how many occurrences there are, or 0 for no limit.
+	Count int
+	// Until is the last time an occurrence may start at, or the zero time
+	// for no limit.
+	Until time.Time
+}
+
+// ParseRecurrenceRule parses a rule, with or without an RRULE: prefix.
+func ParseRecurrenceRule(s string) (*RecurrenceRule, error) {
+	r := &RecurrenceRule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, Errorf(INVALID_ERR, "rule: %q is not like NAME=VALUE.", part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		switch name {
+		case "FREQ":
+			if value != FreqDaily && value != FreqWeekly && value != FreqMonthly {
+				return nil, Errorf(INVALID_ERR, "rule: FREQ must be DAILY, WEEKLY or MONTHLY.")
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 52 {
+				return nil, Errorf(INVALID_ERR, "rule: INTERVAL must be from 1 to 52.")
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day := indexOf(weekdayCodes, code)
+				if day < 0 {
+					return nil, Errorf(INVALID_ERR, "rule: BYDAY must list days like MO,TH.")
+				}
+				r.ByDay = append(r.ByDay, time.Weekday(day))
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, Errorf(INVALID_ERR, "rule: COUNT must be a positive number.")
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+			if err != nil {
+				until, err = time.Parse("20060102", value)
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			if err != nil {
+				return nil, Errorf(INVALID_ERR, "rule: UNTIL must be a date like 20230131.")
+			}
+			r.Until = until
+		default:
+			return nil, Errorf(INVALID_ERR, "rule: %s is not supported.", name)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, Errorf(INVALID_ERR, "rule: FREQ is required.")
+	}
+	if len(r.ByDay) > 0 && r.Freq != FreqWeekly {
+		return nil, Errorf(INVALID_ERR, "rule: BYDAY is only supported with FREQ=WEEKLY.")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, Errorf(INVALID_ERR, "rule: COUNT and UNTIL cannot both be given.")
+	}
+	sort.Slice(r.ByDay, func(i, j int) bool { return mondayFirst(r.ByDay[i]) < mondayFirst(r.ByDay[j]) })
+	return r, nil
+}
+
+// String returns the rule in the syntax ParseRecurrenceRule parses.
+func (r *RecurrenceRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, day := range r.ByDay {
+			days = append(days, weekdayCodes[day])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences returns the occurrences of a series first occurring at start
+// from the time from up to the time to.
+func (r *RecurrenceRule) Occurrences(start, from, to time.Time) []time.Time {
+	var occurrences []time.Time
+	r.each(start, func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			occurrences = append(occurrences, t)
+		}
+		return true
+	})
+	return occurrences
+}
+
+// EndsBefore reports whether a series first occurring at start has no
+// occurrences at or after t.
+func (r *RecurrenceRule) EndsBefore(start, t time.Time) bool {
+	ends := true
+	r.each(start, func(o time.Time) bool {
+		if !o.Before(t) {
+			ends = false
+			return false
+		}
+		return true
+	})
+	return ends
+}
+
+// each calls fn with the occurrences of a series first occurring at start in
+// order until fn returns false or the occurrences run out.
+func (r *RecurrenceRule) each(start time.Time, fn func(time.Time) bool) {
+	count := 0
+	emit := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if r.Count > 0 && count >= r.Count || !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		count++
+		return fn(t)
+	}
+
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		n := period * r.Interval
+		switch {
+		case r.Freq == FreqDaily:
+			if !emit(start.AddDate(0, 0, n)) {
+				return
+			}
+		case r.Freq == FreqWeekly && len(r.ByDay) == 0:
+			if !emit(start.AddDate(0, 0, 7*n)) {
+				return
+			}
+		case r.Freq == FreqWeekly:
+			monday := start.AddDate(0, 0, 7*n-mondayFirst(start.Weekday()))
+			for _, day := range r.ByDay {
+				if !emit(monday.AddDate(0, 0, mondayFirst(day))) {
+					return
+				}
+			}
+		case r.Freq == FreqMonthly:
+			first := time.Date(start.Year(), start.Month()+time.Month(n), 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+			t := first.AddDate(0, 0, start.Day()-1)
+			if t.Month() != first.Month() {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// mondayFirst returns the number of days from Monday to a day of the week.
+func mondayFirst(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,102 @@
+package app_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	rule, err := app.ParseRecurrenceRule("RRULE:FREQ=weekly;INTERVAL=2;BYDAY=TH,MO;COUNT=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rule.String(), "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=4"; got != want {
+		t.Fatalf("rule=%s, want %s", got, want)
+	}
+
+	for _, s := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYDAY=MO",
+		"FREQ=WEEKLY;COUNT=2;UNTIL=20230401",
+		"FREQ=WEEKLY;BYHOUR=9",
+	} {
+		if _, err := app.ParseRecurrenceRule(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestRecurrenceRuleOccurrences(t *testing.T) {
+	format := func(times []time.Time) []string {
+		var s []string
+		for _, t := range times {
+			s = append(s, t.Format(app.DateTimeFormat))
+		}
+		return s
+	}
+	start := at(monday, "09:00")
+
+	for _, tt := range []struct {
+		rule  string
+		start time.Time
+		to    time.Time
+		want  []string
+	}{
+		{
+			rule:  "FREQ=WEEKLY;INTERVAL=2",
+			start: start,
+			to:    monday.AddDate(0, 0, 35),
+			want:  []string{"2023-03-06 09:00:00", "2023-03-20 09:00:00", "2023-04-03 09:00:00"},
+		},
+		{
+			rule:  "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3",
+			start: start,
+			to:    monday.AddDate(1, 0, 0),
+			want:  []string{"2023-03-06 09:00:00", "2023-03-09 09:00:00", "2023-03-13 09:00:00"},
+		},
+		{
+			// Occurrences before the first one are left out.
+			rule:  "FREQ=WEEKLY;BYDAY=MO,FR;UNTIL=20230314",
+			start: at(monday.AddDate(0, 0, 2), "09:00"),
+			to:    monday.AddDate(1, 0, 0),
+			want:  []string{"2023-03-10 09:00:00", "2023-03-13 09:00:00"},
+		},
+		{
+			// Months without a 31st are left out.
+			rule:  "FREQ=MONTHLY",
+			start: time.Date(2023, 1, 31, 9, 0, 0, 0, time.UTC),
+			to:    time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2023-01-31 09:00:00", "2023-03-31 09:00:00", "2023-05-31 09:00:00"},
+		},
+	} {
+		rule, err := app.ParseRecurrenceRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := format(rule.Occurrences(tt.start, tt.start, tt.to)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: occurrences=%v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestRecurrenceRuleEndsBefore(t *testing.T) {
+	start := at(monday, "09:00")
+	rule, _ := app.ParseRecurrenceRule("FREQ=DAILY;COUNT=3")
+	if rule.EndsBefore(start, at(monday.AddDate(0, 0, 2), "08:00")) {
+		t.Error("ends before its last occurrence")
+	}
+	if !rule.EndsBefore(start, at(monday.AddDate(0, 0, 2), "10:00")) {
+		t.Error("does not end after its last occurrence")
+	}
+
+	rule, _ = app.ParseRecurrenceRule("FREQ=WEEKLY")
+	if rule.EndsBefore(start, start.AddDate(5, 0, 0)) {
+		t.Error("a rule without a limit ends")
+	}
+}
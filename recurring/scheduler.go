@@ -0,0 +1,79 @@
+// Package recurring makes the bookings of recurring series ahead of time in
+// the background.
+package recurring
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+)
+
+// DefaultInterval is how often series are checked for bookings to make.
+const DefaultInterval = time.Hour
+
+// Scheduler periodically makes the bookings of active series that come within
+// the horizon of the series service. Several instances may run against the
+// same database: each series is claimed before its bookings are made.
+type Scheduler struct {
+	Series   app.SeriesService
+	Interval time.Duration
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(series app.SeriesService) *Scheduler {
+	return &Scheduler{
+		Series:   series,
+		Interval: DefaultInterval,
+	}
+}
+
+// Open starts running the scheduler in the background.
+func (s *Scheduler) Open() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop()
+	}()
+}
+
+// Close stops the scheduler and waits for the current run to finish.
+func (s *Scheduler) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Run(s.ctx); err != nil && s.ctx.Err() == nil {
+			log.Printf("recurring: run failed: %s", err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run makes the bookings that are due once.
+func (s *Scheduler) Run(ctx context.Context) error {
+	n, err := s.Series.GenerateSeriesBookings(ctx)
+	if n > 0 {
+		log.Printf("recurring: handled %d occurrences", n)
+	}
+	return err
+}
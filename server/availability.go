@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) handleProviderAvailability(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	availability, err := s.AvlSvc.FindAvailability(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, availability)
+}
+
+func (s *Server) handleWorkingHoursUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	hours := model.WeeklyHours{UserID: userID.String()}
+	if err := json.Unmarshal([]byte(r.FormValue("hours")), &hours.Hours); err != nil {
+		handleError(w, "hours: invalid json array value", http.StatusBadRequest)
+		return
+	}
+
+	if err := hours.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.AvlSvc.SetWorkingHours(r.Context(), &hours); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Working hours updated successfully", hours.Hours)
+}
+
+func (s *Server) handleAvailabilityExceptionCreate(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	exception := model.AvailabilityException{
+		UserID:    userID.String(),
+		Date:      r.FormValue("date"),
+		StartTime: r.FormValue("start_time"),
+		EndTime:   r.FormValue("end_time"),
+	}
+
+	if err := exception.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.AvlSvc.CreateAvailabilityException(r.Context(), &exception); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Exception created successfully", exception)
+}
+
+func (s *Server) handleAvailabilityExceptionDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.AvlSvc.DeleteAvailabilityException(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Exception deleted successfully")
+}
+
+func (s *Server) handleBlackoutCreate(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	blackout := model.Blackout{
+		UserID:    userID.String(),
+		StartDate: r.FormValue("start_date"),
+		EndDate:   r.FormValue("end_date"),
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		blackout.Reason = &reason
+	}
+	if blackout.EndDate == "" {
+		blackout.EndDate = blackout.StartDate
+	}
+
+	if err := blackout.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.AvlSvc.CreateBlackout(r.Context(), &blackout); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Blackout created successfully", blackout)
+}
+
+func (s *Server) handleBlackoutDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.AvlSvc.DeleteBlackout(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Blackout deleted successfully")
+}
+
+func (s *Server) handleProviderSlots(w http.ResponseWriter, r *http.Request) {
+	filter := model.SlotFilter{
+		ProviderID: mux.Vars(r)["id"],
+		ServiceID:  r.URL.Query().Get("service_id"),
+		From:       r.URL.Query().Get("from"),
+		To:         r.URL.Query().Get("to"),
+	}
+	if filter.To == "" {
+		filter.To = filter.From
+	}
+
+	if err := filter.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slots, err := s.AvlSvc.ListFreeSlots(r.Context(), &filter)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, slots)
+}
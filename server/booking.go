@@ -3,6 +3,7 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -26,12 +27,11 @@ func (s *Server) handleBookingByID(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.BkSvc.FindBookingByID(r.Context(), id)
 	if err != nil {
-		log.Println(err)
 		if err == sql.ErrNoRows {
 			handleError(w, "Booking not found", http.StatusNotFound)
 			return
 		}
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
@@ -77,12 +77,11 @@ func (s *Server) handleProviderBooking(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.BkSvc.FindProviderBookingByID(r.Context(), id, userId.String())
 	if err != nil {
-		log.Println(err)
 		if err == sql.ErrNoRows {
 			handleError(w, "Booking not found", http.StatusNotFound)
 			return
 		}
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
@@ -140,13 +139,12 @@ func (s *Server) handleBookingCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	booking.StartDate = time.Format("2006-01-02T15:04:05")
+	booking.StartDate = time.Format(app.DateTimeFormat)
 
 	err = s.BkSvc.CreateBooking(r.Context(), &booking)
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
 		if err = handleMysqlErrors(w, err); err != nil {
-			handleError(w, "something went wrong", http.StatusInternalServerError)
+			handleServiceError(w, r, err)
 		}
 		return
 	}
@@ -154,36 +152,179 @@ func (s *Server) handleBookingCreate(w http.ResponseWriter, r *http.Request) {
 	handleSuccessMsgWithRes(w, "Booking created successfully", booking)
 }
 
-func (s *Server) handleBookingComplete(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleBookingAccept(w http.ResponseWriter, r *http.Request) {
+	s.transitionBooking(w, r, app.BookingAccepted, "Booking accepted successfully")
+}
+
+func (s *Server) handleBookingDispute(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("reason") == "" {
+		handleError(w, "reason: non zero value required", http.StatusBadRequest)
+		return
+	}
+	s.transitionBooking(w, r, app.BookingDisputed, "Booking marked disputed successfully")
+}
+
+// transitionBooking moves the booking in the request path to the given status
+// on behalf of the logged in user. An optional reason is read from the form.
+func (s *Server) transitionBooking(w http.ResponseWriter, r *http.Request, status string, msg string) {
 	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
 	if err != nil {
 		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
 		return
 	}
-	err = s.BkSvc.CompleteBooking(r.Context(), bookingId)
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
 	if err != nil {
-		log.Println(err)
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleUnathorised(w)
+		return
+	}
+
+	transition := model.BookingTransition{
+		BookingID: bookingId,
+		Status:    status,
+		ActorID:   userID.String(),
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		transition.Reason = &reason
+	}
+
+	if err := transition.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.BkSvc.TransitionBooking(r.Context(), &transition)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			handleError(w, "Booking not found", http.StatusNotFound)
+			return
+		}
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, msg)
+}
+
+func (s *Server) handleBookingEvents(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
 		return
 	}
 
-	handleSuccess(w, "Booking marked completed successfully")
+	events, err := s.BkSvc.ListBookingEvents(r.Context(), bookingId)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, events)
 }
 
-func (s *Server) handleBookingCancel(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleBookingReschedules(w http.ResponseWriter, r *http.Request) {
 	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
 	if err != nil {
 		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
 		return
 	}
-	err = s.BkSvc.CancelBooking(r.Context(), bookingId)
+
+	reschedules, err := s.BkSvc.ListReschedules(r.Context(), bookingId)
 	if err != nil {
-		log.Println(err)
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
-	handleSuccess(w, "Booking marked cancelled successfully")
+	handleSuccess(w, reschedules)
+}
+
+func (s *Server) handleBookingRescheduleCreate(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	proposal := model.RescheduleProposal{
+		BookingID: bookingId,
+		ActorID:   userID.String(),
+		StartDate: r.FormValue("start_date"),
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		proposal.Reason = &reason
+	}
+
+	if err := proposal.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, err := dateparse.ParseStrict(proposal.StartDate)
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "start_date: invalid date format", http.StatusBadRequest)
+		return
+	}
+	proposal.StartDate = start.Format(app.DateTimeFormat)
+
+	if err := s.BkSvc.ProposeReschedule(r.Context(), &proposal); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Reschedule proposed successfully", proposal)
+}
+
+func (s *Server) handleBookingRescheduleAccept(w http.ResponseWriter, r *http.Request) {
+	s.decideReschedule(w, r, true, "Booking rescheduled successfully")
+}
+
+func (s *Server) handleBookingRescheduleDecline(w http.ResponseWriter, r *http.Request) {
+	s.decideReschedule(w, r, false, "Reschedule declined successfully")
+}
+
+// decideReschedule accepts or declines a proposal to move a booking on behalf
+// of the logged in user.
+func (s *Server) decideReschedule(w http.ResponseWriter, r *http.Request, accept bool, msg string) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	rescheduleId, err := strconv.Atoi(mux.Vars(r)["reschedule_id"])
+	if err != nil {
+		handleError(w, "reschedule_id: must be a number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.BkSvc.DecideReschedule(r.Context(), &model.RescheduleDecision{
+		BookingID:    bookingId,
+		RescheduleID: rescheduleId,
+		ActorID:      userID.String(),
+		Accept:       accept,
+	})
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, msg)
 }
 
 /*
@@ -209,7 +350,6 @@ func retrieveFirebaseUserData(ctx context.Context, uid string) *auth.UserRecord
 func (s *Server) handleRequestCreate(w http.ResponseWriter, r *http.Request) {
 	var request model.Request
 	request.ID = uuid.New()
-	request.Status = "bidding"
 
 	userID, err := middlewares.UserIDFromContext(r.Context())
 	// Return an error if the user is not currently logged in.
@@ -220,13 +360,62 @@ func (s *Server) handleRequestCreate(w http.ResponseWriter, r *http.Request) {
 
 	request.ClientID = userID.String()
 
+	if !parseRequestForm(w, r, &request) {
+		return
+	}
+
+	err = s.ReqSvc.CreateRequest(r.Context(), &request)
+	if err != nil {
+		if err = handleMysqlErrors(w, err); err != nil {
+			handleServiceError(w, r, err)
+		}
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Request created successfully", request)
+}
+
+func (s *Server) handleRequestUpdate(w http.ResponseWriter, r *http.Request) {
+	var request model.Request
+	var err error
+	request.ID, err = uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	request.ClientID = userID.String()
+
+	if !parseRequestForm(w, r, &request) {
+		return
+	}
+
+	err = s.ReqSvc.UpdateRequest(r.Context(), &request)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Request updated successfully", request)
+}
+
+// parseRequestForm reads the fields of a request from the form and validates
+// them. It writes the error response and returns false if they are invalid.
+func parseRequestForm(w http.ResponseWriter, r *http.Request, request *model.Request) bool {
 	var photos []string
 	photoData := r.PostFormValue("photos")
 	if photoData != "" {
-		err = json.Unmarshal([]byte(photoData), &photos)
+		err := json.Unmarshal([]byte(photoData), &photos)
 		if err != nil {
 			handleError(w, "photos: invalid json array value", http.StatusBadRequest)
-			return
+			return false
 		}
 		request.Photos = photos
 	}
@@ -235,49 +424,98 @@ func (s *Server) handleRequestCreate(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Marshall error: %s %s: %s", r.Method, r.URL.Path, err)
 		handleError(w, "error parsing form values", http.StatusInternalServerError)
-		return
+		return false
 	}
 
-	if err := json.Unmarshal(jsonStr, &request); err != nil {
+	if err := json.Unmarshal(jsonStr, request); err != nil {
 		log.Printf("Unmarshal error: %s %s: %s", r.Method, r.URL.Path, err)
 		handleError(w, "error parsing json string", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// if start_date is not set, infer as urgent and set StartDate to 24 hours
 	if request.StartDate == "" {
-		if err == nil {
-			request.Urgent = true
-			request.StartDate = time.Now().Add(time.Hour * 24).Format("2006-01-02T15:04:05")
-		}
+		request.Urgent = true
+		request.StartDate = time.Now().Add(time.Hour * 24).Format("2006-01-02T15:04:05")
 	} else {
 		// validate start date
 		time, err := dateparse.ParseStrict(request.StartDate)
 		if err != nil {
 			log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
 			handleError(w, "start_date: invalid date format", http.StatusBadRequest)
-			return
+			return false
 		}
 
 		request.StartDate = time.Format("2006-01-02T15:04:05")
 	}
 
-	err = request.Validate()
-	if err != nil {
+	if err := request.Validate(); err != nil {
 		handleError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleRequestCancel(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
 		return
 	}
 
-	err = s.ReqSvc.CreateRequest(r.Context(), &request)
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-		if err = handleMysqlErrors(w, err); err != nil {
-			handleError(w, "something went wrong", http.StatusInternalServerError)
+		handleUnathorised(w)
+		return
+	}
+
+	cancellation := model.BookingCancellation{
+		BookingID: id,
+		ActorID:   userID.String(),
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		cancellation.Reason = &reason
+	}
+	if v := r.FormValue("accept_fee"); v != "" {
+		fee, err := strconv.Atoi(v)
+		if err != nil {
+			handleError(w, "accept_fee: must be a whole number", http.StatusBadRequest)
+			return
 		}
+		cancellation.AcceptedFee = &fee
+	}
+
+	decision, err := s.ReqSvc.CancelRequest(r.Context(), &cancellation)
+	if err != nil {
+		handleServiceError(w, r, err)
 		return
 	}
 
-	handleSuccessMsgWithRes(w, "Request created successfully", request)
+	handleSuccessMsgWithRes(w, "Request cancelled successfully", decision)
+}
+
+func (s *Server) handleRequestReopen(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.ReqSvc.ReopenRequest(r.Context(), id, userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Request re-opened successfully")
 }
 
 func (s *Server) handleRequestList(w http.ResponseWriter, r *http.Request) {
@@ -328,19 +566,19 @@ func (s *Server) handleAllRequests(w http.ResponseWriter, r *http.Request) {
 	handleSuccess(w, requests)
 }
 
+// handleRecommendedRequests returns the requests open for bidding ranked for
+// the logged in provider, with the factors of their scores.
 func (s *Server) handleRecommendedRequests(w http.ResponseWriter, r *http.Request) {
-
-	filter := model.RequestFilter{}
-
 	userId, err := middlewares.UserIDFromContext(r.Context())
-	if err == nil {
-		filter.UserID = userId.String()
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
 	}
 
-	requests, err := s.ReqSvc.AllRequests(r.Context(), filter)
+	requests, err := s.RecSvc.RecommendRequests(r.Context(), userId.String())
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
@@ -492,6 +730,11 @@ func (s *Server) handleBidCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if bid.ETA, bid.ExpiresAt, err = parseBidTimes(r); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := bid.Validate(); err != nil {
 		handleError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -499,12 +742,86 @@ func (s *Server) handleBidCreate(w http.ResponseWriter, r *http.Request) {
 
 	err = s.BidSvc.CreateBid(r.Context(), &bid)
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
-	handleSuccessMsgWithRes(w, "Booking created successfully", bid)
+	handleSuccessMsgWithRes(w, "Bid created successfully", bid)
+}
+
+// handleBidUpdate revises the amount, message, ETA and expiry of a bid.
+func (s *Server) handleBidUpdate(w http.ResponseWriter, r *http.Request) {
+	bidId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid integer", http.StatusBadRequest)
+		return
+	}
+
+	update := model.BidUpdate{
+		ID:     bidId,
+		Amount: r.FormValue("amount"),
+	}
+	if v := r.FormValue("message"); v != "" {
+		update.Message = &v
+	}
+	if update.ETA, update.ExpiresAt, err = parseBidTimes(r); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := update.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.BidSvc.UpdateBid(r.Context(), &update)
+	if err == sql.ErrNoRows {
+		handleError(w, "Bid not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Bid updated successfully")
+}
+
+func (s *Server) handleBidWithdraw(w http.ResponseWriter, r *http.Request) {
+	bidId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid integer", http.StatusBadRequest)
+		return
+	}
+
+	err = s.BidSvc.WithdrawBid(r.Context(), bidId)
+	if err == sql.ErrNoRows {
+		handleError(w, "Bid not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Bid withdrawn successfully")
+}
+
+// parseBidTimes parses the optional eta and expires_at of a bid.
+func parseBidTimes(r *http.Request) (eta *time.Time, expiresAt *time.Time, err error) {
+	if v := r.FormValue("eta"); v != "" {
+		t, err := dateparse.ParseStrict(v)
+		if err != nil {
+			return nil, nil, errors.New("eta: invalid date format")
+		}
+		eta = &t
+	}
+	if v := r.FormValue("expires_at"); v != "" {
+		t, err := dateparse.ParseStrict(v)
+		if err != nil {
+			return nil, nil, errors.New("expires_at: invalid date format")
+		}
+		expiresAt = &t
+	}
+	return eta, expiresAt, nil
 }
 
 func (s *Server) handleMyBids(w http.ResponseWriter, r *http.Request) {
@@ -550,12 +867,20 @@ func (s *Server) handleAcceptBid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: check if bid exists
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
 
-	err = s.BidSvc.AcceptBid(r.Context(), bidId)
+	err = s.BidSvc.AcceptBid(r.Context(), bidId, userID.String())
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-		handleError(w, "Something went wrong", http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			handleError(w, "Bid not found", http.StatusNotFound)
+			return
+		}
+		handleServiceError(w, r, err)
 		return
 	}
 
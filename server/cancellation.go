@@ -0,0 +1,215 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// handleBookingCancellation returns what cancelling the booking now would
+// cost the client, so they can accept the fee before cancelling. Providers
+// pass no_show=true to see the fee of reporting a no-show.
+func (s *Server) handleBookingCancellation(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	cancellation := model.BookingCancellation{
+		BookingID: bookingId,
+		ActorID:   userID.String(),
+		NoShow:    r.URL.Query().Get("no_show") == "true",
+	}
+
+	decision, err := s.BkSvc.QuoteCancellation(r.Context(), &cancellation)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, decision)
+}
+
+func (s *Server) handleBookingCancel(w http.ResponseWriter, r *http.Request) {
+	s.cancelBooking(w, r, false, "Booking marked cancelled successfully")
+}
+
+func (s *Server) handleBookingNoShow(w http.ResponseWriter, r *http.Request) {
+	s.cancelBooking(w, r, true, "Booking marked as a no-show successfully")
+}
+
+// cancelBooking cancels the booking in the request path on behalf of the
+// logged in user. The client accepts a cancellation fee with accept_fee.
+func (s *Server) cancelBooking(w http.ResponseWriter, r *http.Request, noShow bool, msg string) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	cancellation := model.BookingCancellation{
+		BookingID: bookingId,
+		ActorID:   userID.String(),
+		NoShow:    noShow,
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		cancellation.Reason = &reason
+	}
+	if v := r.FormValue("accept_fee"); v != "" {
+		fee, err := strconv.Atoi(v)
+		if err != nil {
+			handleError(w, "accept_fee: must be a whole number", http.StatusBadRequest)
+			return
+		}
+		cancellation.AcceptedFee = &fee
+	}
+
+	if err := cancellation.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := s.BkSvc.CancelBooking(r.Context(), &cancellation)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, msg, decision)
+}
+
+func (s *Server) handleProviderCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	policy, err := s.CanSvc.FindProviderCancellationPolicy(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, policy)
+}
+
+func (s *Server) handleProviderCancellationPolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	policy, msg := parseCancellationPolicy(r)
+	if msg != "" {
+		handleError(w, msg, http.StatusBadRequest)
+		return
+	}
+	policy.UserID = userID.String()
+
+	if err := policy.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.CanSvc.SetProviderCancellationPolicy(r.Context(), policy); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Cancellation policy updated successfully", policy)
+}
+
+func (s *Server) handleProviderCancellationPolicyDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	if err := s.CanSvc.DeleteProviderCancellationPolicy(r.Context(), userID.String()); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Cancellation policy deleted successfully")
+}
+
+func (s *Server) handleCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := s.CanSvc.ListCancellationPolicies(r.Context())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, policies)
+}
+
+// handleCancellationPolicyUpdate sets the cancellation policy of a
+// category_id, or the default policy when none is given.
+func (s *Server) handleCancellationPolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	policy, msg := parseCancellationPolicy(r)
+	if msg != "" {
+		handleError(w, msg, http.StatusBadRequest)
+		return
+	}
+	if v := r.FormValue("category_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			handleError(w, "category_id: must be a whole number", http.StatusBadRequest)
+			return
+		}
+		policy.CategoryID = &id
+	}
+
+	if err := policy.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.CanSvc.SetCancellationPolicy(r.Context(), policy); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Cancellation policy updated successfully", policy)
+}
+
+// parseCancellationPolicy reads the terms of a cancellation policy from the
+// form, returning a message if one is malformed.
+func parseCancellationPolicy(r *http.Request) (*model.CancellationPolicy, string) {
+	var policy model.CancellationPolicy
+
+	hours, err := strconv.Atoi(r.FormValue("free_hours"))
+	if err != nil {
+		return nil, "free_hours: must be a whole number"
+	}
+	policy.FreeHours = hours
+
+	for key, field := range map[string]*float64{"fee_percent": &policy.FeePercent, "no_show_percent": &policy.NoShowPercent} {
+		percent, err := strconv.ParseFloat(r.FormValue(key), 64)
+		if err != nil {
+			return nil, key + ": must be a number"
+		}
+		*field = percent
+	}
+	return &policy, ""
+}
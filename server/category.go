@@ -47,6 +47,24 @@ func (s *Server) handleCategoriesRoot(w http.ResponseWriter, r *http.Request) {
 	handleSuccess(w, categories)
 }
 
+// handleCategorySuggestions suggests categories for a request from its title.
+func (s *Server) handleCategorySuggestions(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		handleError(w, "title: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	categories, err := s.CatSvc.SuggestCategories(r.Context(), title)
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	handleSuccess(w, categories)
+}
+
 func (s *Server) handleCategoryCreate(w http.ResponseWriter, r *http.Request) {
 	var category model.Category
 
@@ -1,9 +1,13 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	app "github.com/andrwkng/hudumaapp"
 )
 
 // allFormValues returns a map that contains all the form values.
@@ -96,3 +100,40 @@ func handleUnathorised(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 	w.Write(jsonResp)
 }
+
+// handleServiceError writes the response for an error returned by a service.
+// Application errors are reported with their message and a status code
+// matching their error code; any other error is an internal error.
+func handleServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+
+	var appErr *app.Error
+	switch {
+	case errors.As(err, &appErr):
+		handleError(w, appErr.Message, errorStatusCode(appErr.Code))
+	case err == sql.ErrNoRows:
+		handleError(w, "Not found", http.StatusNotFound)
+	default:
+		handleError(w, "Something went wrong", http.StatusInternalServerError)
+	}
+}
+
+// errorStatusCode maps an application error code to an HTTP status code.
+func errorStatusCode(code string) int {
+	switch code {
+	case app.INVALID_ERR:
+		return http.StatusBadRequest
+	case app.UNAUTHORIZED_ERR:
+		return http.StatusUnauthorized
+	case app.FORBIDDEN_ERR:
+		return http.StatusForbidden
+	case app.NOTFOUND_ERR:
+		return http.StatusNotFound
+	case app.CONFLICT_ERR:
+		return http.StatusConflict
+	case app.RATELIMIT_ERR:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
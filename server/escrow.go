@@ -0,0 +1,112 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) handleBookingEscrow(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	escrow, err := s.EscSvc.FindEscrowByBookingID(r.Context(), bookingId)
+	if err == sql.ErrNoRows {
+		handleError(w, "The booking has no payment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, escrow)
+}
+
+// handleBookingPay asks the client to pay an accepted booking into escrow.
+func (s *Server) handleBookingPay(w http.ResponseWriter, r *http.Request) {
+	if s.PaySvc == nil {
+		handleError(w, "Payments are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	// The payment prompt goes to the account's phone unless another is given.
+	phone := r.FormValue("phone")
+	if phone == "" {
+		phone = ptrToStr(middlewares.PhoneFromContext(r.Context()))
+	}
+
+	payment, err := s.PaySvc.RequestBookingPayment(r.Context(), mux.Vars(r)["id"], userID.String(), phone)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, payment.Message, payment)
+}
+
+// handleMpesaB2CResult records the outcome of a withdrawal or refund. Like the other
+// callbacks, results of payouts that are unknown or already recorded are
+// acknowledged so that Daraja stops retrying them.
+func (s *Server) handleMpesaB2CResult(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyCallback(r) {
+		handleError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var callback mpesa.B2CResult
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json body", http.StatusBadRequest)
+		return
+	}
+
+	result := &model.PayoutResult{
+		Reference:  callback.Result.ConversationID,
+		ResultCode: callback.Result.ResultCode,
+		ResultDesc: callback.Result.ResultDesc,
+	}
+	if result.ResultCode == 0 {
+		result.Receipt = callback.Result.TransactionID
+	}
+
+	err := s.WalSvc.CompletePayout(r.Context(), result)
+	var appErr *app.Error
+	if errors.As(err, &appErr) {
+		log.Printf("[mpesa] %s %s: %s", r.Method, r.URL.Path, appErr.Message)
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	writeCallbackResponse(w, r, mpesa.Accepted)
+}
+
+// handleMpesaB2CTimeout acknowledges a payout that timed out in the M-Pesa
+// queue. Its result still arrives at the result URL.
+func (s *Server) handleMpesaB2CTimeout(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyCallback(r) {
+		handleError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	log.Printf("[mpesa] payout timed out in the queue")
+	writeCallbackResponse(w, r, mpesa.Accepted)
+}
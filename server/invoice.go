@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/andrwkng/hudumaapp/invoice"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) handleMyInvoices(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	invoices, err := s.InvSvc.ListInvoices(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, invoices)
+}
+
+// handleInvoice returns an invoice or receipt as JSON, or as a PDF download
+// when asked for with format=pdf or an Accept header of application/pdf.
+func (s *Server) handleInvoice(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	inv, err := s.InvSvc.FindInvoiceByID(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Invoice not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "application/pdf") {
+		format = "pdf"
+	}
+	switch format {
+	case "", "json":
+		handleSuccess(w, inv)
+	case "pdf":
+		var buf bytes.Buffer
+		if err := invoice.WritePDF(&buf, inv, s.InvoiceIssuer); err != nil {
+			handleServiceError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+invoice.Filename(inv)+`"`)
+		buf.WriteTo(w)
+	default:
+		handleError(w, "format must be json or pdf", http.StatusBadRequest)
+	}
+}
@@ -7,6 +7,11 @@ import (
 	app "github.com/andrwkng/hudumaapp"
 )
 
+// NewContextWithUser returns a new context with the given user attached.
+func NewContextWithUser(ctx context.Context, user app.AuthUser) context.Context {
+	return context.WithValue(ctx, accessKeyAuthToken, user)
+}
+
 // UserFromContext returns the current logged in user.
 func UserFromContext(ctx context.Context) *app.AuthUser {
 	user, ok := ctx.Value(accessKeyAuthToken).(app.AuthUser)
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
+)
+
+// paymentMethodBrands are the display names and logos of payment methods.
+var paymentMethodBrands = map[string]struct {
+	Name string
+	Logo string
+}{
+	"mpesa": {
+		Name: "Lipa na M-PESA",
+		Logo: "https://mpasho254.files.wordpress.com/2018/11/mpesa.png",
+	},
+	"visa": {
+		Name: "Visa",
+		Logo: "https://e7.pngegg.com/pngimages/594/666/png-clipart-visa-logo-credit-card-debit-card-payment-card-bank-visa-blue-text.png",
+	},
+	"mastercard": {
+		Name: "Mastercard",
+	},
+}
+
+// describePaymentMethods sets the display name and logo of payment methods.
+func describePaymentMethods(methods []*app.PaymentMethod) {
+	for _, method := range methods {
+		brand := paymentMethodBrands[method.Method]
+		method.Name, method.Logo = brand.Name, brand.Logo
+	}
+}
+
+func (s *Server) handlePaymentMethods(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	methods, err := s.PmSvc.ListPaymentMethods(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+	describePaymentMethods(methods)
+
+	handleSuccess(w, methods)
+}
+
+// handlePaymentMethodAdd adds an M-Pesa number or a tokenized card.
+func (s *Server) handlePaymentMethodAdd(w http.ResponseWriter, r *http.Request) {
+	s.addPaymentMethod(w, r, "")
+}
+
+func (s *Server) handleAddMpesaPayment(w http.ResponseWriter, r *http.Request) {
+	s.addPaymentMethod(w, r, "mpesa")
+}
+
+func (s *Server) addPaymentMethod(w http.ResponseWriter, r *http.Request, method string) {
+	var paymentMethod model.PaymentMethod
+
+	jsonStr, err := json.Marshal(allFormValues(r))
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing form values", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.Unmarshal(jsonStr, &paymentMethod); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json string", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+	paymentMethod.UserID = userID.String()
+	if method != "" {
+		paymentMethod.Method = method
+	}
+
+	if err := paymentMethod.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Phone numbers are stored in the international format of account phone
+	// numbers.
+	if paymentMethod.Type() == app.MobilePaymentMethod {
+		msisdn, err := mpesa.FormatPhone(paymentMethod.PhoneNumber)
+		if err != nil {
+			handleError(w, "phone_number: must be a Kenyan phone number", http.StatusBadRequest)
+			return
+		}
+		paymentMethod.PhoneNumber = "+" + msisdn
+	}
+
+	if err := s.PmSvc.AddPaymentMethod(r.Context(), &paymentMethod); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	added, err := s.PmSvc.FindPaymentMethodByID(r.Context(), paymentMethod.ID, paymentMethod.UserID)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+	describePaymentMethods([]*app.PaymentMethod{added})
+
+	if !added.Verified {
+		handleSuccessMsgWithRes(w, "Enter the code sent to "+added.Number+" to verify the payment method", added)
+		return
+	}
+	handleSuccessMsgWithRes(w, "Payment method added successfuly", added)
+}
+
+func (s *Server) handleDeletePaymentMethods(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.PmSvc.DeletePaymentMethod(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Payment method not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Payment method deleted successfuly")
+}
+
+func (s *Server) handlePaymentMethodDefault(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.PmSvc.SetDefaultPaymentMethod(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Payment method not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Default payment method updated successfuly")
+}
+
+// handlePaymentMethodVerificationSend sends a new code to an unverified
+// M-Pesa number.
+func (s *Server) handlePaymentMethodVerificationSend(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	err = s.PmSvc.SendPaymentMethodCode(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Payment method not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Verification code sent")
+}
+
+func (s *Server) handlePaymentMethodVerificationConfirm(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		handleError(w, "code: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	err = s.PmSvc.VerifyPaymentMethod(r.Context(), mux.Vars(r)["id"], userID.String(), code)
+	if err == sql.ErrNoRows {
+		handleError(w, "Payment method not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Payment method verified successfuly")
+}
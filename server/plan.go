@@ -5,88 +5,91 @@ import (
 	"log"
 	"net/http"
 
-	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/gorilla/mux"
 )
 
-var plan = app.Plan{
-	ID:           "98975604-428c-4335-9474-b99110b2d019",
-	Name:         "Weekly",
-	Description:  "24km distance",
-	Price:        199,
-	Currency:     "Ksh",
-	Interval:     1,
-	IntervalUnit: "week",
+func (s *Server) handlePlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := s.PlanSvc.ListPlans(r.Context(), false)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, plans)
 }
 
-var plans = []app.Plan{
-	plan,
-	{
-		ID:           "098974ab-441c-4aa9-bf9c-40c6a95bdb5d",
-		Name:         "Monthly",
-		Description:  "24km distance + distance",
-		Price:        999,
-		Currency:     "Ksh",
-		Interval:     1,
-		IntervalUnit: "month",
-	},
-	{
-		ID:           "3363ace9-4c7e-482b-8f3b-ae66f7f88f62",
-		Name:         "Yearly",
-		Description:  "24km distance + distance + premium",
-		Price:        999,
-		Currency:     "Ksh",
-		Interval:     1,
-		IntervalUnit: "year",
-	},
+// handleAdminPlans lists all plans, including archived ones.
+func (s *Server) handleAdminPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := s.PlanSvc.ListPlans(r.Context(), true)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, plans)
 }
 
-func (s *Server) handlePlans(w http.ResponseWriter, r *http.Request) {
-	/*
-		plans, err := s.PlanSvc.GetAllPlans(r.Context())
-		if err != nil {
-			log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-			handleError(w, "Something went wrong", http.StatusInternalServerError)
-			return
-		}
-
-		handleSuccess(w, plans)
-	*/
-	/*
-		plans = append(plans, app.Plan{
-			ID:           "98975604-428c-4335-9474-b99110b2d019",
-			Name:         "Weekly",
-			Description:  "24km distance",
-			Price:        199,
-			Currency:     "Ksh",
-			Interval:     1,
-			IntervalUnit: "week",
-		})
-
-		plans = append(plans, app.Plan{
-			ID:           "098974ab-441c-4aa9-bf9c-40c6a95bdb5d",
-			Name:         "Monthly",
-			Description:  "24km distance + distance",
-			Price:        999,
-			Currency:     "Ksh",
-			Interval:     1,
-			IntervalUnit: "month",
-		})
-
-		plans = append(plans, app.Plan{
-			ID:           "3363ace9-4c7e-482b-8f3b-ae66f7f88f62",
-			Name:         "Yearly",
-			Description:  "24km distance + distance + premium",
-			Price:        999,
-			Currency:     "Ksh",
-			Interval:     1,
-			IntervalUnit: "year",
-		})
-	*/
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
-	if err := json.NewEncoder(w).Encode(plans); err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+func (s *Server) handlePlanCreate(w http.ResponseWriter, r *http.Request) {
+	plan, ok := parsePlan(w, r)
+	if !ok {
 		return
 	}
+
+	if err := s.PlanSvc.CreatePlan(r.Context(), plan); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Plan created successfully", plan)
+}
+
+func (s *Server) handlePlanUpdate(w http.ResponseWriter, r *http.Request) {
+	plan, ok := parsePlan(w, r)
+	if !ok {
+		return
+	}
+	plan.ID = mux.Vars(r)["id"]
+
+	if err := s.PlanSvc.UpdatePlan(r.Context(), plan); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Plan updated successfully", plan)
+}
+
+func (s *Server) handlePlanArchive(w http.ResponseWriter, r *http.Request) {
+	if err := s.PlanSvc.ArchivePlan(r.Context(), mux.Vars(r)["id"]); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Plan archived successfully")
+}
+
+// parsePlan reads and validates the plan in the form values of a request. It
+// writes the error response and returns false if the plan is invalid.
+func parsePlan(w http.ResponseWriter, r *http.Request) (*model.Plan, bool) {
+	var plan model.Plan
+
+	jsonStr, err := json.Marshal(allFormValues(r))
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "something went wrong", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if err := json.Unmarshal(jsonStr, &plan); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json string", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if err := plan.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &plan, true
 }
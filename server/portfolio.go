@@ -291,30 +291,27 @@ func generatedAccountID() string {
 	return uuid.New().String()
 }
 
+// handlePasswordNew sets a new password for the logged in user. The user must
+// prove they own their phone with a code from /user/password/forgot.
 func (s *Server) handlePasswordNew(w http.ResponseWriter, r *http.Request) {
-	newPassWord := r.FormValue("new_password")
-	if newPassWord == "" {
-		handleError(w, "New password is required", http.StatusBadRequest)
+	phone, ok := s.userPhone(w, r)
+	if !ok {
 		return
 	}
 
-	userID, err := middlewares.UserIDFromContext(r.Context())
-	// Return an error if the user is not currently logged in.
-	if err != nil {
-		handleUnathorised(w)
+	reset := model.PasswordReset{
+		Phone:       phone,
+		Code:        r.FormValue("code"),
+		NewPassword: r.FormValue("new_password"),
+	}
+	if err := reset.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Println("[http]", r.Method, r.URL.Path, "userID:", userID, "new_password:["+newPassWord+"]")
-
-	err = s.UsrSvc.ResetUserPassword(r.Context(), newPassWord, userID.String())
+	err := s.VerSvc.ResetPassword(r.Context(), &reset)
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
-		if err == sql.ErrNoRows {
-			handleError(w, "Failed to reset password", http.StatusNotFound)
-			return
-		}
-		handleError(w, "something went wrong", http.StatusInternalServerError)
+		handleServiceError(w, r, err)
 		return
 	}
 
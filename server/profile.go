@@ -12,6 +12,7 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/mattn/go-sqlite3"
 )
 
 func (s *Server) handleProfileGet(w http.ResponseWriter, r *http.Request) {
@@ -287,6 +288,9 @@ func (s *Server) handleProviderPortfolios(w http.ResponseWriter, r *http.Request
 }
 
 func handleMysqlErrors(w http.ResponseWriter, err error) error {
+	if se, ok := err.(sqlite3.Error); ok {
+		return handleSqliteErrors(w, se)
+	}
 	me, ok := err.(*mysql.MySQLError)
 	if !ok {
 		return err
@@ -302,6 +306,18 @@ func handleMysqlErrors(w http.ResponseWriter, err error) error {
 	return err
 }
 
+func handleSqliteErrors(w http.ResponseWriter, se sqlite3.Error) error {
+	switch se.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		handleError(w, "duplicate entry", http.StatusConflict)
+		return nil
+	case sqlite3.ErrConstraintForeignKey:
+		handleError(w, "foreign key constraint fail", http.StatusNotFound)
+		return nil
+	}
+	return se
+}
+
 func (s *Server) handleProviderList(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var providers []*app.ProviderBrief
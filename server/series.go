@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) handleSeriesList(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	series, err := s.SerSvc.ListSeries(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, series)
+}
+
+func (s *Server) handleSeriesCreate(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	series := model.BookingSeries{
+		ClientID:   userID.String(),
+		ProviderID: r.FormValue("provider_id"),
+		ServiceID:  r.FormValue("service_id"),
+		LocationID: r.FormValue("location_id"),
+		StartDate:  r.FormValue("start_date"),
+		Frequency:  r.FormValue("frequency"),
+		Rule:       r.FormValue("rule"),
+	}
+
+	if err := series.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.SerSvc.CreateSeries(r.Context(), &series); err != nil {
+		if err = handleMysqlErrors(w, err); err != nil {
+			handleServiceError(w, r, err)
+		}
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Series created successfully", series)
+}
+
+func (s *Server) handleSeriesByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	series, err := s.SerSvc.FindSeriesByID(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, series)
+}
+
+func (s *Server) handleSeriesSkip(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	date := r.FormValue("date")
+	if date == "" {
+		handleError(w, "date: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	err = s.SerSvc.SkipOccurrence(r.Context(), mux.Vars(r)["id"], date, userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Occurrence skipped successfully")
+}
+
+func (s *Server) handleSeriesCancel(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	var reason *string
+	if v := r.FormValue("reason"); v != "" {
+		reason = &v
+	}
+
+	err = s.SerSvc.CancelSeries(r.Context(), mux.Vars(r)["id"], userID.String(), reason)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Series cancelled successfully")
+}
@@ -27,6 +27,27 @@ type Server struct {
 	SrchSvc app.SearchService
 	PlanSvc app.PlanService
 	SubSvc  app.SubscriptionService
+	VerSvc  app.VerificationService
+	PaySvc  app.PaymentService
+	PmSvc   app.PaymentMethodService
+	TxnSvc  app.TransactionService
+	EscSvc  app.EscrowService
+	WalSvc  app.WalletService
+	InvSvc  app.InvoiceService
+	RecSvc  app.RecommendationService
+	AvlSvc  app.AvailabilityService
+	SerSvc  app.SeriesService
+	CanSvc  app.CancellationPolicyService
+	// InvoiceIssuer is the business name printed on invoices.
+	InvoiceIssuer string
+	// CallbackSecret and CallbackAllowedIPs verify that payment callbacks
+	// come from M-Pesa.
+	CallbackSecret     string
+	CallbackAllowedIPs []string
+	// Auth verifies the access tokens of authenticated routes. Tokens is only
+	// set when the server issues its own tokens.
+	Auth   app.Authenticator
+	Tokens app.TokenIssuer
 }
 
 func New() *Server {
@@ -39,25 +60,25 @@ func New() *Server {
 	s.router.HandleFunc("/user", s.handleUserCreate).Methods("POST")
 	s.router.HandleFunc("/user", s.handleUserGet).Methods("GET")
 	s.router.HandleFunc("/user/validate", s.handleUserValidate).Methods("POST")
-	// TEMP
-	s.router.HandleFunc("/subscription", s.handleMyActiveSubscription).Methods("GET")
-	s.router.HandleFunc("/subscriptions", s.handleSubscribe).Methods("POST")
-	s.router.HandleFunc("/subscriptions", s.handleMySubscriptions).Methods("GET")
-	s.router.HandleFunc("/subscriptions/{id}", s.handleCancelSubscription).Methods("DELETE")
-
-	s.router.HandleFunc("/transactions/confirm", s.handleTransactionConfirm).Methods("GET")
-	s.router.HandleFunc("/transactions/validate", s.handleTransactionConfirm).Methods("GET")
+	s.router.HandleFunc("/user/refresh", s.handleTokenRefresh).Methods("POST")
+	s.router.HandleFunc("/user/password/forgot", s.handlePasswordForgot).Methods("POST")
+	s.router.HandleFunc("/user/password/reset", s.handlePasswordReset).Methods("POST")
+	// M-Pesa callbacks
+	s.router.HandleFunc("/transactions/confirm", s.handleMpesaConfirm).Methods("POST")
+	s.router.HandleFunc("/transactions/validate", s.handleMpesaValidate).Methods("POST")
+	s.router.HandleFunc("/payments/mpesa/stk", s.handleMpesaConfirm).Methods("POST")
+	s.router.HandleFunc("/payments/mpesa/c2b/confirm", s.handleMpesaConfirm).Methods("POST")
+	s.router.HandleFunc("/payments/mpesa/c2b/validate", s.handleMpesaValidate).Methods("POST")
+	s.router.HandleFunc("/payments/mpesa/b2c/result", s.handleMpesaB2CResult).Methods("POST")
+	s.router.HandleFunc("/payments/mpesa/b2c/timeout", s.handleMpesaB2CTimeout).Methods("POST")
 	s.router.HandleFunc("/plans", s.handlePlans).Methods("GET")
-	s.router.HandleFunc("/payment-methods", s.handlePaymentMethods).Methods("GET")
-	s.router.HandleFunc("/payment-methods/{id}", s.handleDeletePaymentMethods).Methods("DELETE")
-	s.router.HandleFunc("/payment-methods/mpesa", s.handleAddMpesaPayment).Methods("POST")
 
 	// Tesing
 	s.router.HandleFunc("/test", s.handleTest).Methods("GET", "POST")
 	s.testingRoutes(s.router)
 
 	r := s.router.PathPrefix("/").Subrouter()
-	r.Use(middlewares.AuthHandler)
+	r.Use(s.authenticate)
 	s.registerRoutes(r)
 	return s
 }
@@ -81,6 +102,8 @@ func (s *Server) testingRoutes(r *mux.Router) {
 func (s *Server) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/user/password", s.handlePasswordNew).Methods("POST")
 	r.HandleFunc("/user/password", s.handlePasswordChange).Methods("PUT")
+	r.HandleFunc("/user/verification", s.handleVerificationSend).Methods("POST")
+	r.HandleFunc("/user/verification/confirm", s.handleVerificationConfirm).Methods("POST")
 	r.HandleFunc("/user/{id}", s.handleUserByID).Methods("GET")
 	// Profile
 	//r.HandleFunc("/profile", s.handleProfileCreate).Methods("POST")
@@ -90,6 +113,19 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	//r.HandleFunc("/profile/{id}", s.handleProfileDelete).Methods("DELETE")
 	// Providers
 	r.HandleFunc("/provider", s.handleProviderGet).Methods("GET")
+	r.HandleFunc("/provider/wallet", s.handleProviderWallet).Methods("GET")
+	r.HandleFunc("/provider/payouts", s.handleProviderPayouts).Methods("GET")
+	r.HandleFunc("/provider/payouts", s.handleProviderPayoutRequest).Methods("POST")
+	r.HandleFunc("/provider/payouts/{id}", s.handleProviderPayout).Methods("GET")
+	r.HandleFunc("/provider/availability", s.handleProviderAvailability).Methods("GET")
+	r.HandleFunc("/provider/availability/hours", s.handleWorkingHoursUpdate).Methods("PUT")
+	r.HandleFunc("/provider/availability/exceptions", s.handleAvailabilityExceptionCreate).Methods("POST")
+	r.HandleFunc("/provider/availability/exceptions/{id}", s.handleAvailabilityExceptionDelete).Methods("DELETE")
+	r.HandleFunc("/provider/availability/blackouts", s.handleBlackoutCreate).Methods("POST")
+	r.HandleFunc("/provider/availability/blackouts/{id}", s.handleBlackoutDelete).Methods("DELETE")
+	r.HandleFunc("/provider/cancellation-policy", s.handleProviderCancellationPolicy).Methods("GET")
+	r.HandleFunc("/provider/cancellation-policy", s.handleProviderCancellationPolicyUpdate).Methods("PUT")
+	r.HandleFunc("/provider/cancellation-policy", s.handleProviderCancellationPolicyDelete).Methods("DELETE")
 	r.HandleFunc("/providers", s.handleProviderList).Methods("GET")
 	r.HandleFunc("/top-providers", s.handleProviderList).Methods("GET")
 	r.HandleFunc("/providers/{id}", s.handleProviderByID).Methods("GET")
@@ -99,6 +135,7 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/providers/{id}/portfolios", s.handleProviderPortfolios).Methods("GET")
 	r.HandleFunc("/providers/{id}/bookings", s.handleProviderBookings).Methods("GET")
 	r.HandleFunc("/providers/{id}/bookings/{id}", s.handleProviderBooking).Methods("GET")
+	r.HandleFunc("/providers/{id}/slots", s.handleProviderSlots).Methods("GET")
 	// Locations
 	r.HandleFunc("/locations", s.handleMyLocations).Methods("GET")
 	r.HandleFunc("/locations", s.handleLocationCreate).Methods("POST")
@@ -107,6 +144,7 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/categories", s.handleCategoriesList).Methods("GET")
 	r.HandleFunc("/categories", s.handleCategoryCreate).Methods("POST")
 	r.HandleFunc("/categories/root", s.handleCategoriesRoot).Methods("GET")
+	r.HandleFunc("/categories/suggestions", s.handleCategorySuggestions).Methods("GET")
 	// Industries
 	r.HandleFunc("/industries", s.handleIndustriesList).Methods("GET")
 	r.HandleFunc("/industries", s.handleIndustryCreate).Methods("POST")
@@ -120,7 +158,9 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/requests", s.handleRequestList).Methods("GET")
 	r.HandleFunc("/requests", s.handleRequestCreate).Methods("POST")
 	r.HandleFunc("/requests/{id}", s.handleRequest).Methods("GET")
-	//r.HandleFunc("/requests/{id}/cancel", s.handleRequestCancel).Methods("PUT")
+	r.HandleFunc("/requests/{id}", s.handleRequestUpdate).Methods("PUT")
+	r.HandleFunc("/requests/{id}/cancel", s.handleRequestCancel).Methods("PUT")
+	r.HandleFunc("/requests/{id}/reopen", s.handleRequestReopen).Methods("PUT")
 	r.HandleFunc("/requests/{id}/bids", s.handleRequestBids).Methods("GET")
 	// All requests
 	r.HandleFunc("/all-requests", s.handleAllRequests).Methods("GET")
@@ -134,13 +174,34 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/bookings", s.handleBookingCreate).Methods("POST")
 	//r.HandleFunc("/bookings/{id}", s.handleBookingUpdate).Methods("PUT")
 	//r.HandleFunc("/bookings/{id}", s.handleBookingDelete).Methods("DELETE")
+	r.HandleFunc("/bookings/{id}/accept", s.handleBookingAccept).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/check-in", s.handleBookingCheckIn).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/check-out", s.handleBookingCheckOut).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/visit", s.handleBookingVisit).Methods("GET")
 	r.HandleFunc("/bookings/{id}/complete", s.handleBookingComplete).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/cancellation", s.handleBookingCancellation).Methods("GET")
 	r.HandleFunc("/bookings/{id}/cancel", s.handleBookingCancel).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/no-show", s.handleBookingNoShow).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/dispute", s.handleBookingDispute).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/events", s.handleBookingEvents).Methods("GET")
+	r.HandleFunc("/bookings/{id}/reschedules", s.handleBookingReschedules).Methods("GET")
+	r.HandleFunc("/bookings/{id}/reschedules", s.handleBookingRescheduleCreate).Methods("POST")
+	r.HandleFunc("/bookings/{id}/reschedules/{reschedule_id}/accept", s.handleBookingRescheduleAccept).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/reschedules/{reschedule_id}/decline", s.handleBookingRescheduleDecline).Methods("PUT")
+	r.HandleFunc("/bookings/{id}/escrow", s.handleBookingEscrow).Methods("GET")
+	r.HandleFunc("/bookings/{id}/pay", s.handleBookingPay).Methods("POST")
+	// Recurring bookings
+	r.HandleFunc("/series", s.handleSeriesList).Methods("GET")
+	r.HandleFunc("/series", s.handleSeriesCreate).Methods("POST")
+	r.HandleFunc("/series/{id}", s.handleSeriesByID).Methods("GET")
+	r.HandleFunc("/series/{id}/skip", s.handleSeriesSkip).Methods("PUT")
+	r.HandleFunc("/series/{id}/cancel", s.handleSeriesCancel).Methods("PUT")
 	// Bids
 	r.HandleFunc("/bids", s.handleBidCreate).Methods("POST")
 	r.HandleFunc("/bids", s.handleMyBids).Methods("GET")
+	r.HandleFunc("/bids/{id}", s.handleBidUpdate).Methods("PUT")
 	r.HandleFunc("/bids/{id}/accept", s.handleAcceptBid).Methods("PUT")
-	//r.HandleFunc("/bids/{id}/cancel", s.handleCancelBid).Methods("DELETE")
+	r.HandleFunc("/bids/{id}/withdraw", s.handleBidWithdraw).Methods("PUT")
 	// Portfolios
 	r.HandleFunc("/portfolios", s.handleMyPortfolio).Methods("GET")
 	r.HandleFunc("/portfolios", s.handlePortfolioCreate).Methods("POST")
@@ -148,16 +209,46 @@ func (s *Server) registerRoutes(r *mux.Router) {
 	// Search
 	r.HandleFunc("/search", s.handleSearch).Methods("GET")
 	// Transactions
+	r.HandleFunc("/transactions", s.handleMyTransactions).Methods("GET")
+	r.HandleFunc("/transactions/{id}", s.handleTransaction).Methods("GET")
+	r.HandleFunc("/invoices", s.handleMyInvoices).Methods("GET")
+	r.HandleFunc("/invoices/{id}", s.handleInvoice).Methods("GET")
 	// Payment options
+	r.HandleFunc("/payment-methods", s.handlePaymentMethods).Methods("GET")
+	r.HandleFunc("/payment-methods", s.handlePaymentMethodAdd).Methods("POST")
+	r.HandleFunc("/payment-methods/mpesa", s.handleAddMpesaPayment).Methods("POST")
+	r.HandleFunc("/payment-methods/{id}", s.handleDeletePaymentMethods).Methods("DELETE")
+	r.HandleFunc("/payment-methods/{id}/default", s.handlePaymentMethodDefault).Methods("PUT")
+	r.HandleFunc("/payment-methods/{id}/verification", s.handlePaymentMethodVerificationSend).Methods("POST")
+	r.HandleFunc("/payment-methods/{id}/verification/confirm", s.handlePaymentMethodVerificationConfirm).Methods("POST")
 	// Preferences
 	//r.HandleFunc("/preferences", s.handlePreferenceList).Methods("GET")
 	//r.HandleFunc("/preferences", s.handlePreferenceCreate).Methods("POST")
 	// Subscriptions
-
+	r.HandleFunc("/subscription", s.handleMyActiveSubscription).Methods("GET")
+	r.HandleFunc("/subscriptions", s.handleSubscribe).Methods("POST")
+	r.HandleFunc("/subscriptions", s.handleMySubscriptions).Methods("GET")
 	r.HandleFunc("/subscriptions/{id}", s.handleSubscription).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}", s.handleSubscriptionCancel).Methods("DELETE")
 	r.HandleFunc("/subscriptions/{id}/cancel", s.handleSubscriptionCancel).Methods("POST")
 	// Plans
 	r.HandleFunc("/plans", s.handlePlans).Methods("GET")
+	r.HandleFunc("/admin/plans", s.handleAdminPlans).Methods("GET")
+	r.HandleFunc("/admin/plans", s.handlePlanCreate).Methods("POST")
+	r.HandleFunc("/admin/plans/{id}", s.handlePlanUpdate).Methods("PUT")
+	r.HandleFunc("/admin/plans/{id}", s.handlePlanArchive).Methods("DELETE")
+	r.HandleFunc("/admin/commissions", s.handleCommissionRates).Methods("GET")
+	r.HandleFunc("/admin/commissions", s.handleCommissionRateUpdate).Methods("PUT")
+	r.HandleFunc("/admin/cancellation-policies", s.handleCancellationPolicies).Methods("GET")
+	r.HandleFunc("/admin/cancellation-policies", s.handleCancellationPolicyUpdate).Methods("PUT")
+}
+
+// authenticate requires a valid access token on the wrapped routes. The
+// authenticator is looked up per request as it is set after New returns.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middlewares.AuthHandler(s.Auth, next).ServeHTTP(w, r)
+	})
 }
 
 func (s *Server) handleTest(w http.ResponseWriter, r *http.Request) {
@@ -1,18 +1,263 @@
 package server
 
 import (
-	"fmt"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"time"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
 )
 
-func (s *Server) handleTransactionConfirm(w http.ResponseWriter, r *http.Request) {
+// handleMyTransactions lists the payment history of the user. It can be
+// narrowed down by status, direction and a from and to date (YYYY-MM-DD),
+// both inclusive.
+func (s *Server) handleMyTransactions(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
 
-	r.ParseForm()
+	query := r.URL.Query()
+	filter := model.TransactionFilter{
+		UserID:    userID.String(),
+		Status:    query.Get("status"),
+		Direction: query.Get("direction"),
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			handleError(w, "from must be a date in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		filter.From = &from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			handleError(w, "to must be a date in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		// Include the whole of the last day.
+		to = to.Add(24*time.Hour - time.Second)
+		filter.To = &to
+	}
+	if filter.From != nil && filter.To != nil && filter.To.Before(*filter.From) {
+		handleError(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
 
-	fmt.Println("Confirm transaction")
+	transactions, err := s.TxnSvc.ListTransactions(r.Context(), filter)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
 
-	for k, v := range r.Form {
-		fmt.Printf("%s = %s\n", k, v)
+	handleSuccess(w, transactions)
+}
+
+func (s *Server) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	transaction, err := s.TxnSvc.FindTransactionByID(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Transaction not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
 	}
 
+	handleSuccess(w, transaction)
+}
+
+// handleMpesaConfirm records the outcome of an M-Pesa payment. It accepts
+// both STK push callbacks and C2B confirmations. Daraja retries callbacks
+// that are not acknowledged, so repeated callbacks are acknowledged without
+// being recorded again.
+func (s *Server) handleMpesaConfirm(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyCallback(r) {
+		handleError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if s.PaySvc == nil {
+		handleError(w, "Payments are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var payload struct {
+		mpesa.STKCallback
+		mpesa.C2BCallback
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json body", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch {
+	case payload.Body.StkCallback.CheckoutRequestID != "":
+		err = s.completeSTKPayment(r, &payload.STKCallback)
+	case payload.TransID != "":
+		var payment *model.C2BPayment
+		if payment, err = c2bPayment(&payload.C2BCallback); err == nil {
+			err = s.PaySvc.ConfirmC2BPayment(r.Context(), payment)
+		}
+	default:
+		handleError(w, "unknown callback", http.StatusBadRequest)
+		return
+	}
+
+	// Payments that cannot be matched are logged for reconciliation rather
+	// than retried by Daraja.
+	var appErr *app.Error
+	if errors.As(err, &appErr) {
+		log.Printf("[mpesa] %s %s: %s", r.Method, r.URL.Path, appErr.Message)
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	writeCallbackResponse(w, r, mpesa.Accepted)
+}
+
+// handleMpesaValidate accepts a C2B payment only if its account number belongs
+// to a payment we requested and it pays at least the amount requested.
+func (s *Server) handleMpesaValidate(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyCallback(r) {
+		handleError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if s.PaySvc == nil {
+		handleError(w, "Payments are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var callback mpesa.C2BCallback
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json body", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := c2bPayment(&callback)
+	if err == nil {
+		err = s.PaySvc.ValidateC2BPayment(r.Context(), payment)
+	}
+
+	var appErr *app.Error
+	switch {
+	case err == nil:
+		writeCallbackResponse(w, r, mpesa.Accepted)
+	case errors.As(err, &appErr) && appErr.Code == app.NOTFOUND_ERR:
+		writeCallbackResponse(w, r, mpesa.RejectedAccount)
+	case errors.As(err, &appErr) && appErr.Code == app.INVALID_ERR:
+		writeCallbackResponse(w, r, mpesa.RejectedAmount)
+	default:
+		handleServiceError(w, r, err)
+	}
+}
+
+func (s *Server) completeSTKPayment(r *http.Request, callback *mpesa.STKCallback) error {
+	result := &model.STKResult{
+		CheckoutRequestID: callback.Body.StkCallback.CheckoutRequestID,
+		ResultCode:        callback.Body.StkCallback.ResultCode,
+		ResultDesc:        callback.Body.StkCallback.ResultDesc,
+	}
+	if result.ResultCode == 0 {
+		amount, err := callback.Amount()
+		if err != nil {
+			return app.Errorf(app.INVALID_ERR, "Payment request %s: %s", result.CheckoutRequestID, err)
+		}
+		result.Amount = amount
+		result.Receipt = callback.Item("MpesaReceiptNumber")
+		result.Phone = callback.Item("PhoneNumber")
+	}
+	return s.PaySvc.CompleteSTKPayment(r.Context(), result)
+}
+
+func c2bPayment(callback *mpesa.C2BCallback) (*model.C2BPayment, error) {
+	amount, err := callback.Amount()
+	if err != nil {
+		return nil, app.Errorf(app.INVALID_ERR, "Payment %s: %s", callback.TransID, err)
+	}
+	return &model.C2BPayment{
+		TransID:          callback.TransID,
+		AccountReference: callback.BillRefNumber,
+		Amount:           amount,
+		Phone:            callback.MSISDN,
+	}, nil
+}
+
+func writeCallbackResponse(w http.ResponseWriter, r *http.Request, resp mpesa.CallbackResponse) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+	}
+}
+
+// verifyCallback reports whether a payment callback comes from M-Pesa.
+// Daraja does not sign callbacks, so the registered URLs carry a shared
+// secret in the secret query parameter, and requests can be restricted to the
+// addresses Safaricom sends them from. Callbacks are refused when neither is
+// configured.
+func (s *Server) verifyCallback(r *http.Request) bool {
+	if s.CallbackSecret == "" && len(s.CallbackAllowedIPs) == 0 {
+		log.Printf("[mpesa] refusing callback %s: no callback secret or allowed IPs configured", r.URL.Path)
+		return false
+	}
+
+	if s.CallbackSecret != "" {
+		secret := r.URL.Query().Get("secret")
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(s.CallbackSecret)) != 1 {
+			log.Printf("[mpesa] refusing callback %s from %s: wrong secret", r.URL.Path, r.RemoteAddr)
+			return false
+		}
+	}
+
+	if len(s.CallbackAllowedIPs) > 0 && !ipAllowed(r.RemoteAddr, s.CallbackAllowedIPs) {
+		log.Printf("[mpesa] refusing callback %s from %s: address not allowed", r.URL.Path, r.RemoteAddr)
+		return false
+	}
+
+	return true
+}
+
+// ipAllowed reports whether the host of addr is one of the allowed IP
+// addresses or within one of the allowed CIDR ranges.
+func ipAllowed(addr string, allowed []string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, a := range allowed {
+		if _, network, err := net.ParseCIDR(a); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(a); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -43,19 +43,50 @@ func (s *Server) handleUserValidate(w http.ResponseWriter, r *http.Request) {
 	//usr.Phone = govalidator.Trim(usr.Phone, "")
 	//usr.Password = govalidator.Trim(usr.Password, "")
 
-	log.Println("[LOG] phone: [" + usr.Phone + "] password: [" + usr.Password + "]")
-
-	err = s.UsrSvc.ValidateUser(r.Context(), usr.Phone, usr.Password, usr.IsProvider)
+	validUser, err := s.UsrSvc.ValidateUser(r.Context(), usr.Phone, usr.Password, usr.IsProvider)
 	if err != nil {
-		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
 		if err == sql.ErrNoRows {
+			log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
 			handleError(w, "Incorrect phone number or password", http.StatusUnauthorized)
 			return
 		}
+		handleServiceError(w, r, err)
+		return
+	}
+
+	// Firebase clients sign in with Firebase, everyone else gets our tokens.
+	if s.Tokens == nil {
+		handleSuccessMsg(w, "User is valid")
+		return
+	}
+
+	tokens, err := s.Tokens.IssueTokens(r.Context(), validUser)
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
 		handleError(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
-	handleSuccessMsg(w, "User is valid")
+	handleSuccessMsgWithRes(w, "User is valid", tokens)
+}
+
+func (s *Server) handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	if s.Tokens == nil {
+		handleError(w, "Token refresh is not supported", http.StatusNotFound)
+		return
+	}
+
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		handleError(w, "refresh_token: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.Tokens.RefreshTokens(r.Context(), refreshToken)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
 
+	handleSuccess(w, tokens)
 }
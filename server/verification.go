@@ -0,0 +1,125 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	app "github.com/andrwkng/hudumaapp"
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+)
+
+// handleVerificationSend sends a code to the logged in user's phone.
+func (s *Server) handleVerificationSend(w http.ResponseWriter, r *http.Request) {
+	phone, ok := s.userPhone(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.VerSvc.SendCode(r.Context(), phone, app.VerifyPhonePurpose)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Verification code sent")
+}
+
+// handleVerificationConfirm marks the logged in user's phone as verified.
+func (s *Server) handleVerificationConfirm(w http.ResponseWriter, r *http.Request) {
+	phone, ok := s.userPhone(w, r)
+	if !ok {
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		handleError(w, "code: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.VerSvc.VerifyPhone(r.Context(), phone, code)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Phone number verified successfuly")
+}
+
+// handlePasswordForgot sends a password reset code to a phone number. It
+// succeeds whether or not the number has an account.
+func (s *Server) handlePasswordForgot(w http.ResponseWriter, r *http.Request) {
+	phone := r.FormValue("phone")
+	if phone == "" {
+		handleError(w, "phone: non zero value required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.VerSvc.SendCode(r.Context(), phone, app.ResetPasswordPurpose)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "If the phone number is registered a reset code has been sent")
+}
+
+func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	var reset model.PasswordReset
+
+	jsonStr, err := json.Marshal(allFormValues(r))
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing form values", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.Unmarshal(jsonStr, &reset); err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		handleError(w, "error parsing json string", http.StatusInternalServerError)
+		return
+	}
+
+	if err := reset.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.VerSvc.ResetPassword(r.Context(), &reset)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Password reset successfuly")
+}
+
+// userPhone returns the phone number of the logged in user. It writes the
+// error response and returns false if there is none.
+func (s *Server) userPhone(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	// Return an error if the user is not currently logged in.
+	if err != nil {
+		handleUnathorised(w)
+		return "", false
+	}
+
+	usr, err := s.UsrSvc.FindUserByID(r.Context(), userID.String())
+	if err != nil {
+		log.Printf("[http] error: %s %s: %s", r.Method, r.URL.Path, err)
+		if err == sql.ErrNoRows {
+			handleError(w, "User not found", http.StatusNotFound)
+			return "", false
+		}
+		handleError(w, "something went wrong", http.StatusInternalServerError)
+		return "", false
+	}
+	if usr.Phone == nil {
+		handleError(w, "User has no phone number", http.StatusBadRequest)
+		return "", false
+	}
+	return *usr.Phone, true
+}
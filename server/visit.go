@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) handleBookingCheckIn(w http.ResponseWriter, r *http.Request) {
+	s.checkpointBooking(w, r, s.BkSvc.CheckIn, "Checked in successfully")
+}
+
+func (s *Server) handleBookingCheckOut(w http.ResponseWriter, r *http.Request) {
+	s.checkpointBooking(w, r, s.BkSvc.CheckOut, "Checked out successfully")
+}
+
+// checkpointBooking checks the logged in provider in or out of the booking in
+// the request path at the latitude and longitude in the form.
+func (s *Server) checkpointBooking(w http.ResponseWriter, r *http.Request, fn func(context.Context, *model.BookingCheckpoint) error, msg string) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	checkpoint := model.BookingCheckpoint{
+		BookingID: bookingId,
+		ActorID:   userID.String(),
+		Latitude:  r.FormValue("latitude"),
+		Longitude: r.FormValue("longitude"),
+	}
+
+	if err := checkpoint.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := fn(r.Context(), &checkpoint); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, msg)
+}
+
+// handleBookingComplete completes the booking in the request path. Clients
+// confirm by tapping, providers with the code the client gives them.
+func (s *Server) handleBookingComplete(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	completion := model.BookingCompletion{
+		BookingID: bookingId,
+		ActorID:   userID.String(),
+	}
+	if code := r.FormValue("code"); code != "" {
+		completion.Code = &code
+	}
+
+	if err := completion.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.BkSvc.CompleteBooking(r.Context(), &completion); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsg(w, "Booking marked completed successfully")
+}
+
+func (s *Server) handleBookingVisit(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, "Id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+
+	visit, err := s.BkSvc.FindBookingVisit(r.Context(), bookingId)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, visit)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/andrwkng/hudumaapp/model"
+	"github.com/andrwkng/hudumaapp/payments/mpesa"
+	"github.com/andrwkng/hudumaapp/server/middlewares"
+	"github.com/gorilla/mux"
+)
+
+// handleProviderWallet shows the earnings of the provider: what can be
+// withdrawn, what is still held in escrow, and the totals earned and paid
+// out.
+func (s *Server) handleProviderWallet(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	wallet, err := s.WalSvc.FindWallet(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, wallet)
+}
+
+func (s *Server) handleProviderPayouts(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	payouts, err := s.WalSvc.ListPayouts(r.Context(), userID.String())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, payouts)
+}
+
+func (s *Server) handleProviderPayout(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	payout, err := s.WalSvc.FindPayoutByID(r.Context(), mux.Vars(r)["id"], userID.String())
+	if err == sql.ErrNoRows {
+		handleError(w, "Payout not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, payout)
+}
+
+// handleProviderPayoutRequest withdraws earnings to an M-Pesa number, the
+// account's phone unless a verified M-Pesa payment method is given. Without an
+// amount the whole available balance is withdrawn.
+func (s *Server) handleProviderPayoutRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := middlewares.UserIDFromContext(r.Context())
+	if err != nil {
+		handleUnathorised(w)
+		return
+	}
+
+	request := model.PayoutRequest{
+		UserID: userID.String(),
+		Phone:  r.FormValue("phone"),
+	}
+	if v := r.FormValue("amount"); v != "" {
+		if request.Amount, err = strconv.Atoi(v); err != nil {
+			handleError(w, "amount: must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Phone == "" {
+		request.Phone = ptrToStr(middlewares.PhoneFromContext(r.Context()))
+	}
+	if request.Phone != "" {
+		msisdn, err := mpesa.FormatPhone(request.Phone)
+		if err != nil {
+			handleError(w, "phone: must be a Kenyan phone number", http.StatusBadRequest)
+			return
+		}
+		request.Phone = "+" + msisdn
+	}
+
+	if err := request.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.WalSvc.RequestPayout(r.Context(), &request); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	payout, err := s.WalSvc.FindPayoutByID(r.Context(), request.ID, request.UserID)
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Payout requested successfully", payout)
+}
+
+func (s *Server) handleCommissionRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.WalSvc.ListCommissionRates(r.Context())
+	if err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccess(w, rates)
+}
+
+// handleCommissionRateUpdate sets the commission rate of a category_id or an
+// industry_id, or the default rate when neither is given.
+func (s *Server) handleCommissionRateUpdate(w http.ResponseWriter, r *http.Request) {
+	var rate model.CommissionRate
+
+	percent, err := strconv.ParseFloat(r.FormValue("percent"), 64)
+	if err != nil {
+		handleError(w, "percent: must be a number", http.StatusBadRequest)
+		return
+	}
+	rate.Percent = percent
+
+	for key, field := range map[string]**int{"category_id": &rate.CategoryID, "industry_id": &rate.IndustryID} {
+		v := r.FormValue(key)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			handleError(w, key+": must be a whole number", http.StatusBadRequest)
+			return
+		}
+		*field = &id
+	}
+
+	if err := rate.Validate(); err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.WalSvc.SetCommissionRate(r.Context(), &rate); err != nil {
+		handleServiceError(w, r, err)
+		return
+	}
+
+	handleSuccessMsgWithRes(w, "Commission rate updated successfully", rate)
+}